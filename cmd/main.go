@@ -9,8 +9,12 @@ import (
 	"medea/pkg/config"
 	cmdApp "medea/serve/app"
 	"medea/serve/client"
+	"medea/serve/gc"
 	"medea/serve/http"
+	"medea/serve/key"
 	"medea/serve/migrate"
+	"medea/serve/stats"
+	"medea/serve/storage"
 
 	"medea/pkg/log"
 
@@ -110,6 +114,10 @@ func main() {
 	commands = append(commands, cmdApp.Commands...)
 	commands = append(commands, client.Commands...)
 	commands = append(commands, http.Commands...)
+	commands = append(commands, key.Commands...)
+	commands = append(commands, gc.Commands...)
+	commands = append(commands, storage.Commands...)
+	commands = append(commands, stats.Commands...)
 	app.Commands = commands
 
 	sort.Sort(cli.FlagsByName(app.Flags))
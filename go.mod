@@ -5,9 +5,11 @@ go 1.18
 require (
 	github.com/gin-contrib/cors v1.3.0
 	github.com/gin-gonic/gin v1.4.1-0.20190805014259-20440b96b9ab
+	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/gookit/color v1.1.10
 	github.com/jinzhu/gorm v1.9.10
 	github.com/json-iterator/go v1.1.7
+	github.com/klauspost/compress v1.15.15
 	github.com/magiconair/properties v1.8.1
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/olekukonko/tablewriter v0.0.1
@@ -29,7 +31,6 @@ require (
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.14.0 // indirect
 	github.com/go-playground/universal-translator v0.18.0 // indirect
-	github.com/go-playground/validator v9.31.0+incompatible // indirect
 	github.com/go-sql-driver/mysql v1.4.1 // indirect
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
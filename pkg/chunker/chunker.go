@@ -0,0 +1,187 @@
+package chunker
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	Fixed   = "fixed"
+	FastCDC = "fastcdc"
+)
+
+var (
+	ErrUnknownStrategy = errors.New("unknown chunking strategy")
+	ErrInvalidSizes    = errors.New("chunk sizes must satisfy 0 < min <= avg <= max")
+)
+
+type Options struct {
+	Strategy string
+	MinSize  int
+	AvgSize  int
+	MaxSize  int
+}
+
+// Chunker splits a stream into chunks, Next returns io.EOF after the last
+// chunk. The returned slice is only valid until the next call of Next.
+type Chunker interface {
+	Next() ([]byte, error)
+}
+
+func New(reader io.Reader, opts Options) (Chunker, error) {
+	switch opts.Strategy {
+	case "", Fixed:
+		if opts.MaxSize <= 0 {
+			return nil, ErrInvalidSizes
+		}
+		return &fixedChunker{reader: reader, buf: make([]byte, opts.MaxSize)}, nil
+	case FastCDC:
+		if opts.MinSize <= 0 || opts.MinSize > opts.AvgSize || opts.AvgSize > opts.MaxSize {
+			return nil, ErrInvalidSizes
+		}
+		return newCDCChunker(reader, opts), nil
+	default:
+		return nil, ErrUnknownStrategy
+	}
+}
+
+// IsCut reports whether the chunker cuts at the end of chunk whatever content
+// follows it, chunk must start at a cut.
+func IsCut(chunk []byte, opts Options) bool {
+	if len(chunk) >= opts.MaxSize {
+		return true
+	}
+	if opts.Strategy != FastCDC {
+		return false
+	}
+	// the cut of a byte more only depends on the bytes before it
+	return newCDCChunker(nil, opts).cut(append(chunk[:len(chunk):len(chunk)], 0)) == len(chunk)
+}
+
+type fixedChunker struct {
+	reader io.Reader
+	buf    []byte
+	eof    bool
+}
+
+func (fc *fixedChunker) Next() ([]byte, error) {
+	if fc.eof {
+		return nil, io.EOF
+	}
+	n, err := io.ReadFull(fc.reader, fc.buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		fc.eof = true
+		if n == 0 {
+			return nil, io.EOF
+		}
+		return fc.buf[:n], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fc.buf[:n], nil
+}
+
+// cdcChunker implements FastCDC with normalized chunking: a gear rolling hash
+// is checked against a harder mask before the average size and an easier one
+// after it, so that chunk sizes concentrate around the average.
+type cdcChunker struct {
+	reader     io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+	minSize    int
+	avgSize    int
+	maxSize    int
+	maskS      uint64
+	maskL      uint64
+}
+
+func newCDCChunker(reader io.Reader, opts Options) *cdcChunker {
+	var avgBits = bits.Len(uint(opts.AvgSize)) - 1
+
+	return &cdcChunker{
+		reader:  reader,
+		buf:     make([]byte, opts.MaxSize),
+		minSize: opts.MinSize,
+		avgSize: opts.AvgSize,
+		maxSize: opts.MaxSize,
+		maskS:   topBitsMask(avgBits + 1),
+		maskL:   topBitsMask(avgBits - 1),
+	}
+}
+
+func topBitsMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n > 64 {
+		n = 64
+	}
+	return ^uint64(0) << uint(64-n)
+}
+
+func (cc *cdcChunker) fill() error {
+	if cc.eof || cc.end-cc.start >= cc.maxSize {
+		return nil
+	}
+	copy(cc.buf, cc.buf[cc.start:cc.end])
+	cc.end -= cc.start
+	cc.start = 0
+
+	n, err := io.ReadFull(cc.reader, cc.buf[cc.end:])
+	cc.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		cc.eof = true
+		return nil
+	}
+	return err
+}
+
+func (cc *cdcChunker) Next() ([]byte, error) {
+	if err := cc.fill(); err != nil {
+		return nil, err
+	}
+	if cc.start == cc.end {
+		return nil, io.EOF
+	}
+
+	size := cc.cut(cc.buf[cc.start:cc.end])
+	chunk := cc.buf[cc.start : cc.start+size]
+	cc.start += size
+	return chunk, nil
+}
+
+func (cc *cdcChunker) cut(data []byte) int {
+	var (
+		fp     uint64
+		n      = len(data)
+		normal = cc.avgSize
+		i      = cc.minSize
+	)
+
+	if n <= cc.minSize {
+		return n
+	}
+	if n > cc.maxSize {
+		n = cc.maxSize
+	}
+	if n < normal {
+		normal = n
+	}
+
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&cc.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&cc.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
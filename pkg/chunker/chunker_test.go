@@ -0,0 +1,155 @@
+package chunker
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var cdcOptions = Options{Strategy: FastCDC, MinSize: 2 << 10, AvgSize: 8 << 10, MaxSize: 32 << 10}
+
+func randomContent(size int, seed int64) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(content)
+	return content
+}
+
+func split(t *testing.T, content []byte, opts Options) [][]byte {
+	c, err := New(bytes.NewReader(content), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Strategy: Fixed},
+		{Strategy: FastCDC, MinSize: 0, AvgSize: 8, MaxSize: 16},
+		{Strategy: FastCDC, MinSize: 16, AvgSize: 8, MaxSize: 32},
+		{Strategy: FastCDC, MinSize: 4, AvgSize: 32, MaxSize: 16},
+	} {
+		if _, err := New(nil, opts); err != ErrInvalidSizes {
+			t.Errorf("%+v returns %v", opts, err)
+		}
+	}
+	if _, err := New(nil, Options{Strategy: "rabin", MaxSize: 16}); err != ErrUnknownStrategy {
+		t.Errorf("an unknown strategy returns %v", err)
+	}
+}
+
+func TestFixedChunker(t *testing.T) {
+	var (
+		content = randomContent(100, 1)
+		chunks  = split(t, content, Options{Strategy: Fixed, MaxSize: 32})
+	)
+	if len(chunks) != 4 {
+		t.Fatalf("%d chunks, expected 4", len(chunks))
+	}
+	for i, chunk := range chunks[:3] {
+		if len(chunk) != 32 {
+			t.Errorf("chunk %d has %d bytes", i, len(chunk))
+		}
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), content) {
+		t.Error("chunks don't join into the content")
+	}
+	if chunks = split(t, nil, Options{MaxSize: 32}); len(chunks) != 0 {
+		t.Errorf("empty content is split into %d chunks", len(chunks))
+	}
+}
+
+// TestCDCSizeBounds checks that every chunk but the last lies within min and
+// max, including content without any cut point, which is cut at max.
+func TestCDCSizeBounds(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"random": randomContent(1<<20, 2),
+		"zeros":  make([]byte, 200<<10),
+	} {
+		chunks := split(t, content, cdcOptions)
+		if !bytes.Equal(bytes.Join(chunks, nil), content) {
+			t.Fatalf("%s: chunks don't join into the content", name)
+		}
+		for i, chunk := range chunks {
+			if len(chunk) > cdcOptions.MaxSize {
+				t.Errorf("%s: chunk %d has %d bytes, more than max", name, i, len(chunk))
+			}
+			if i < len(chunks)-1 && len(chunk) < cdcOptions.MinSize {
+				t.Errorf("%s: chunk %d has %d bytes, less than min", name, i, len(chunk))
+			}
+		}
+	}
+
+	chunks := split(t, randomContent(cdcOptions.MinSize-1, 3), cdcOptions)
+	if len(chunks) != 1 {
+		t.Errorf("content shorter than min is split into %d chunks", len(chunks))
+	}
+}
+
+// TestCDCBoundaryStability checks that bytes inserted into the content only
+// change the chunks around them, the others are cut at the same boundaries.
+func TestCDCBoundaryStability(t *testing.T) {
+	var (
+		content  = randomContent(1<<20, 4)
+		offset   = len(content) / 2
+		inserted = append(append(append([]byte(nil), content[:offset]...), randomContent(100, 5)...), content[offset:]...)
+		before   = split(t, content, cdcOptions)
+		after    = split(t, inserted, cdcOptions)
+		hashes   = make(map[string]bool, len(before))
+		changed  int
+	)
+
+	for _, chunk := range before {
+		hashes[string(chunk)] = true
+	}
+	for _, chunk := range after {
+		if !hashes[string(chunk)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Fatalf("%d of %d chunks changed after an insertion", changed, len(after))
+	}
+
+	// chunks before the insertion are untouched
+	for i, chunk := range before {
+		if len(bytes.Join(before[:i+1], nil)) > offset {
+			break
+		}
+		if !bytes.Equal(chunk, after[i]) {
+			t.Fatalf("chunk %d before the insertion changed", i)
+		}
+	}
+}
+
+func TestIsCut(t *testing.T) {
+	var (
+		content = randomContent(1<<20, 6)
+		chunks  = split(t, content, cdcOptions)
+	)
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if !IsCut(chunk, cdcOptions) {
+			t.Fatalf("chunk %d isn't a cut", i)
+		}
+		if IsCut(chunk[:len(chunk)-1], cdcOptions) && len(chunk)-1 > cdcOptions.MinSize {
+			t.Fatalf("chunk %d is cut before its end", i)
+		}
+	}
+
+	fixed := Options{Strategy: Fixed, MaxSize: 32}
+	if !IsCut(content[:32], fixed) || IsCut(content[:31], fixed) {
+		t.Error("only full chunks are cuts under fixed size chunking")
+	}
+}
@@ -0,0 +1,17 @@
+package chunker
+
+// gear maps every byte to a random 64 bits value. It is generated by
+// splitmix64 from a fixed seed, changing it would move every chunk boundary
+// and defeat the deduplication of already stored chunks.
+var gear [256]uint64
+
+func init() {
+	var seed uint64 = 0x6d65646561
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
@@ -0,0 +1,229 @@
+package compact
+
+import (
+	"fmt"
+	"time"
+
+	"medea/pkg/database/models"
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+type Options struct {
+	// a closed pack is rewritten if at least DeadRatio of it is dead
+	DeadRatio float64
+	// retired packs are deleted after Grace, as they may still be read
+	Grace     time.Duration
+	BatchSize int
+	DryRun    bool
+	RootPath  *string
+}
+
+type Failure struct {
+	PackID  uint64
+	ChunkID uint64
+	Detail  string
+}
+
+type Report struct {
+	DryRun         bool
+	Packs          int64
+	Compacted      int64
+	Chunks         int64
+	CopiedBytes    int64
+	Deleted        int64
+	ReclaimedBytes int64
+	Failures       []Failure
+}
+
+type compactor struct {
+	db     *gorm.DB
+	opts   Options
+	roots  []*storage.Root
+	report *Report
+}
+
+// Run copies the live chunks of the closed packs which are mostly dead to the
+// current pack, the packs are retired then. Retired packs older than grace
+// are deleted.
+func Run(db *gorm.DB, opts Options) (report *Report, err error) {
+	if opts.DeadRatio <= 0 {
+		opts.DeadRatio = 0.5
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	c := &compactor{db: db, opts: opts, report: &Report{DryRun: opts.DryRun}}
+	if c.roots, err = models.ChunkRoots(opts.RootPath); err != nil {
+		return nil, err
+	}
+	if err = c.deleteRetired(); err != nil {
+		return c.report, err
+	}
+	if !opts.DryRun {
+		stop := models.StartPacker(db)
+		defer stop()
+	}
+
+	var lastID uint64
+	for {
+		var packs []models.Pack
+		err = db.Where("id > ? AND status != ?", lastID, models.PackRetired).Order("id").Limit(opts.BatchSize).Find(&packs).Error
+		if err != nil {
+			return c.report, err
+		}
+		if len(packs) == 0 {
+			return c.report, nil
+		}
+		lastID = packs[len(packs)-1].ID
+		for index := range packs {
+			if !packs[index].IsClosed() {
+				continue
+			}
+			if err = c.compactPack(&packs[index]); err != nil {
+				return c.report, err
+			}
+		}
+	}
+}
+
+func (c *compactor) fail(pack *models.Pack, chunkID uint64, format string, args ...interface{}) {
+	c.report.Failures = append(c.report.Failures, Failure{PackID: pack.ID, ChunkID: chunkID, Detail: fmt.Sprintf(format, args...)})
+}
+
+// packSize returns the size of the largest replica of pack.
+func (c *compactor) packSize(pack *models.Pack) (size int64, err error) {
+	for _, root := range pack.PlacedRoots(c.roots) {
+		info, err := root.Store.Stat(pack.Key())
+		if err != nil {
+			if err == storage.ErrNotExist {
+				continue
+			}
+			return 0, err
+		}
+		if info.Size > size {
+			size = info.Size
+		}
+	}
+	return size, nil
+}
+
+func (c *compactor) compactPack(pack *models.Pack) error {
+	var (
+		live struct {
+			Count int64
+			Bytes int64
+		}
+		size int64
+		err  error
+	)
+
+	c.report.Packs++
+	if size, err = c.packSize(pack); err != nil {
+		c.fail(pack, 0, "%s", err)
+		return nil
+	}
+	err = c.db.Model(&models.Chunk{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(storedSize), 0) AS bytes").
+		Where("packId = ?", pack.ID).
+		Scan(&live).Error
+	if err != nil {
+		return err
+	}
+	if size > 0 && float64(size-live.Bytes) < c.opts.DeadRatio*float64(size) {
+		return nil
+	}
+
+	c.report.Compacted++
+	if c.opts.DryRun {
+		c.report.Chunks += live.Count
+		c.report.CopiedBytes += live.Bytes
+		return nil
+	}
+
+	failed := false
+	for lastID := uint64(0); ; {
+		var chunks []models.Chunk
+		err = c.db.Where("packId = ? AND id > ?", pack.ID, lastID).Order("id").Limit(c.opts.BatchSize).Find(&chunks).Error
+		if err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			break
+		}
+		lastID = chunks[len(chunks)-1].ID
+		for index := range chunks {
+			chunk := &chunks[index]
+			moved, err := chunk.Repack(c.opts.RootPath, c.db)
+			if err != nil {
+				c.fail(pack, chunk.ID, "%s", err)
+				failed = true
+				continue
+			}
+			if moved {
+				c.report.Chunks++
+				c.report.CopiedBytes += int64(chunk.StoredSize)
+			}
+		}
+	}
+	if failed {
+		return nil
+	}
+	return pack.Retire(c.db)
+}
+
+// deleteRetired deletes the files and rows of the packs retired before grace,
+// a pack still referred to by any chunk is kept.
+func (c *compactor) deleteRetired() error {
+	var (
+		packs  []models.Pack
+		cutoff = time.Now().Add(-c.opts.Grace)
+	)
+	if err := c.db.Where("status = ? AND updatedAt < ?", models.PackRetired, cutoff).Find(&packs).Error; err != nil {
+		return err
+	}
+
+	for index := range packs {
+		var (
+			pack  = &packs[index]
+			count int64
+			size  int64
+			err   error
+		)
+		if err = c.db.Model(&models.Chunk{}).Where("packId = ?", pack.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			c.fail(pack, 0, "retired pack is still referred to by %d chunks", count)
+			continue
+		}
+		if size, err = c.packSize(pack); err != nil {
+			c.fail(pack, 0, "%s", err)
+			continue
+		}
+		if c.opts.DryRun {
+			c.report.Deleted++
+			c.report.ReclaimedBytes += size
+			continue
+		}
+
+		deleted := true
+		for _, root := range pack.PlacedRoots(c.roots) {
+			if err = root.Store.Delete(pack.Key()); err != nil {
+				c.fail(pack, 0, "failed to delete from %s: %s", root.Name, err)
+				deleted = false
+			}
+		}
+		if !deleted {
+			continue
+		}
+		if err = c.db.Delete(pack).Error; err != nil {
+			return err
+		}
+		c.report.Deleted++
+		c.report.ReclaimedBytes += size
+	}
+	return nil
+}
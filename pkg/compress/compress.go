@@ -0,0 +1,71 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func Valid(codec string) bool {
+	switch codec {
+	case "", None, Gzip, Zstd:
+		return true
+	}
+	return false
+}
+
+func Encode(codec string, p []byte) ([]byte, error) {
+	switch codec {
+	case "", None:
+		return p, nil
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(p, make([]byte, 0, len(p)/2)), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, codec)
+	}
+}
+
+func Decode(codec string, p []byte) ([]byte, error) {
+	switch codec {
+	case "", None:
+		return p, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case Zstd:
+		return zstdDecoder.DecodeAll(p, nil)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, codec)
+	}
+}
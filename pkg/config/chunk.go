@@ -1,5 +1,62 @@
 package config
 
 type Chunk struct {
-	RootPath string `yaml:"rootPath,omitempty"`
+	RootPath    string     `yaml:"rootPath,omitempty"`
+	Backend     string     `yaml:"backend,omitempty"`
+	S3          S3         `yaml:"s3,omitempty"`
+	Roots       []Root     `yaml:"roots,omitempty"`
+	Replicas    int        `yaml:"replicas,omitempty"`
+	Tiering     Tiering    `yaml:"tiering,omitempty"`
+	Pack        Pack       `yaml:"pack,omitempty"`
+	Compression string     `yaml:"compression,omitempty"`
+	Encryption  Encryption `yaml:"encryption,omitempty"`
+	Strategy    string     `yaml:"strategy,omitempty"`
+	MinSize     int        `yaml:"minSize,omitempty"`
+	AvgSize     int        `yaml:"avgSize,omitempty"`
+	MaxSize     int        `yaml:"maxSize,omitempty"`
+	Digests     []string   `yaml:"digests,omitempty"`
+}
+
+// Root is one of several places of chunks, such as a disk. rootPath, backend
+// and s3 of Chunk make the only root if no root is given.
+type Root struct {
+	Name    string `yaml:"name,omitempty"`
+	Backend string `yaml:"backend,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+	S3      S3     `yaml:"s3,omitempty"`
+	Weight  int    `yaml:"weight,omitempty"`
+	Drain   bool   `yaml:"drain,omitempty"`
+	Tier    string `yaml:"tier,omitempty"`
+}
+
+// Tiering moves the chunks of objects not read for IdleDays, or of files
+// under PathPrefixes which are given as "appUid:/path", to cold roots.
+type Tiering struct {
+	IdleDays      int      `yaml:"idleDays,omitempty"`
+	PathPrefixes  []string `yaml:"pathPrefixes,omitempty"`
+	PromoteOnRead bool     `yaml:"promoteOnRead,omitempty"`
+}
+
+// Pack appends the chunks stored smaller than Threshold bytes to pack files,
+// a pack file is sealed when it reaches MaxSize bytes.
+type Pack struct {
+	Enable    bool  `yaml:"enable,omitempty"`
+	Threshold int   `yaml:"threshold,omitempty"`
+	MaxSize   int64 `yaml:"maxSize,omitempty"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	Bucket    string `yaml:"bucket,omitempty"`
+	AccessKey string `yaml:"accessKey,omitempty"`
+	SecretKey string `yaml:"secretKey,omitempty"`
+	Prefix    string `yaml:"prefix,omitempty"`
+	PathStyle bool   `yaml:"pathStyle,omitempty"`
+}
+
+type Encryption struct {
+	Enable        bool   `yaml:"enable,omitempty"`
+	MasterKey     string `yaml:"masterKey,omitempty"`
+	MasterKeyFile string `yaml:"masterKeyFile,omitempty"`
 }
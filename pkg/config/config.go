@@ -11,6 +11,7 @@ type Configurator struct {
 	Log      `yaml:"log,omitempty"`
 	HTTP     `yaml:"http,omitempty"`
 	Chunk    `yaml:"chunk,omitempty"`
+	GC       `yaml:"gc,omitempty"`
 }
 
 func ParseConfigFile(file string, config *Configurator) error {
@@ -59,9 +59,32 @@ func init() {
 			CORSAllowOrigins:      []string{"*"},
 			CORSAllowMethods:      []string{"PUT", "DELETE", "PATCH"},
 			CORSMaxAge:            3600 * int64(time.Second),
+			StatsInterval:         10,
 		},
 		Chunk{
 			RootPath: "storage/chunks",
+			Backend:  "local",
+			S3: S3{
+				Region:    "us-east-1",
+				PathStyle: true,
+			},
+			Replicas: 1,
+			Pack: Pack{
+				Enable:    false,
+				Threshold: 64 << 10,
+				MaxSize:   256 << 20,
+			},
+			Compression: "none",
+			Strategy:    "fixed",
+			MinSize:     512 << 10,
+			AvgSize:     1 << 20,
+			MaxSize:     2 << 20,
+		},
+		GC{
+			Enable:      false,
+			Interval:    3600,
+			GracePeriod: 86400,
+			BatchSize:   500,
 		},
 	}
 }
@@ -0,0 +1,8 @@
+package config
+
+type GC struct {
+	Enable      bool  `yaml:"enable,omitempty"`
+	Interval    int64 `yaml:"interval,omitempty"`
+	GracePeriod int64 `yaml:"gracePeriod,omitempty"`
+	BatchSize   int   `yaml:"batchSize,omitempty"`
+}
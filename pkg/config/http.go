@@ -1,17 +1,96 @@
 package config
 
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidRateLimit = errors.New("rates must be at least 0, and times of schedules must be like 15:04")
+
 type HTTP struct {
-	APIPrefix             string   `yaml:"apiPrefix,omitempty"`
-	AccessLogFile         string   `yaml:"accessLogFile,omitempty"`
-	LimitRateByIPEnable   bool     `yaml:"limitRateByIPEnable,omitempty"`
-	LimitRateByIPInterval int64    `yaml:"limitRateByIPInterval,omitempty"`
-	LimitRateByIPMaxNum   uint     `yaml:"limitRateByIPMaxNum,omitempty"`
-	CORSEnable            bool     `yaml:"corsEnable,omitempty"`
-	CORSAllowAllOrigins   bool     `yaml:"corsAllowAllOrigins,omitempty"`
-	CORSAllowOrigins      []string `yaml:"corsAllowOrigins,omitempty"`
-	CORSAllowMethods      []string `yaml:"corsAllowMethods,omitempty"`
-	CORSAllowHeaders      []string `yaml:"corsAllowHeaders,omitempty"`
-	CORSExposeHeaders     []string `yaml:"corsExposeHeaders,omitempty"`
-	CORSAllowCredentials  bool     `yaml:"corsAllowCredentials,omitempty"`
-	CORSMaxAge            int64    `yaml:"corsMaxAge,omitempty"`
+	APIPrefix             string    `yaml:"apiPrefix,omitempty"`
+	AccessLogFile         string    `yaml:"accessLogFile,omitempty"`
+	LimitRateByIPEnable   bool      `yaml:"limitRateByIPEnable,omitempty"`
+	LimitRateByIPInterval int64     `yaml:"limitRateByIPInterval,omitempty"`
+	LimitRateByIPMaxNum   uint      `yaml:"limitRateByIPMaxNum,omitempty"`
+	CORSEnable            bool      `yaml:"corsEnable,omitempty"`
+	CORSAllowAllOrigins   bool      `yaml:"corsAllowAllOrigins,omitempty"`
+	CORSAllowOrigins      []string  `yaml:"corsAllowOrigins,omitempty"`
+	CORSAllowMethods      []string  `yaml:"corsAllowMethods,omitempty"`
+	CORSAllowHeaders      []string  `yaml:"corsAllowHeaders,omitempty"`
+	CORSExposeHeaders     []string  `yaml:"corsExposeHeaders,omitempty"`
+	CORSAllowCredentials  bool      `yaml:"corsAllowCredentials,omitempty"`
+	CORSMaxAge            int64     `yaml:"corsMaxAge,omitempty"`
+	RateLimit             RateLimit `yaml:"rateLimit,omitempty"`
+	StatsInterval         int64     `yaml:"statsInterval,omitempty"`
+}
+
+// RateLimit limits the bytes per second read from and written to the server,
+// 0 is unlimited. A schedule replaces both rates during its time of day.
+type RateLimit struct {
+	Read      int64          `yaml:"read,omitempty" json:"read,omitempty"`
+	Write     int64          `yaml:"write,omitempty" json:"write,omitempty"`
+	Schedules []RateSchedule `yaml:"schedules,omitempty" json:"schedules,omitempty"`
+}
+
+// RateSchedule applies from From until To, both like 15:04 in local time, To
+// before From wraps past midnight.
+type RateSchedule struct {
+	From  string `yaml:"from" json:"from"`
+	To    string `yaml:"to" json:"to"`
+	Read  int64  `yaml:"read,omitempty" json:"read,omitempty"`
+	Write int64  `yaml:"write,omitempty" json:"write,omitempty"`
+}
+
+func (r *RateLimit) Validate() error {
+	if r.Read < 0 || r.Write < 0 {
+		return ErrInvalidRateLimit
+	}
+	for _, schedule := range r.Schedules {
+		if _, _, err := schedule.window(); err != nil || schedule.Read < 0 || schedule.Write < 0 {
+			return ErrInvalidRateLimit
+		}
+	}
+	return nil
+}
+
+// Limited tells whether any rate is limited at any time.
+func (r *RateLimit) Limited() bool {
+	if r.Read > 0 || r.Write > 0 {
+		return true
+	}
+	for _, schedule := range r.Schedules {
+		if schedule.Read > 0 || schedule.Write > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Rates returns the read and write rates at now, by the first schedule
+// covering it or the default ones.
+func (r *RateLimit) Rates(now time.Time) (read, write int64) {
+	minute := now.Hour()*60 + now.Minute()
+	for _, schedule := range r.Schedules {
+		from, to, err := schedule.window()
+		if err != nil {
+			continue
+		}
+		if from <= to && minute >= from && minute < to || from > to && (minute >= from || minute < to) {
+			return schedule.Read, schedule.Write
+		}
+	}
+	return r.Read, r.Write
+}
+
+// window returns From and To as minutes of the day.
+func (s RateSchedule) window() (from, to int, err error) {
+	var f, t time.Time
+	if f, err = time.Parse("15:04", s.From); err != nil {
+		return 0, 0, err
+	}
+	if t, err = time.Parse("15:04", s.To); err != nil {
+		return 0, 0, err
+	}
+	return f.Hour()*60 + f.Minute(), t.Hour()*60 + t.Minute(), nil
 }
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&CreateChunkPlacementsTable{})
+}
+
+type CreateChunkPlacementsTable struct{}
+
+func (c *CreateChunkPlacementsTable) Name() string {
+	return "create_chunk_placements_table"
+}
+
+func (c *CreateChunkPlacementsTable) Up(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE TABLE IF NOT EXISTS chunk_placements (
+	  id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	  chunkId BIGINT(20) UNSIGNED NOT NULL,
+	  root VARCHAR(64) NOT NULL,
+	  createdAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	  PRIMARY KEY (id),
+	  UNIQUE INDEX chunk_root_uq (chunkId, root),
+	  KEY root_idx (root))
+	ENGINE = InnoDB DEFAULT CHARACTER SET utf8 COLLATE utf8_general_ci`).Error
+}
+
+func (c *CreateChunkPlacementsTable) Down(db *gorm.DB) error {
+	return db.DropTableIfExists("chunk_placements").Error
+}
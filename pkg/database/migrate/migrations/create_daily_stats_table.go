@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&CreateDailyStatsTable{})
+}
+
+type CreateDailyStatsTable struct{}
+
+func (c *CreateDailyStatsTable) Name() string {
+	return "create_daily_stats_table"
+}
+
+func (c *CreateDailyStatsTable) Up(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE TABLE IF NOT EXISTS daily_stats (
+	  id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	  day DATE NOT NULL,
+	  appId BIGINT(20) UNSIGNED NOT NULL,
+	  tokenId BIGINT(20) UNSIGNED NOT NULL DEFAULT 0,
+	  requests BIGINT(20) NOT NULL DEFAULT 0,
+	  bytesIn BIGINT(20) NOT NULL DEFAULT 0,
+	  bytesOut BIGINT(20) NOT NULL DEFAULT 0,
+	  createdAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	  updatedAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	  PRIMARY KEY (id),
+	  KEY appId_day_idx (appId, day),
+	  UNIQUE day_appId_tokenId_unique (day, appId, tokenId))
+	ENGINE = InnoDB DEFAULT CHARACTER SET utf8 COLLATE utf8_general_ci`).Error
+}
+
+func (c *CreateDailyStatsTable) Down(db *gorm.DB) error {
+	return db.DropTableIfExists("daily_stats").Error
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&CreateDataKeysTable{})
+}
+
+type CreateDataKeysTable struct{}
+
+func (c *CreateDataKeysTable) Name() string {
+	return "create_data_keys_table"
+}
+
+func (c *CreateDataKeysTable) Up(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE TABLE IF NOT EXISTS data_keys (
+	  id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	  appId BIGINT(20) UNSIGNED NOT NULL DEFAULT 0,
+	  wrappedKey VARCHAR(255) NOT NULL,
+	  masterKeyId CHAR(16) NOT NULL,
+	  createdAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	  updatedAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	  PRIMARY KEY (id),
+	  KEY appId_idx (appId))
+	ENGINE = InnoDB DEFAULT CHARACTER SET utf8 COLLATE utf8_general_ci`).Error
+}
+
+func (c *CreateDataKeysTable) Down(db *gorm.DB) error {
+	return db.DropTableIfExists("data_keys").Error
+}
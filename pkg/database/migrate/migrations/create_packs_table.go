@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&CreatePacksTable{})
+}
+
+type CreatePacksTable struct{}
+
+func (c *CreatePacksTable) Name() string {
+	return "create_packs_table"
+}
+
+func (c *CreatePacksTable) Up(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE TABLE IF NOT EXISTS packs (
+	  id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	  roots VARCHAR(255) NOT NULL,
+	  status TINYINT(4) NOT NULL DEFAULT 0,
+	  createdAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	  updatedAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	  PRIMARY KEY (id),
+	  KEY status_idx (status))
+	ENGINE = InnoDB DEFAULT CHARACTER SET utf8 COLLATE utf8_general_ci`).Error
+}
+
+func (c *CreatePacksTable) Down(db *gorm.DB) error {
+	return db.DropTableIfExists("packs").Error
+}
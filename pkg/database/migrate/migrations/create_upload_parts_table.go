@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&CreateUploadPartsTable{})
+}
+
+type CreateUploadPartsTable struct{}
+
+func (c *CreateUploadPartsTable) Name() string {
+	return "create_upload_parts_table"
+}
+
+func (c *CreateUploadPartsTable) Up(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE TABLE IF NOT EXISTS upload_parts (
+	  id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	  sessionId BIGINT(20) UNSIGNED NOT NULL,
+	  number INT UNSIGNED NOT NULL,
+	  chunkId BIGINT(20) UNSIGNED NOT NULL,
+	  size INT UNSIGNED NOT NULL,
+	  hash CHAR(64) NOT NULL,
+	  createdAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	  updatedAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	  PRIMARY KEY (id),
+	  UNIQUE INDEX session_number_uq (sessionId, number),
+	  KEY chunkId_idx (chunkId))
+	ENGINE = InnoDB DEFAULT CHARACTER SET utf8 COLLATE utf8_general_ci`).Error
+}
+
+func (c *CreateUploadPartsTable) Down(db *gorm.DB) error {
+	return db.DropTableIfExists("upload_parts").Error
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&CreateUploadSessionsTable{})
+}
+
+type CreateUploadSessionsTable struct{}
+
+func (c *CreateUploadSessionsTable) Name() string {
+	return "create_upload_sessions_table"
+}
+
+func (c *CreateUploadSessionsTable) Up(db *gorm.DB) error {
+	return db.Exec(`
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+	  id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	  uid CHAR(32) NOT NULL,
+	  appId BIGINT(20) UNSIGNED NOT NULL,
+	  path VARCHAR(1000) NOT NULL,
+	  hidden TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	  onConflict TINYINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '0: fail, 1: overwrite, 2: rename',
+	  status TINYINT UNSIGNED NOT NULL DEFAULT 0 COMMENT '0: pending, 1: completed, 2: aborted',
+	  fileId BIGINT(20) UNSIGNED NOT NULL DEFAULT 0,
+	  expiredAt timestamp(6) NULL,
+	  createdAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	  updatedAt timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+	  PRIMARY KEY (id),
+	  UNIQUE INDEX uid_uq_idx (uid ASC),
+	  KEY appId_idx (appId))
+	ENGINE = InnoDB DEFAULT CHARACTER SET utf8 COLLATE utf8_general_ci`).Error
+}
+
+func (c *CreateUploadSessionsTable) Down(db *gorm.DB) error {
+	return db.DropTableIfExists("upload_sessions").Error
+}
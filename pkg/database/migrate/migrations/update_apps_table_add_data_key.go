@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateAppsTableAddDataKey{})
+}
+
+type UpdateAppsTableAddDataKey struct{}
+
+func (c *UpdateAppsTableAddDataKey) Name() string {
+	return "update_apps_table_add_data_key"
+}
+
+func (c *UpdateAppsTableAddDataKey) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table apps
+		add column dataKeyId bigint(20) unsigned null after note
+	`).Error
+}
+
+func (c *UpdateAppsTableAddDataKey) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table apps
+		drop column dataKeyId
+	`).Error
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateAppsTableAddQuota{})
+}
+
+type UpdateAppsTableAddQuota struct{}
+
+func (c *UpdateAppsTableAddQuota) Name() string {
+	return "update_apps_table_add_quota"
+}
+
+func (c *UpdateAppsTableAddQuota) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table apps
+		add column quotaBytes bigint(20) not null default 0,
+		add column quotaFiles bigint(20) not null default 0
+	`).Error
+}
+
+func (c *UpdateAppsTableAddQuota) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table apps
+		drop column quotaFiles,
+		drop column quotaBytes
+	`).Error
+}
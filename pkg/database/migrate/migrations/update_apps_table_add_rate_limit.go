@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateAppsTableAddRateLimit{})
+}
+
+type UpdateAppsTableAddRateLimit struct{}
+
+func (c *UpdateAppsTableAddRateLimit) Name() string {
+	return "update_apps_table_add_rate_limit"
+}
+
+func (c *UpdateAppsTableAddRateLimit) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table apps
+		add column rateLimit text null
+	`).Error
+}
+
+func (c *UpdateAppsTableAddRateLimit) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table apps
+		drop column rateLimit
+	`).Error
+}
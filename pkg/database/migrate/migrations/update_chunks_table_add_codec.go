@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateChunksTableAddCodec{})
+}
+
+type UpdateChunksTableAddCodec struct{}
+
+func (c *UpdateChunksTableAddCodec) Name() string {
+	return "update_chunks_table_add_codec"
+}
+
+func (c *UpdateChunksTableAddCodec) Up(db *gorm.DB) error {
+	if err := db.Exec(`
+	alter table chunks
+		add column codec varchar(16) not null default 'none' after hash,
+		add column storedSize int unsigned not null default 0
+	`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`update chunks set storedSize = size`).Error
+}
+
+func (c *UpdateChunksTableAddCodec) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		drop column storedSize,
+		drop column codec
+	`).Error
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateChunksTableAddDataKey{})
+}
+
+type UpdateChunksTableAddDataKey struct{}
+
+func (c *UpdateChunksTableAddDataKey) Name() string {
+	return "update_chunks_table_add_data_key"
+}
+
+func (c *UpdateChunksTableAddDataKey) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		add column dataKeyId bigint(20) unsigned not null default 0,
+		add index dataKeyId_idx (dataKeyId)
+	`).Error
+}
+
+func (c *UpdateChunksTableAddDataKey) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		drop index dataKeyId_idx,
+		drop column dataKeyId
+	`).Error
+}
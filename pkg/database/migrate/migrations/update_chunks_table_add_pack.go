@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateChunksTableAddPack{})
+}
+
+type UpdateChunksTableAddPack struct{}
+
+func (c *UpdateChunksTableAddPack) Name() string {
+	return "update_chunks_table_add_pack"
+}
+
+func (c *UpdateChunksTableAddPack) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		add column packId bigint(20) unsigned not null default 0,
+		add column packOffset bigint(20) not null default 0,
+		add index packId_idx (packId)
+	`).Error
+}
+
+func (c *UpdateChunksTableAddPack) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		drop index packId_idx,
+		drop column packOffset,
+		drop column packId
+	`).Error
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateChunksTableAddRefCount{})
+}
+
+type UpdateChunksTableAddRefCount struct{}
+
+func (c *UpdateChunksTableAddRefCount) Name() string {
+	return "update_chunks_table_add_ref_count"
+}
+
+func (c *UpdateChunksTableAddRefCount) Up(db *gorm.DB) error {
+	err := db.Exec(`
+	alter table chunks
+		add column refCount int not null default 0
+	`).Error
+	if err != nil {
+		return err
+	}
+	return db.Exec(`
+	update chunks c set
+		c.refCount = (select count(*) from object_chunk oc where oc.chunkId = c.id)
+			+ (select count(*) from upload_parts p where p.chunkId = c.id),
+		c.updatedAt = c.updatedAt
+	`).Error
+}
+
+func (c *UpdateChunksTableAddRefCount) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		drop column refCount
+	`).Error
+}
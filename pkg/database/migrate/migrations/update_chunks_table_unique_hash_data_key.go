@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateChunksTableUniqueHashDataKey{})
+}
+
+type UpdateChunksTableUniqueHashDataKey struct{}
+
+func (c *UpdateChunksTableUniqueHashDataKey) Name() string {
+	return "update_chunks_table_unique_hash_data_key"
+}
+
+func (c *UpdateChunksTableUniqueHashDataKey) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		drop index hash_UNIQUE,
+		add unique index hash_dataKeyId_UNIQUE (hash, dataKeyId)
+	`).Error
+}
+
+func (c *UpdateChunksTableUniqueHashDataKey) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table chunks
+		drop index hash_dataKeyId_UNIQUE,
+		add unique index hash_UNIQUE (hash ASC)
+	`).Error
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateFilesTableAddLastAccessedAt{})
+}
+
+type UpdateFilesTableAddLastAccessedAt struct{}
+
+func (c *UpdateFilesTableAddLastAccessedAt) Name() string {
+	return "update_files_table_add_last_accessed_at"
+}
+
+func (c *UpdateFilesTableAddLastAccessedAt) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table files
+		add column lastAccessedAt timestamp(6) null after downloadCount
+	`).Error
+}
+
+func (c *UpdateFilesTableAddLastAccessedAt) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table files
+		drop column lastAccessedAt
+	`).Error
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateHistoriesTableAddObjectIndex{})
+}
+
+type UpdateHistoriesTableAddObjectIndex struct{}
+
+func (c *UpdateHistoriesTableAddObjectIndex) Name() string {
+	return "update_histories_table_add_object_index"
+}
+
+func (c *UpdateHistoriesTableAddObjectIndex) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table histories
+		add index objectId_idx (objectId)
+	`).Error
+}
+
+func (c *UpdateHistoriesTableAddObjectIndex) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table histories
+		drop index objectId_idx
+	`).Error
+}
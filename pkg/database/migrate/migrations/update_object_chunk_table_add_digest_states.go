@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateObjectChunkTableAddDigestStates{})
+}
+
+type UpdateObjectChunkTableAddDigestStates struct{}
+
+func (c *UpdateObjectChunkTableAddDigestStates) Name() string {
+	return "update_object_chunk_table_add_digest_states"
+}
+
+func (c *UpdateObjectChunkTableAddDigestStates) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table object_chunk
+		add column digestStates text null after hashState
+	`).Error
+}
+
+func (c *UpdateObjectChunkTableAddDigestStates) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table object_chunk
+		drop column digestStates
+	`).Error
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateObjectsTableAddDigests{})
+}
+
+type UpdateObjectsTableAddDigests struct{}
+
+func (c *UpdateObjectsTableAddDigests) Name() string {
+	return "update_objects_table_add_digests"
+}
+
+func (c *UpdateObjectsTableAddDigests) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		add column digests text null after hash
+	`).Error
+}
+
+func (c *UpdateObjectsTableAddDigests) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		drop column digests
+	`).Error
+}
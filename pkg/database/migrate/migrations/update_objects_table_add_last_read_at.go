@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateObjectsTableAddLastReadAt{})
+}
+
+type UpdateObjectsTableAddLastReadAt struct{}
+
+func (c *UpdateObjectsTableAddLastReadAt) Name() string {
+	return "update_objects_table_add_last_read_at"
+}
+
+func (c *UpdateObjectsTableAddLastReadAt) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		add column lastReadAt timestamp(6) null after hash
+	`).Error
+}
+
+func (c *UpdateObjectsTableAddLastReadAt) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		drop column lastReadAt
+	`).Error
+}
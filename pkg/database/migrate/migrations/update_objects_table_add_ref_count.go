@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateObjectsTableAddRefCount{})
+}
+
+type UpdateObjectsTableAddRefCount struct{}
+
+func (c *UpdateObjectsTableAddRefCount) Name() string {
+	return "update_objects_table_add_ref_count"
+}
+
+func (c *UpdateObjectsTableAddRefCount) Up(db *gorm.DB) error {
+	err := db.Exec(`
+	alter table objects
+		add column refCount int not null default 0
+	`).Error
+	if err != nil {
+		return err
+	}
+	return db.Exec(`
+	update objects o set
+		o.refCount = (select count(*) from files f where f.objectId = o.id)
+			+ (select count(*) from histories h where h.objectId = o.id),
+		o.updatedAt = o.updatedAt
+	`).Error
+}
+
+func (c *UpdateObjectsTableAddRefCount) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		drop column refCount
+	`).Error
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateObjectsTableIndexHash{})
+}
+
+type UpdateObjectsTableIndexHash struct{}
+
+func (c *UpdateObjectsTableIndexHash) Name() string {
+	return "update_objects_table_index_hash"
+}
+
+func (c *UpdateObjectsTableIndexHash) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		drop index hash_UNIQUE,
+		add index hash_idx (hash)
+	`).Error
+}
+
+func (c *UpdateObjectsTableIndexHash) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table objects
+		drop index hash_idx,
+		add unique index hash_UNIQUE (hash ASC)
+	`).Error
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateTokensTableAddQuota{})
+}
+
+type UpdateTokensTableAddQuota struct{}
+
+func (c *UpdateTokensTableAddQuota) Name() string {
+	return "update_tokens_table_add_quota"
+}
+
+func (c *UpdateTokensTableAddQuota) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table tokens
+		add column quotaBytes bigint(20) not null default 0,
+		add column quotaFiles bigint(20) not null default 0,
+		add column usedBytes bigint(20) not null default 0,
+		add column usedFiles bigint(20) not null default 0
+	`).Error
+}
+
+func (c *UpdateTokensTableAddQuota) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table tokens
+		drop column usedFiles,
+		drop column usedBytes,
+		drop column quotaFiles,
+		drop column quotaBytes
+	`).Error
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"medea/pkg/database/migrate"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.DefaultMC.Register(&UpdateTokensTableAddRateLimit{})
+}
+
+type UpdateTokensTableAddRateLimit struct{}
+
+func (c *UpdateTokensTableAddRateLimit) Name() string {
+	return "update_tokens_table_add_rate_limit"
+}
+
+func (c *UpdateTokensTableAddRateLimit) Up(db *gorm.DB) error {
+	return db.Exec(`
+	alter table tokens
+		add column rateLimit text null
+	`).Error
+}
+
+func (c *UpdateTokensTableAddRateLimit) Down(db *gorm.DB) error {
+	return db.Exec(`
+	alter table tokens
+		drop column rateLimit
+	`).Error
+}
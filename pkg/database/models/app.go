@@ -7,14 +7,18 @@ import (
 )
 
 type App struct {
-	ID        uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
-	UID       string     `gorm:"type:CHAR(32) NOT NULL;UNIQUE;column:uid"`
-	Secret    string     `gorm:"type:CHAR(32) NOT NULL"`
-	Name      string     `gorm:"type:VARCHAR(100) NOT NULL"`
-	Note      *string    `gorm:"type:VARCHAR(500) NULL"`
-	CreatedAt time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
-	UpdatedAt time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
-	DeletedAt *time.Time `gorm:"type:TIMESTAMP(6);INDEX;column:deletedAt"`
+	ID         uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	UID        string     `gorm:"type:CHAR(32) NOT NULL;UNIQUE;column:uid"`
+	Secret     string     `gorm:"type:CHAR(32) NOT NULL"`
+	Name       string     `gorm:"type:VARCHAR(100) NOT NULL"`
+	Note       *string    `gorm:"type:VARCHAR(500) NULL"`
+	DataKeyID  *uint64    `gorm:"type:BIGINT(20) UNSIGNED NULL;column:dataKeyId"`
+	QuotaBytes int64      `gorm:"type:BIGINT(20);column:quotaBytes;DEFAULT:0"`
+	QuotaFiles int64      `gorm:"type:BIGINT(20);column:quotaFiles;DEFAULT:0"`
+	RateLimit  *string    `gorm:"type:TEXT;column:rateLimit"`
+	CreatedAt  time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt  time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+	DeletedAt  *time.Time `gorm:"type:TIMESTAMP(6);INDEX;column:deletedAt"`
 }
 
 func (app *App) TableName() string {
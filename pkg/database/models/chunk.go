@@ -2,16 +2,21 @@ package models
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
-	"strings"
 	"time"
 
+	"medea/pkg/chunker"
+	"medea/pkg/compress"
 	"medea/pkg/config"
+	"medea/pkg/envelope"
+	"medea/pkg/storage"
 	"medea/pkg/utils"
 
 	"github.com/jinzhu/gorm"
@@ -22,39 +27,389 @@ const ChunkSize = 2 << 20
 var (
 	ErrInvalidChunkID   = errors.New("invalid chunk id")
 	ErrChunkExceedLimit = fmt.Errorf("total length exceed limit: %d bytes", ChunkSize)
+	ErrChunkCorrupted   = errors.New("chunk content doesn't match its size and hash")
 )
 
 type Chunk struct {
-	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
-	Size      int       `gorm:"type:int;column:size"`
-	Hash      string    `gorm:"type:CHAR(64) NOT NULL;UNIQUE;column:hash"`
-	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
-	UpdatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+	ID         uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	Size       int       `gorm:"type:int;column:size"`
+	Hash       string    `gorm:"type:CHAR(64) NOT NULL;unique_index:hash_dataKeyId_UNIQUE;column:hash"`
+	Codec      string    `gorm:"type:VARCHAR(16) NOT NULL;column:codec;DEFAULT:'none'"`
+	StoredSize int       `gorm:"type:int;column:storedSize"`
+	DataKeyID  uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;unique_index:hash_dataKeyId_UNIQUE;column:dataKeyId;DEFAULT:0"`
+	PackID     uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:packId;DEFAULT:0"`
+	PackOffset int64     `gorm:"type:BIGINT(20) NOT NULL;column:packOffset;DEFAULT:0"`
+	RefCount   int       `gorm:"type:int;column:refCount;DEFAULT:0"`
+	CreatedAt  time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt  time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
 }
 
 func (c Chunk) TableName() string {
 	return "chunks"
 }
 
-func (c *Chunk) Reader(rootPath *string) (file *os.File, err error) {
-	var path string
-	if path, err = c.Path(rootPath); err != nil {
-		return
+// chunkerOptions returns the chunking strategy configured, the size of a
+// chunk never exceeds ChunkSize whatever the strategy is.
+func chunkerOptions() chunker.Options {
+	var conf = config.DefaultConfig.Chunk
+
+	opts := chunker.Options{
+		Strategy: conf.Strategy,
+		MinSize:  conf.MinSize,
+		AvgSize:  conf.AvgSize,
+		MaxSize:  conf.MaxSize,
+	}
+	if opts.Strategy != chunker.FastCDC || opts.MaxSize <= 0 || opts.MaxSize > ChunkSize {
+		opts.MaxSize = ChunkSize
+	}
+	return opts
+}
+
+func isContentDefinedChunking() bool {
+	return config.DefaultConfig.Chunk.Strategy == chunker.FastCDC
+}
+
+// ChunkRoots returns the roots of chunks, a single local root at rootPath is
+// used if rootPath is given, otherwise the configured ones.
+func ChunkRoots(rootPath *string) ([]*storage.Root, error) {
+	if rootPath != nil {
+		return []*storage.Root{{
+			Name:   storage.DefaultRoot,
+			Weight: 1,
+			Tier:   storage.TierHot,
+			Store:  storage.NewLocalStore(*rootPath),
+		}}, nil
+	}
+	return storage.DefaultRoots()
+}
+
+// ChunkReplicas returns the number of replicas configured for new chunks.
+func ChunkReplicas() int {
+	if replicas := config.DefaultConfig.Chunk.Replicas; replicas > 1 {
+		return replicas
+	}
+	return 1
+}
+
+func (c *Chunk) Placements(db *gorm.DB) (placements []ChunkPlacement, err error) {
+	err = db.Where("chunkId = ?", c.ID).Order("id asc").Find(&placements).Error
+	return placements, err
+}
+
+// ReplicaRoots returns the roots keeping the replicas of chunk.
+func (c *Chunk) ReplicaRoots(rootPath *string, db *gorm.DB) ([]*storage.Root, error) {
+	var (
+		roots      []*storage.Root
+		placements []ChunkPlacement
+		err        error
+	)
+	if roots, err = ChunkRoots(rootPath); err != nil {
+		return nil, err
+	}
+	if placements, err = c.Placements(db); err != nil {
+		return nil, err
+	}
+	return PlacedRoots(placements, roots), nil
+}
+
+func (c *Chunk) Reader(rootPath *string, db *gorm.DB) (io.ReadCloser, error) {
+	return c.RangeReader(0, -1, rootPath, db)
+}
+
+// RangeReader reads length bytes of the chunk from offset, a negative length
+// reads to the end. A compressed or encrypted chunk is decoded and verified as
+// a whole first, the range of any other one is read from the first root which
+// keeps a replica of it.
+func (c *Chunk) RangeReader(offset, length int64, rootPath *string, db *gorm.DB) (io.ReadCloser, error) {
+	var (
+		key     string
+		roots   []*storage.Root
+		content []byte
+		reader  io.ReadCloser
+		err     error
+	)
+
+	if roots, err = c.ReplicaRoots(rootPath, db); err != nil {
+		return nil, err
+	}
+	if storage.IsCold(roots) {
+		promote(c.ID)
+	}
+
+	if c.isCompressed() || c.isEncrypted() {
+		if content, err = c.contentFromRoots(roots, db); err != nil {
+			return nil, err
+		}
+		if offset > int64(len(content)) {
+			offset = int64(len(content))
+		}
+		content = content[offset:]
+		if length >= 0 && length < int64(len(content)) {
+			content = content[:length]
+		}
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	if offset > int64(c.StoredSize) {
+		offset = int64(c.StoredSize)
 	}
-	return os.Open(path)
+	if !c.IsPacked() {
+		if key, err = c.Key(); err != nil {
+			return nil, err
+		}
+	} else {
+		if length < 0 || offset+length > int64(c.StoredSize) {
+			length = int64(c.StoredSize) - offset
+		}
+		key, offset = PackKey(c.PackID), c.PackOffset+offset
+	}
+
+	err = storage.ErrNotExist
+	for _, root := range roots {
+		if reader, err = root.Store.Range(key, offset, length); err != storage.ErrNotExist {
+			return reader, err
+		}
+	}
+	return nil, err
+}
+
+// Content returns the logical content of chunk, which is decrypted and
+// decompressed if needed.
+func (c *Chunk) Content(rootPath *string, db *gorm.DB) ([]byte, error) {
+	roots, err := c.ReplicaRoots(rootPath, db)
+	if err != nil {
+		return nil, err
+	}
+	if storage.IsCold(roots) {
+		promote(c.ID)
+	}
+	return c.contentFromRoots(roots, db)
+}
+
+func (c *Chunk) contentFromRoots(roots []*storage.Root, db *gorm.DB) ([]byte, error) {
+	_, content, err := c.GoodReplica(roots, db)
+	return content, err
+}
+
+// GoodReplica reads the replicas on roots in turn until one is found and
+// matches the size and hash of chunk, both its stored and logical content are
+// returned.
+func (c *Chunk) GoodReplica(roots []*storage.Root, db *gorm.DB) (stored, content []byte, err error) {
+	err = storage.ErrNotExist
+	for _, root := range roots {
+		if stored, err = c.ReadStored(root.Store); err != nil {
+			continue
+		}
+		if content, err = c.DecodeContent(stored, db); err != nil {
+			continue
+		}
+		if err = c.Verify(content); err != nil {
+			continue
+		}
+		return stored, content, nil
+	}
+	return nil, nil, err
 }
 
-func (c Chunk) Path(rootPath *string) (path string, err error) {
+// Verify checks content against the size and hash of chunk.
+func (c *Chunk) Verify(content []byte) error {
+	if len(content) != c.Size {
+		return ErrChunkCorrupted
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != c.Hash {
+		return ErrChunkCorrupted
+	}
+	return nil
+}
+
+func (c *Chunk) isCompressed() bool {
+	return c.Codec != "" && c.Codec != compress.None
+}
+
+func (c *Chunk) isEncrypted() bool {
+	return c.DataKeyID != 0
+}
+
+// encodeContent compresses p with the configured codec, p is kept raw if
+// compressing doesn't make it smaller, then encrypts it if encryption is
+// enabled. The codec, data key and stored size are recorded on the chunk.
+func (c *Chunk) encodeContent(p []byte, db *gorm.DB) ([]byte, error) {
+	var (
+		conf    = config.DefaultConfig.Chunk
+		stored  = p
+		encoded []byte
+		dataKey []byte
+		err     error
+	)
+
+	c.Codec, c.DataKeyID = compress.None, 0
+	if conf.Compression != "" && conf.Compression != compress.None && len(p) > 0 {
+		if encoded, err = compress.Encode(conf.Compression, p); err != nil {
+			return nil, err
+		}
+		if len(encoded) < len(p) {
+			c.Codec, stored = conf.Compression, encoded
+		}
+	}
+
+	if conf.Encryption.Enable && len(p) > 0 {
+		if c.DataKeyID, dataKey, err = dataKeyForWrite(db); err != nil {
+			return nil, err
+		}
+		if stored, err = envelope.Seal(dataKey, stored, []byte(c.Hash)); err != nil {
+			return nil, err
+		}
+	}
+
+	c.StoredSize = len(stored)
+	return stored, nil
+}
+
+// DecodeContent returns the logical content of the stored one.
+func (c *Chunk) DecodeContent(stored []byte, db *gorm.DB) ([]byte, error) {
+	var (
+		dataKey []byte
+		err     error
+	)
+	if c.isEncrypted() {
+		if dataKey, err = dataKeyByID(c.DataKeyID, db); err != nil {
+			return nil, err
+		}
+		if stored, err = envelope.Open(dataKey, stored, []byte(c.Hash)); err != nil {
+			return nil, err
+		}
+	}
+	return compress.Decode(c.Codec, stored)
+}
+
+// storeNew stores the content of a new chunk, which is packed if it is small
+// and packing is started.
+func (c *Chunk) storeNew(stored []byte, rootPath *string, db *gorm.DB) error {
+	if packed, err := c.putPacked(stored, rootPath, db); err != nil || packed {
+		return err
+	}
+	return c.putStored(stored, rootPath, db)
+}
+
+// putStored writes stored to the roots keeping chunk, or to the roots chosen
+// by placement for a new chunk, whose placements are recorded then. A packed
+// chunk is appended to a pack again, or unpacked if it isn't small anymore.
+func (c *Chunk) putStored(stored []byte, rootPath *string, db *gorm.DB) error {
+	var (
+		key        string
+		roots      []*storage.Root
+		placements []ChunkPlacement
+		err        error
+	)
+	if c.IsPacked() {
+		var packed bool
+		if packed, err = c.putPacked(stored, rootPath, db); err != nil || packed {
+			return err
+		}
+		if err = c.setPack(0, 0, nil, db); err != nil {
+			return err
+		}
+	}
+	if key, err = c.Key(); err != nil {
+		return err
+	}
+	if roots, err = ChunkRoots(rootPath); err != nil {
+		return err
+	}
+	if placements, err = c.Placements(db); err != nil {
+		return err
+	}
+
+	if len(placements) > 0 {
+		roots = PlacedRoots(placements, roots)
+	} else if roots, err = storage.Place(key, storage.TierRoots(roots, storage.TierHot), ChunkReplicas()); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		if err = root.Store.Put(key, stored); err != nil {
+			return err
+		}
+		if len(placements) == 0 {
+			if err = AddChunkPlacement(c.ID, root.Name, db); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MoveTo copies chunk from a good replica to the targets which don't keep it
+// yet, then removes the replicas elsewhere. It returns the bytes copied.
+func (c *Chunk) MoveTo(targets []*storage.Root, rootPath *string, db *gorm.DB) (copied int64, err error) {
+	var (
+		key        string
+		roots      []*storage.Root
+		placements []ChunkPlacement
+		current    []*storage.Root
+		stored     []byte
+		recorded   = make(map[string]bool)
+		targeted   = make(map[string]bool)
+	)
+	if c.IsPacked() {
+		return 0, ErrChunkPacked
+	}
+	if key, err = c.Key(); err != nil {
+		return 0, err
+	}
+	if roots, err = ChunkRoots(rootPath); err != nil {
+		return 0, err
+	}
+	if placements, err = c.Placements(db); err != nil {
+		return 0, err
+	}
+	for _, placement := range placements {
+		recorded[placement.Root] = true
+	}
+	current = PlacedRoots(placements, roots)
+
+	for _, target := range targets {
+		targeted[target.Name] = true
+		if recorded[target.Name] {
+			continue
+		}
+		if stored == nil {
+			if stored, _, err = c.GoodReplica(current, db); err != nil {
+				return copied, err
+			}
+		}
+		if err = target.Store.Put(key, stored); err != nil {
+			return copied, err
+		}
+		if err = AddChunkPlacement(c.ID, target.Name, db); err != nil {
+			return copied, err
+		}
+		copied += int64(len(stored))
+	}
+
+	for _, root := range current {
+		if targeted[root.Name] {
+			continue
+		}
+		if err = RemoveChunkPlacement(c.ID, root.Name, db); err != nil {
+			return copied, err
+		}
+		if err = root.Store.Delete(key); err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// Key returns the key of chunk in the store, the digits of id are grouped by
+// three as directories, e.g. the key of chunk 1234567 is "1/234/1234567".
+func (c Chunk) Key() (string, error) {
 	var (
 		idStr string
 		parts []string
 		index int
-		dir   string
 	)
 
-	if rootPath == nil {
-		rootPath = &config.DefaultConfig.Chunk.RootPath
-	}
 	if c.ID < 10000 {
 		return "", ErrInvalidChunkID
 	}
@@ -67,32 +422,38 @@ func (c Chunk) Path(rootPath *string) (path string, err error) {
 	parts[index] = idStr
 	parts = parts[1:]
 	utils.ReverseSlice(parts)
-	dir = filepath.Join(strings.TrimSuffix(*rootPath, string(os.PathSeparator)), filepath.Join(parts...))
-	path = filepath.Join(dir, strconv.FormatUint(c.ID, 10))
-	if !utils.IsDir(dir) {
-		err = os.MkdirAll(dir, os.ModePerm)
+	return path.Join(path.Join(parts...), strconv.FormatUint(c.ID, 10)), nil
+}
+
+// ChunkIDFromKey returns the id of the chunk stored at key, ok is false if key
+// isn't where a chunk is stored.
+func ChunkIDFromKey(key string) (id uint64, ok bool) {
+	var (
+		chunkKey string
+		err      error
+	)
+	if id, err = strconv.ParseUint(path.Base(key), 10, 64); err != nil {
+		return 0, false
 	}
-	return path, err
+	if chunkKey, err = (Chunk{ID: id}).Key(); err != nil || chunkKey != key {
+		return 0, false
+	}
+	return id, true
 }
 
 func (c *Chunk) AppendBytes(p []byte, rootPath *string, db *gorm.DB) (chunk *Chunk, writeCount int, err error) {
 	var (
-		file       *os.File
 		buf        bytes.Buffer
 		oldContent []byte
+		stored     []byte
 		hash       string
-		path       string
 	)
 
 	if len(p) > ChunkSize-c.Size {
 		return nil, 0, ErrChunkExceedLimit
 	}
 
-	if path, err = c.Path(rootPath); err != nil {
-		return
-	}
-
-	if oldContent, err = ioutil.ReadFile(path); err != nil {
+	if oldContent, err = c.Content(rootPath, db); err != nil {
 		return
 	}
 	buf.Write(oldContent)
@@ -102,11 +463,12 @@ func (c *Chunk) AppendBytes(p []byte, rootPath *string, db *gorm.DB) (chunk *Chu
 		return
 	}
 
-	if chunk, err = FindChunkByHash(hash, db); err == nil {
+	if chunk, err = reuseChunkByHash(hash, db); err == nil {
 		return chunk, len(p), nil
 	}
 
-	if count, err := CountObjectChunkByChunkID(c.ID, db); err != nil {
+	// a chunk referred to elsewhere is copied instead of being modified
+	if count, err := lockChunkRefCount(c.ID, db); err != nil {
 		return nil, 0, err
 	} else if count > 1 {
 		newChunk, err := CreateChunkFromBytes(buf.Bytes(), rootPath, db)
@@ -119,23 +481,28 @@ func (c *Chunk) AppendBytes(p []byte, rootPath *string, db *gorm.DB) (chunk *Chu
 	c.Size = buf.Len()
 	c.Hash = hash
 
-	if file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
-		return nil, 0, err
+	// object stores don't support appending, the whole content is written
+	if stored, err = c.encodeContent(buf.Bytes(), db); err != nil {
+		return c, 0, err
 	}
-	defer file.Close()
-
-	if writeCount, err = file.Write(p); err != nil {
+	if err = c.putStored(stored, rootPath, db); err != nil {
 		return c, 0, err
 	}
 
-	return c, writeCount, db.Model(c).Updates(map[string]interface{}{"size": c.Size, "hash": c.Hash}).Error
+	return c, len(p), db.Model(c).Updates(map[string]interface{}{
+		"size":       c.Size,
+		"hash":       c.Hash,
+		"codec":      c.Codec,
+		"storedSize": c.StoredSize,
+		"dataKeyId":  c.DataKeyID,
+	}).Error
 }
 
 func CreateChunkFromBytes(p []byte, rootPath *string, db *gorm.DB) (chunk *Chunk, err error) {
 	var (
 		size    int
-		path    string
 		hashStr string
+		stored  []byte
 	)
 
 	if size = len(p); int64(size) > ChunkSize {
@@ -146,7 +513,7 @@ func CreateChunkFromBytes(p []byte, rootPath *string, db *gorm.DB) (chunk *Chunk
 		return nil, err
 	}
 
-	if chunk, err = FindChunkByHash(hashStr, db); err == nil {
+	if chunk, err = reuseChunkByHash(hashStr, db); err == nil {
 		return chunk, nil
 	}
 
@@ -155,15 +522,15 @@ func CreateChunkFromBytes(p []byte, rootPath *string, db *gorm.DB) (chunk *Chunk
 		Hash: hashStr,
 	}
 
-	if err = db.Set("gorm:insert_option", "ON DUPLICATE KEY UPDATE id=id").Create(chunk).Error; err != nil {
+	if stored, err = chunk.encodeContent(p, db); err != nil {
 		return nil, err
 	}
 
-	if path, err = chunk.Path(rootPath); err != nil {
-		return chunk, err
+	if err = db.Set("gorm:insert_option", "ON DUPLICATE KEY UPDATE id=id").Create(chunk).Error; err != nil {
+		return nil, err
 	}
 
-	if err = ioutil.WriteFile(path, p, 0644); err != nil {
+	if err = chunk.storeNew(stored, rootPath, db); err != nil {
 		return nil, err
 	}
 
@@ -175,28 +542,44 @@ func FindChunkByHash(h string, db *gorm.DB) (*Chunk, error) {
 	return &chunk, db.Where("hash = ?", h).First(&chunk).Error
 }
 
+// reuseChunkByHash finds the chunk to be referenced again among the ones the
+// app in the scope of db may reuse, its updatedAt is refreshed so that the
+// garbage collector keeps it until the reference is committed. It fails if the
+// chunk has been collected meanwhile.
+func reuseChunkByHash(h string, db *gorm.DB) (*Chunk, error) {
+	cond, args, err := reusableChunkCond("chunks", db)
+	if err != nil {
+		return nil, err
+	}
+	chunk := &Chunk{}
+	if err = db.Where("hash = ?", h).Where(cond, args...).First(chunk).Error; err != nil {
+		return nil, err
+	}
+	result := db.Model(chunk).UpdateColumn("updatedAt", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return chunk, nil
+}
+
 func CreateEmptyContentChunk(rootPath *string, db *gorm.DB) (chunk *Chunk, err error) {
-	var (
-		path             string
-		emptyContentHash string
-	)
+	var emptyContentHash string
 	if emptyContentHash, err = utils.Sha256Hash2String(nil); err != nil {
 		return nil, err
 	}
 
-	if chunk, err = FindChunkByHash(emptyContentHash, db); err == nil {
+	if chunk, err = reuseChunkByHash(emptyContentHash, db); err == nil {
 		return chunk, nil
 	}
 
-	chunk = &Chunk{Size: 0, Hash: emptyContentHash}
+	chunk = &Chunk{Size: 0, Hash: emptyContentHash, Codec: compress.None}
 
 	if err = db.Create(chunk).Error; err != nil {
 		return nil, err
 	}
 
-	if path, err = chunk.Path(rootPath); err != nil {
-		return chunk, err
-	}
-
-	return chunk, ioutil.WriteFile(path, nil, 0644)
+	return chunk, chunk.putStored(nil, rootPath, db)
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ChunkPlacement records a root keeping a replica of chunk.
+type ChunkPlacement struct {
+	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	ChunkID   uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:chunkId"`
+	Root      string    `gorm:"type:VARCHAR(64) NOT NULL;column:root"`
+	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+}
+
+func (cp *ChunkPlacement) TableName() string {
+	return "chunk_placements"
+}
+
+func AddChunkPlacement(chunkID uint64, root string, db *gorm.DB) error {
+	return db.Set("gorm:insert_option", "ON DUPLICATE KEY UPDATE root = root").
+		Create(&ChunkPlacement{ChunkID: chunkID, Root: root}).Error
+}
+
+func RemoveChunkPlacement(chunkID uint64, root string, db *gorm.DB) error {
+	return db.Where("chunkId = ? and root = ?", chunkID, root).Delete(&ChunkPlacement{}).Error
+}
+
+// PlacedRoots returns the roots of placements which are configured. All roots
+// are returned if there isn't any, a chunk stored before placements were
+// recorded may be on any of them.
+func PlacedRoots(placements []ChunkPlacement, roots []*storage.Root) []*storage.Root {
+	var placed []*storage.Root
+	for _, placement := range placements {
+		if root := storage.FindRoot(roots, placement.Root); root != nil {
+			placed = append(placed, root)
+		}
+	}
+	if len(placed) == 0 {
+		return roots
+	}
+	return placed
+}
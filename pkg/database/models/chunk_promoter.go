@@ -0,0 +1,88 @@
+package models
+
+import (
+	"sync"
+
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+const promoteQueueSize = 1024
+
+var (
+	promoteQueue   chan uint64
+	promotePending sync.Map
+)
+
+// promote queues a cold chunk which has been read to be moved to hot roots,
+// it does nothing unless the promoter is started.
+func promote(chunkID uint64) {
+	if promoteQueue == nil {
+		return
+	}
+	if _, loaded := promotePending.LoadOrStore(chunkID, true); loaded {
+		return
+	}
+	select {
+	case promoteQueue <- chunkID:
+	default:
+		promotePending.Delete(chunkID)
+	}
+}
+
+// StartPromoter moves the cold chunks which are read back to hot roots in
+// background, onError is called with the chunks which fail to be moved.
+func StartPromoter(rootPath *string, db *gorm.DB, onError func(chunkID uint64, err error)) (stop func()) {
+	var (
+		queue = make(chan uint64, promoteQueueSize)
+		done  = make(chan struct{})
+	)
+	promoteQueue = queue
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case chunkID := <-queue:
+				if err := promoteChunk(chunkID, rootPath, db); err != nil {
+					onError(chunkID, err)
+				}
+				promotePending.Delete(chunkID)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func promoteChunk(chunkID uint64, rootPath *string, db *gorm.DB) error {
+	var (
+		chunk   Chunk
+		key     string
+		roots   []*storage.Root
+		targets []*storage.Root
+		err     error
+	)
+	if err = db.Where("id = ?", chunkID).First(&chunk).Error; err != nil {
+		return err
+	}
+	if chunk.IsPacked() {
+		// packs are kept on hot roots only
+		return nil
+	}
+	if key, err = chunk.Key(); err != nil {
+		return err
+	}
+	if roots, err = ChunkRoots(rootPath); err != nil {
+		return err
+	}
+	if targets, err = storage.Place(key, storage.TierRoots(roots, storage.TierHot), ChunkReplicas()); err != nil {
+		return err
+	}
+	_, err = chunk.MoveTo(targets, rootPath, db)
+	return err
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/envelope"
+
+	"github.com/jinzhu/gorm"
+)
+
+// appScopeKey is set on the db by the operations of an app, so that the
+// chunks written by them are encrypted by the data key of the app.
+const appScopeKey = "medea:appId"
+
+var ErrMasterKeyMismatch = errors.New("data key is wrapped by another master key")
+
+var (
+	dataKeyCache   sync.Map
+	masterKeyOnce  sync.Once
+	masterKeyValue []byte
+	masterKeyErr   error
+)
+
+// DataKey encrypts chunk contents, it is stored wrapped by the master key. A
+// data key without app is shared by all apps which don't have their own.
+type DataKey struct {
+	ID          uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	AppID       uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:appId;DEFAULT:0"`
+	WrappedKey  string    `gorm:"type:VARCHAR(255) NOT NULL;column:wrappedKey"`
+	MasterKeyID string    `gorm:"type:CHAR(16) NOT NULL;column:masterKeyId"`
+	CreatedAt   time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt   time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+}
+
+func (dk *DataKey) TableName() string {
+	return "data_keys"
+}
+
+// MasterKey returns the master key configured, it is loaded once.
+func MasterKey() ([]byte, error) {
+	masterKeyOnce.Do(func() {
+		masterKeyValue, masterKeyErr = envelope.LoadMasterKey(&config.DefaultConfig.Chunk.Encryption)
+	})
+	return masterKeyValue, masterKeyErr
+}
+
+func (dk *DataKey) Unwrap(masterKey []byte) ([]byte, error) {
+	if dk.MasterKeyID != envelope.KeyID(masterKey) {
+		return nil, ErrMasterKeyMismatch
+	}
+	return envelope.Unwrap(masterKey, dk.WrappedKey)
+}
+
+// Rewrap wraps the data key by newMasterKey, the chunks encrypted by it don't
+// need to be rewritten.
+func (dk *DataKey) Rewrap(masterKey, newMasterKey []byte, db *gorm.DB) error {
+	var (
+		key     []byte
+		wrapped string
+		err     error
+	)
+	if key, err = dk.Unwrap(masterKey); err != nil {
+		return err
+	}
+	if wrapped, err = envelope.Wrap(newMasterKey, key); err != nil {
+		return err
+	}
+	dk.WrappedKey = wrapped
+	dk.MasterKeyID = envelope.KeyID(newMasterKey)
+	return db.Model(dk).Updates(map[string]interface{}{
+		"wrappedKey":  dk.WrappedKey,
+		"masterKeyId": dk.MasterKeyID,
+	}).Error
+}
+
+func NewDataKey(appID uint64, db *gorm.DB) (*DataKey, error) {
+	var (
+		masterKey []byte
+		key       []byte
+		wrapped   string
+		err       error
+	)
+	if masterKey, err = MasterKey(); err != nil {
+		return nil, err
+	}
+	if key, err = envelope.NewKey(); err != nil {
+		return nil, err
+	}
+	if wrapped, err = envelope.Wrap(masterKey, key); err != nil {
+		return nil, err
+	}
+	dk := &DataKey{AppID: appID, WrappedKey: wrapped, MasterKeyID: envelope.KeyID(masterKey)}
+	return dk, db.Create(dk).Error
+}
+
+// CreateAppDataKey gives the app its own data key, the chunks written by the
+// app from now on are encrypted by it.
+func CreateAppDataKey(app *App, db *gorm.DB) (*DataKey, error) {
+	dk, err := NewDataKey(app.ID, db)
+	if err != nil {
+		return nil, err
+	}
+	app.DataKeyID = &dk.ID
+	return dk, db.Model(app).Update("dataKeyId", dk.ID).Error
+}
+
+func dataKeyByID(id uint64, db *gorm.DB) ([]byte, error) {
+	var (
+		dk        DataKey
+		masterKey []byte
+		key       []byte
+		err       error
+	)
+	if v, ok := dataKeyCache.Load(id); ok {
+		return v.([]byte), nil
+	}
+	if masterKey, err = MasterKey(); err != nil {
+		return nil, err
+	}
+	if err = db.Where("id = ?", id).First(&dk).Error; err != nil {
+		return nil, err
+	}
+	if key, err = dk.Unwrap(masterKey); err != nil {
+		return nil, err
+	}
+	dataKeyCache.Store(id, key)
+	return key, nil
+}
+
+// appDataKeyID returns the id of the data key of the app in the scope of db,
+// nil if there isn't any app or it doesn't have its own key.
+func appDataKeyID(db *gorm.DB) (*uint64, error) {
+	var app App
+
+	appID, ok := db.Get(appScopeKey)
+	if !ok {
+		return nil, nil
+	}
+	if err := db.Unscoped().Where("id = ?", appID).First(&app).Error; err != nil {
+		return nil, err
+	}
+	return app.DataKeyID, nil
+}
+
+// dataKeyForWrite returns the data key of the app in the scope of db, or the
+// shared one which is created if there isn't any.
+func dataKeyForWrite(db *gorm.DB) (uint64, []byte, error) {
+	var (
+		dk    DataKey
+		keyID *uint64
+		key   []byte
+		err   error
+	)
+
+	if keyID, err = appDataKeyID(db); err != nil {
+		return 0, nil, err
+	}
+	if keyID != nil {
+		key, err = dataKeyByID(*keyID, db)
+		return *keyID, key, err
+	}
+
+	if err = db.Where("appId = 0").Order("id desc").First(&dk).Error; err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return 0, nil, err
+		}
+		var created *DataKey
+		if created, err = NewDataKey(0, db); err != nil {
+			return 0, nil, err
+		}
+		dk = *created
+	}
+
+	key, err = dataKeyByID(dk.ID, db)
+	return dk.ID, key, err
+}
+
+// reusableChunkCond returns the condition on the chunks aliased as alias which
+// the app in the scope of db may refer to by hash instead of storing its own.
+// An app with its own data key only reuses the chunks encrypted by it, so that
+// its content can't be read by any other key, and the other apps don't reuse
+// the chunks encrypted by the key of an app. Empty chunks aren't encrypted,
+// nor is any chunk written while encryption isn't enabled.
+func reusableChunkCond(alias string, db *gorm.DB) (cond string, args []interface{}, err error) {
+	var keyID *uint64
+
+	if !config.DefaultConfig.Chunk.Encryption.Enable {
+		return "1 = 1", nil, nil
+	}
+	if keyID, err = appDataKeyID(db); err != nil {
+		return "", nil, err
+	}
+	if keyID != nil {
+		return fmt.Sprintf("(%[1]s.size = 0 OR %[1]s.dataKeyId = ?)", alias), []interface{}{*keyID}, nil
+	}
+	return fmt.Sprintf(
+		"(%[1]s.size = 0 OR %[1]s.dataKeyId = 0 OR %[1]s.dataKeyId IN (SELECT id FROM data_keys WHERE appId = 0))", alias,
+	), nil, nil
+}
+
+// reusableObjectCond is like reusableChunkCond but for the objects aliased as
+// alias, all of whose chunks must be reusable.
+func reusableObjectCond(alias string, db *gorm.DB) (string, []interface{}, error) {
+	cond, args, err := reusableChunkCond("rc", db)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf(
+		"NOT EXISTS (SELECT 1 FROM object_chunk roc JOIN chunks rc ON rc.id = roc.chunkId WHERE roc.objectId = %s.id AND NOT %s)",
+		alias, cond,
+	), args, nil
+}
+
+func withApp(db *gorm.DB, appID uint64) *gorm.DB {
+	return db.Set(appScopeKey, appID)
+}
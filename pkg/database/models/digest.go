@@ -0,0 +1,183 @@
+package models
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"medea/pkg/config"
+	sha2562 "medea/pkg/utils/sha256"
+	sha5122 "medea/pkg/utils/sha512"
+)
+
+const (
+	DigestSHA256 = "sha256"
+	DigestSHA512 = "sha512"
+	DigestMD5    = "md5"
+	DigestCRC32C = "crc32c"
+)
+
+var ErrUnknownDigest = errors.New("unknown digest algorithm")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ExtraDigests are the digests which can be computed besides sha256, in the
+// order they are reported.
+var ExtraDigests = []string{DigestSHA512, DigestMD5, DigestCRC32C}
+
+func newDigest(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case DigestSHA512:
+		return sha512.New(), nil
+	case DigestMD5:
+		return md5.New(), nil
+	case DigestCRC32C:
+		return crc32.New(crc32cTable), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownDigest, algorithm)
+}
+
+func digestStateText(algorithm string, digest hash.Hash) (string, error) {
+	if algorithm == DigestSHA512 {
+		return sha5122.GetHashStateText(digest)
+	}
+	state, err := digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(state), nil
+}
+
+func newDigestWithStateText(algorithm, text string) (hash.Hash, error) {
+	if algorithm == DigestSHA512 {
+		return sha5122.NewHashWithStateText(text)
+	}
+	digest, err := newDigest(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	state, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	return digest, digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+}
+
+// objectHash is the sha256 of an object along with the extra digests of
+// chunk.digests, written in the same pass. Their states are saved on each
+// object chunk, so that hashing can be resumed after it.
+type objectHash struct {
+	hash.Hash
+	digests map[string]hash.Hash
+}
+
+func newObjectHash() *objectHash {
+	h := &objectHash{Hash: sha256.New(), digests: make(map[string]hash.Hash)}
+	for _, algorithm := range config.DefaultConfig.Chunk.Digests {
+		if digest, err := newDigest(algorithm); err == nil {
+			h.digests[algorithm] = digest
+		}
+	}
+	return h
+}
+
+// resumeObjectHash restores the hash after the object chunk oc. A digest
+// whose state wasn't saved, since it was configured later, can't be resumed
+// and is left out.
+func resumeObjectHash(oc *ObjectChunk) (h *objectHash, err error) {
+	var states map[string]string
+
+	h = &objectHash{digests: make(map[string]hash.Hash)}
+	if h.Hash, err = sha2562.NewHashWithStateText(*oc.HashState); err != nil {
+		return nil, err
+	}
+	if oc.DigestStates != nil && len(*oc.DigestStates) > 0 {
+		if err = json.Unmarshal([]byte(*oc.DigestStates), &states); err != nil {
+			return nil, err
+		}
+	}
+	for _, algorithm := range config.DefaultConfig.Chunk.Digests {
+		if text, ok := states[algorithm]; ok {
+			if h.digests[algorithm], err = newDigestWithStateText(algorithm, text); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
+
+func (h *objectHash) Write(p []byte) (n int, err error) {
+	for _, digest := range h.digests {
+		if _, err = digest.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return h.Hash.Write(p)
+}
+
+// saveState saves the states of all hashes on oc.
+func (h *objectHash) saveState(oc *ObjectChunk) error {
+	hashState, err := sha2562.GetHashStateText(h.Hash)
+	if err != nil {
+		return err
+	}
+	oc.HashState = &hashState
+	oc.DigestStates = nil
+
+	if len(h.digests) == 0 {
+		return nil
+	}
+	states := make(map[string]string, len(h.digests))
+	for algorithm, digest := range h.digests {
+		if states[algorithm], err = digestStateText(algorithm, digest); err != nil {
+			return err
+		}
+	}
+	text, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	digestStates := string(text)
+	oc.DigestStates = &digestStates
+	return nil
+}
+
+func (h *objectHash) hexSum() string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestsText encodes the hex sums of the extra digests for Object.Digests.
+func (h *objectHash) digestsText() (*string, error) {
+	if len(h.digests) == 0 {
+		return nil, nil
+	}
+	sums := make(map[string]string, len(h.digests))
+	for algorithm, digest := range h.digests {
+		sums[algorithm] = hex.EncodeToString(digest.Sum(nil))
+	}
+	text, err := json.Marshal(sums)
+	if err != nil {
+		return nil, err
+	}
+	digests := string(text)
+	return &digests, nil
+}
+
+// DigestValues returns the hex sums of the object by algorithm, sha256
+// included.
+func (o *Object) DigestValues() map[string]string {
+	sums := make(map[string]string)
+	if o.Digests != nil && len(*o.Digests) > 0 {
+		_ = json.Unmarshal([]byte(*o.Digests), &sums)
+	}
+	sums[DigestSHA256] = o.Hash
+	return sums
+}
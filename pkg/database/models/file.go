@@ -16,30 +16,42 @@ const IsDir = int8(1)
 
 const Hidden = int8(1)
 
+const (
+	ConflictFail      = int8(0)
+	ConflictOverwrite = int8(1)
+	ConflictRename    = int8(2)
+)
+
 var (
 	ErrFileExisted       = errors.New("file has already existed")
 	ErrOverwriteDir      = errors.New("directory can't be overwritten")
 	ErrAppendToDir       = errors.New("can't append data to directory")
+	ErrWriteToDir        = errors.New("can't write data to directory")
 	ErrReadDir           = errors.New("can't read a directory")
 	ErrAccessDenied      = errors.New("file can't be accessed by some tokens")
 	ErrDeleteNonEmptyDir = errors.New("delete non-empty directory")
+	ErrFileTrashed       = errors.New("the file has been deleted")
+	ErrCopyIntoItself    = errors.New("directory can't be copied into itself")
+	ErrComposeDir        = errors.New("directory can't be composed")
+	ErrSkipDir           = errors.New("skip the directory")
 )
 
 type File struct {
-	ID            uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
-	UID           string     `gorm:"type:CHAR(32) NOT NULL;UNIQUE;column:uid"`
-	PID           uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:pid"`
-	AppID         uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:appId"`
-	ObjectID      uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:objectId"`
-	Size          int        `gorm:"type:int;column:size"`
-	Name          string     `gorm:"type:VARCHAR(255);NOT NULL;column:name"`
-	Ext           string     `gorm:"type:VARCHAR(255);NOT NULL;column:ext"`
-	IsDir         int8       `gorm:"type:tinyint;column:isDir;DEFAULT:0"`
-	Hidden        int8       `gorm:"type:tinyint;column:hidden;DEFAULT:0"`
-	DownloadCount uint64     `gorm:"type:BIGINT(20);column:downloadCount;DEFAULT:0"`
-	CreatedAt     time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
-	UpdatedAt     time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
-	DeletedAt     *time.Time `gorm:"type:TIMESTAMP(6);INDEX;column:deletedAt"`
+	ID             uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	UID            string     `gorm:"type:CHAR(32) NOT NULL;UNIQUE;column:uid"`
+	PID            uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:pid"`
+	AppID          uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:appId"`
+	ObjectID       uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:objectId"`
+	Size           int        `gorm:"type:int;column:size"`
+	Name           string     `gorm:"type:VARCHAR(255);NOT NULL;column:name"`
+	Ext            string     `gorm:"type:VARCHAR(255);NOT NULL;column:ext"`
+	IsDir          int8       `gorm:"type:tinyint;column:isDir;DEFAULT:0"`
+	Hidden         int8       `gorm:"type:tinyint;column:hidden;DEFAULT:0"`
+	DownloadCount  uint64     `gorm:"type:BIGINT(20);column:downloadCount;DEFAULT:0"`
+	LastAccessedAt *time.Time `gorm:"type:TIMESTAMP(6);column:lastAccessedAt"`
+	CreatedAt      time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt      time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+	DeletedAt      *time.Time `gorm:"type:TIMESTAMP(6);INDEX;column:deletedAt"`
 
 	App       App       `gorm:"foreignkey:appId;association_autoupdate:false;association_autocreate:false"`
 	Object    Object    `gorm:"foreignkey:objectId;association_autoupdate:false;association_autocreate:false"`
@@ -176,7 +188,21 @@ func (f *File) UpdateParentSize(size int, db *gorm.DB) error {
 }
 
 func (f *File) createHistory(objectID uint64, path string, db *gorm.DB) error {
-	return db.Save(&History{ObjectID: objectID, FileID: f.ID, Path: path}).Error
+	if err := db.Save(&History{ObjectID: objectID, FileID: f.ID, Path: path}).Error; err != nil {
+		return err
+	}
+	return AddObjectRef(objectID, 1, db)
+}
+
+// setObjectRef moves the reference of file from object previousID to objectID.
+func setObjectRef(previousID, objectID uint64, db *gorm.DB) error {
+	if previousID == objectID {
+		return nil
+	}
+	if err := AddObjectRef(previousID, -1, db); err != nil {
+		return err
+	}
+	return AddObjectRef(objectID, 1, db)
 }
 
 func (f *File) OverWriteFromReader(reader io.Reader, hidden int8, rootPath *string, db *gorm.DB) (err error) {
@@ -184,10 +210,24 @@ func (f *File) OverWriteFromReader(reader io.Reader, hidden int8, rootPath *stri
 		return ErrOverwriteDir
 	}
 
+	var object *Object
+
+	if object, err = CreateObjectFromReader(reader, rootPath, withApp(db, f.AppID)); err != nil {
+		return err
+	}
+
+	return f.OverWriteWithObject(object, hidden, db)
+}
+
+func (f *File) OverWriteWithObject(object *Object, hidden int8, db *gorm.DB) (err error) {
+	if f.IsDir == IsDir {
+		return ErrOverwriteDir
+	}
+
 	var (
-		p        string
-		object   *Object
-		sizeDiff int
+		p          string
+		sizeDiff   int
+		previousID = f.ObjectID
 	)
 
 	if p, err = f.Path(db); err != nil {
@@ -198,10 +238,6 @@ func (f *File) OverWriteFromReader(reader io.Reader, hidden int8, rootPath *stri
 		return err
 	}
 
-	if object, err = CreateObjectFromReader(reader, rootPath, db); err != nil {
-		return err
-	}
-
 	f.Object = *object
 	f.ObjectID = object.ID
 	f.Hidden = hidden
@@ -215,6 +251,9 @@ func (f *File) OverWriteFromReader(reader io.Reader, hidden int8, rootPath *stri
 	}).Error; err != nil {
 		return err
 	}
+	if err = setObjectRef(previousID, object.ID, db); err != nil {
+		return err
+	}
 	db.Preload("Parent").Preload("App").Find(f)
 	return f.Parent.UpdateParentSize(sizeDiff, db)
 }
@@ -295,21 +334,85 @@ func (f *File) MoveTo(newPath string, db *gorm.DB) (err error) {
 	return db.Model(f).Updates(map[string]interface{}{"pid": f.PID, "name": f.Name, "ext": f.Ext}).Error
 }
 
+// CopyTo copies file to dstPath of app, the copies refer to the same objects
+// unless app may not reuse them by its data key, whose content is stored again.
+// A directory is copied with all its files, onConflict decides what to do for
+// each path which is already taken, an existing directory is merged into when
+// overwriting.
+func (f *File) CopyTo(app *App, dstPath string, onConflict int8, rootPath *string, db *gorm.DB) (file *File, err error) {
+	var srcPath string
+
+	if srcPath, err = f.Path(db); err != nil {
+		return nil, err
+	}
+	if f.IsDir == IsDir && f.AppID == app.ID && (dstPath == srcPath || strings.HasPrefix(dstPath, strings.TrimSuffix(srcPath, "/")+"/")) {
+		return nil, ErrCopyIntoItself
+	}
+
+	return f.copyTo(app, dstPath, onConflict, rootPath, db)
+}
+
+func (f *File) copyTo(app *App, dstPath string, onConflict int8, rootPath *string, db *gorm.DB) (file *File, err error) {
+	if f.IsDir != IsDir {
+		object := &Object{}
+		if err = db.Where("id = ?", f.ObjectID).First(object).Error; err != nil {
+			return nil, err
+		}
+		if object, err = object.copyForApp(app, rootPath, db); err != nil {
+			return nil, err
+		}
+		return SaveObjectToPath(app, dstPath, object, f.Hidden, onConflict, db)
+	}
+
+	if file, err = FindFileByPathWithTrashed(app, dstPath, db); err != nil && !utils.IsRecordNotFound(err) {
+		return nil, err
+	}
+	if file != nil && file.ID != 0 {
+		switch {
+		case onConflict == ConflictRename:
+			dstPath = RenamedPath(dstPath)
+		case onConflict != ConflictOverwrite:
+			return nil, ErrFileExisted
+		case file.DeletedAt != nil:
+			return nil, ErrFileTrashed
+		case file.IsDir != IsDir:
+			return nil, ErrOverwriteDir
+		}
+	}
+	if file, err = CreateOrGetLastDirectory(app, dstPath, db); err != nil {
+		return nil, err
+	}
+
+	var children []File
+	if err = db.Where("pid = ?", f.ID).Find(&children).Error; err != nil {
+		return nil, err
+	}
+	for index := range children {
+		child := &children[index]
+		if _, err = child.copyTo(app, strings.TrimSuffix(dstPath, "/")+"/"+child.Name, onConflict, rootPath, db); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
 func (f *File) AppendFromReader(reader io.Reader, hidden int8, rootPath *string, db *gorm.DB) (err error) {
 	if f.IsDir == IsDir {
 		return ErrAppendToDir
 	}
 
 	var (
-		size   int
-		object *Object
+		size       int
+		object     *Object
+		previousID uint64
 	)
 
 	if err = db.Preload("Object").Preload("Parent").Preload("App").First(f).Error; err != nil {
 		return err
 	}
+	previousID = f.ObjectID
 
-	if object, size, err = f.Object.AppendFromReader(reader, rootPath, db); err != nil {
+	if object, size, err = f.Object.AppendFromReader(reader, rootPath, withApp(db, f.AppID)); err != nil {
 		return err
 	}
 
@@ -321,10 +424,86 @@ func (f *File) AppendFromReader(reader io.Reader, hidden int8, rootPath *string,
 	if err = db.Model(f).Updates(map[string]interface{}{"hidden": f.Hidden, "size": f.Size, "objectId": f.ObjectID}).Error; err != nil {
 		return err
 	}
+	if err = setObjectRef(previousID, f.ObjectID, db); err != nil {
+		return err
+	}
 
 	return f.Parent.UpdateParentSize(size, db)
 }
 
+// WriteAtDraft stores the content of reader written at offset of the file as
+// the draft of its next version, which is made by OverWriteWithObject once the
+// draft is saved, so that the previous version is kept as a history.
+func (f *File) WriteAtDraft(offset int, reader io.Reader, rootPath *string, db *gorm.DB) (draft *ObjectDraft, written int, err error) {
+	if f.IsDir == IsDir {
+		return nil, 0, ErrWriteToDir
+	}
+
+	if err = db.Preload("Object").First(f).Error; err != nil {
+		return nil, 0, err
+	}
+	if draft, written, err = f.Object.WriteAt(offset, reader, rootPath, withApp(db, f.AppID)); err != nil {
+		return nil, written, err
+	}
+
+	draft.appID = f.AppID
+	return draft, written, nil
+}
+
+// Truncate cuts the file to size, the previous version is kept as a history
+// like overwriting.
+func (f *File) Truncate(size int, rootPath *string, db *gorm.DB) (err error) {
+	if f.IsDir == IsDir {
+		return ErrWriteToDir
+	}
+
+	var object *Object
+
+	if err = db.Preload("Object").First(f).Error; err != nil {
+		return err
+	}
+	if object, err = f.Object.Truncate(size, rootPath, withApp(db, f.AppID)); err != nil {
+		return err
+	}
+
+	return f.OverWriteWithObject(object, f.Hidden, db)
+}
+
+// Walk calls fn for every file under the directory f, depth first and in the
+// order of names, along with its path relative to f. The files under a
+// directory are skipped if fn returns ErrSkipDir for it.
+func (f *File) Walk(fn func(file *File, relPath string) error, db *gorm.DB) error {
+	return f.walk("", fn, db)
+}
+
+func (f *File) walk(prefix string, fn func(file *File, relPath string) error, db *gorm.DB) error {
+	var children []File
+
+	if err := db.Preload("Object").Where("appId = ? and pid = ?", f.AppID, f.ID).Order("name asc").Find(&children).Error; err != nil {
+		return err
+	}
+
+	for index := range children {
+		var (
+			child   = &children[index]
+			relPath = path.Join(prefix, child.Name)
+			err     = fn(child, relPath)
+		)
+		if err == ErrSkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if child.IsDir == IsDir {
+			if err = child.walk(relPath, fn, db); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func CreateOrGetLastDirectory(app *App, dirPath string, db *gorm.DB) (*File, error) {
 	var (
 		parent = &File{ID: 0}
@@ -370,8 +549,21 @@ func CreateOrGetRootPath(app *App, db *gorm.DB) (*File, error) {
 }
 
 func CreateFileFromReader(app *App, savePath string, reader io.Reader, hidden int8, rootPath *string, db *gorm.DB) (file *File, err error) {
+	var object *Object
+
+	if f, err := FindFileByPathWithTrashed(app, savePath, db); err == nil && f.ID > 0 {
+		return nil, ErrFileExisted
+	}
+
+	if object, err = CreateObjectFromReader(reader, rootPath, withApp(db, app.ID)); err != nil {
+		return nil, err
+	}
+
+	return CreateFileWithObject(app, savePath, object, hidden, db)
+}
+
+func CreateFileWithObject(app *App, savePath string, object *Object, hidden int8, db *gorm.DB) (file *File, err error) {
 	var (
-		object    *Object
 		parentDir *File
 		dirPrefix = path.Dir(savePath)
 		fileName  = path.Base(savePath)
@@ -385,10 +577,6 @@ func CreateFileFromReader(app *App, savePath string, reader io.Reader, hidden in
 		return nil, err
 	}
 
-	if object, err = CreateObjectFromReader(reader, rootPath, db); err != nil {
-		return nil, err
-	}
-
 	file = &File{
 		UID:      UID(),
 		PID:      parentDir.ID,
@@ -406,10 +594,67 @@ func CreateFileFromReader(app *App, savePath string, reader io.Reader, hidden in
 	if err = db.Create(file).Error; err != nil {
 		return nil, err
 	}
+	if err = AddObjectRef(object.ID, 1, db); err != nil {
+		return nil, err
+	}
 
 	return file, parentDir.UpdateParentSize(object.Size, db)
 }
 
+// ComposeFiles saves the concatenated content of files in order to savePath
+// of app, see ComposeObjects.
+func ComposeFiles(app *App, files []*File, savePath string, hidden, onConflict int8, rootPath *string, db *gorm.DB) (file *File, err error) {
+	var (
+		object  *Object
+		objects = make([]*Object, 0, len(files))
+	)
+
+	for _, f := range files {
+		if f.IsDir == IsDir {
+			return nil, ErrComposeDir
+		}
+		o := &Object{}
+		if err = db.Where("id = ?", f.ObjectID).First(o).Error; err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+
+	if object, err = ComposeObjects(objects, rootPath, withApp(db, app.ID)); err != nil {
+		return nil, err
+	}
+
+	return SaveObjectToPath(app, savePath, object, hidden, onConflict, db)
+}
+
+// SaveObjectToPath points the file of savePath to object, onConflict decides
+// what to do when the path is already taken by another file.
+func SaveObjectToPath(app *App, savePath string, object *Object, hidden, onConflict int8, db *gorm.DB) (file *File, err error) {
+	if file, err = FindFileByPathWithTrashed(app, savePath, db); err != nil && !utils.IsRecordNotFound(err) {
+		return nil, err
+	}
+
+	if file == nil || file.ID == 0 {
+		return CreateFileWithObject(app, savePath, object, hidden, db)
+	}
+
+	switch onConflict {
+	case ConflictOverwrite:
+		if file.DeletedAt != nil {
+			return nil, ErrFileTrashed
+		}
+		return file, file.OverWriteWithObject(object, hidden, db)
+	case ConflictRename:
+		return CreateFileWithObject(app, RenamedPath(savePath), object, hidden, db)
+	}
+
+	return nil, ErrFileExisted
+}
+
+func RenamedPath(p string) string {
+	return fmt.Sprintf("%s/%s_%s", path.Dir(p), RandomWithMD5(256), path.Base(p))
+}
+
 func FindFileByUID(uid string, trashed bool, db *gorm.DB) (*File, error) {
 	var (
 		file = &File{}
@@ -2,23 +2,30 @@ package models
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
-	"hash"
+	"errors"
 	"io"
+	"sort"
 	"time"
 
-	sha2562 "medea/pkg/utils/sha256"
+	"medea/pkg/chunker"
 
 	"github.com/jinzhu/gorm"
 )
 
+var (
+	ErrOffsetOutOfRange   = errors.New("offset is out of the range of object")
+	ErrObjectChunksBroken = errors.New("chunks of object are broken")
+)
+
 type Object struct {
-	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
-	Size      int       `gorm:"type:int;column:size"`
-	Hash      string    `gorm:"type:CHAR(64) NOT NULL;UNIQUE;column:hash"`
-	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
-	UpdatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+	ID         uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	Size       int        `gorm:"type:int;column:size"`
+	Hash       string     `gorm:"type:CHAR(64) NOT NULL;INDEX;column:hash"`
+	Digests    *string    `gorm:"type:TEXT;column:digests"`
+	LastReadAt *time.Time `gorm:"type:TIMESTAMP(6);column:lastReadAt"`
+	RefCount   int        `gorm:"type:int;column:refCount;DEFAULT:0"`
+	CreatedAt  time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt  time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
 
 	Files        []File        `gorm:"foreignkey:objectId;association_autoupdate:false;association_autocreate:false"`
 	Chunks       []Chunk       `gorm:"many2many:object_chunk;association_jointable_foreignkey:chunkId;jointable_foreignkey:objectId;association_autoupdate:false;association_autocreate:false"`
@@ -38,6 +45,13 @@ func (o *Object) ChunkCount(db *gorm.DB) int {
 	return db.Model(o).Association("Chunks").Count()
 }
 
+// OrderedChunks returns all chunks of the object ordered by number.
+func (o *Object) OrderedChunks(db *gorm.DB) (chunks []Chunk, err error) {
+	var joinObjectChunk = "join object_chunk on object_chunk.chunkId = chunks.id and object_chunk.objectId = ?"
+	err = db.Joins(joinObjectChunk, o.ID).Order("object_chunk.number asc").Find(&chunks).Error
+	return chunks, err
+}
+
 func (o *Object) ChunkWithNumber(number int, db *gorm.DB) (chunk *Chunk, err error) {
 	var joinObjectChunk = "join object_chunk on object_chunk.chunkId = chunks.id and object_chunk.objectId = ? and number = ?"
 	chunk = &Chunk{}
@@ -45,13 +59,15 @@ func (o *Object) ChunkWithNumber(number int, db *gorm.DB) (chunk *Chunk, err err
 	return chunk, err
 }
 
+// LastChunk returns the chunk with the highest number, chunks reused by hash
+// or uploaded as parts out of order may have lower ids than earlier ones.
 func (o *Object) LastChunk(db *gorm.DB) (*Chunk, error) {
 	var (
 		joinObjectChunk = "join object_chunk on object_chunk.chunkId = chunks.id and object_chunk.objectId = ?"
 		chunk           = &Chunk{}
 		err             error
 	)
-	err = db.Joins(joinObjectChunk, o.ID).Order("chunks.id desc").First(chunk).Error
+	err = db.Joins(joinObjectChunk, o.ID).Order("object_chunk.number desc").First(chunk).Error
 	return chunk, err
 }
 
@@ -70,7 +86,7 @@ func (o *Object) LastChunkNumber(db *gorm.DB) (int, error) {
 
 func (o *Object) LastObjectChunk(db *gorm.DB) (*ObjectChunk, error) {
 	err := db.Preload("ObjectChunks", func(db *gorm.DB) *gorm.DB {
-		return db.Order("object_chunk.id desc").Limit(1)
+		return db.Order("object_chunk.number desc").Limit(1)
 	}).Find(o).Error
 	if len(o.ObjectChunks) == 0 {
 		return nil, err
@@ -81,7 +97,7 @@ func (o *Object) LastObjectChunk(db *gorm.DB) (*ObjectChunk, error) {
 func (o *Object) completeLastChunk(
 	reader io.Reader,
 	object *Object,
-	stateHash hash.Hash,
+	stateHash *objectHash,
 	readerContentLen *int,
 	rootPath *string,
 	db *gorm.DB,
@@ -93,7 +109,6 @@ func (o *Object) completeLastChunk(
 	if lackSize := ChunkSize - lastChunk.Size; lackSize > 0 {
 		var (
 			chunk          *Chunk
-			hashState      string
 			lackContentBuf = bytes.NewBuffer(nil)
 		)
 
@@ -125,11 +140,10 @@ func (o *Object) completeLastChunk(
 		if _, err := stateHash.Write(lackContent); err != nil {
 			return err
 		}
-		if hashState, err = sha2562.GetHashStateText(stateHash); err != nil {
+		if err = stateHash.saveState(&object.ObjectChunks[len(object.ObjectChunks)-1]); err != nil {
 			return err
 		}
 		*readerContentLen += len(lackContent)
-		object.ObjectChunks[len(object.ObjectChunks)-1].HashState = &hashState
 	}
 	return nil
 }
@@ -138,104 +152,144 @@ func (o *Object) appendRestContent(
 	lastOc *ObjectChunk,
 	reader io.Reader,
 	object *Object,
-	stateHash hash.Hash,
+	stateHash *objectHash,
 	readerContentLen *int,
 	rootPath *string,
 	db *gorm.DB,
-) (err error) {
-	restContentBuf := bytes.NewBuffer(nil)
-	restContentReadOver := false
-	for index := lastOc.Number; ; {
-		var (
-			chunk     *Chunk
-			content   = make([]byte, ChunkSize-restContentBuf.Len())
-			readLen   int
-			hashState string
-		)
+) error {
+	oc, size, err := createChunksFromReader(reader, lastOc.Number, rootPath, stateHash, db)
+	if err != nil {
+		return err
+	}
+	object.ObjectChunks = append(object.ObjectChunks, oc...)
+	*readerContentLen += size
+	return nil
+}
 
-		if readLen, err = reader.Read(content); err != nil {
-			if err == io.EOF {
-				restContentReadOver = true
-			} else {
-				return err
-			}
-		}
+// rechunkLastChunk feeds the last chunk followed by the appended content to
+// the content defined chunker, so the boundaries are the same as if the whole
+// content had been uploaded at once.
+func (o *Object) rechunkLastChunk(
+	lastOc *ObjectChunk,
+	reader io.Reader,
+	object *Object,
+	readerContentLen *int,
+	rootPath *string,
+	db *gorm.DB,
+) (stateHash *objectHash, err error) {
+	var (
+		lastChunk *Chunk
+		content   []byte
+		oc        []ObjectChunk
+		rest      []ObjectChunk
+		size      int
+		reusedID  uint64
+	)
 
-		if readLen > 0 {
-			if _, err = restContentBuf.Write(content[:readLen]); err != nil {
-				return err
-			}
-		}
+	if lastChunk, err = o.ChunkWithNumber(lastOc.Number, db); err != nil {
+		return nil, err
+	}
+	if content, err = lastChunk.Content(rootPath, db); err != nil {
+		return nil, err
+	}
 
-		if (restContentReadOver || restContentBuf.Len() == ChunkSize) && restContentBuf.Len() > 0 {
-			writeContent := restContentBuf.Bytes()
-			if chunk, err = CreateChunkFromBytes(writeContent, rootPath, db); err != nil {
-				return err
-			}
-			if _, err := stateHash.Write(writeContent); err != nil {
-				return err
-			}
-			if hashState, err = sha2562.GetHashStateText(stateHash); err != nil {
-				return err
-			}
-			object.ObjectChunks = append(object.ObjectChunks, ObjectChunk{
-				ChunkID:   chunk.ID,
-				Number:    index + 1,
-				HashState: &hashState,
-			})
-			*readerContentLen += len(writeContent)
-			restContentBuf.Reset()
-			index++
+	stateHash = newObjectHash()
+	for _, item := range object.ObjectChunks {
+		if item.Number == lastOc.Number {
+			reusedID = item.ID
+			continue
 		}
-		if restContentReadOver {
-			break
+		if item.Number == lastOc.Number-1 {
+			if stateHash, err = resumeObjectHash(&item); err != nil {
+				return nil, err
+			}
 		}
+		rest = append(rest, item)
 	}
-	return nil
+
+	reader = io.MultiReader(bytes.NewReader(content), reader)
+	if oc, size, err = createChunksFromReader(reader, lastOc.Number-1, rootPath, stateHash, db); err != nil {
+		return nil, err
+	}
+
+	if len(oc) == 0 {
+		return resumeObjectHash(lastOc)
+	}
+
+	oc[0].ID = reusedID
+	object.ObjectChunks = append(rest, oc...)
+	*readerContentLen += size - len(content)
+	return stateHash, nil
 }
 
 func (o *Object) AppendFromReader(reader io.Reader, rootPath *string, db *gorm.DB) (object *Object, readerContentLen int, err error) {
 	var (
 		lastOc     *ObjectChunk
-		stateHash  hash.Hash
+		stateHash  *objectHash
+		refCount   int
 		objectSize = o.Size
 	)
 	if lastOc, err = o.LastObjectChunk(db); err != nil {
 		return o, readerContentLen, err
 	}
-	if stateHash, err = sha2562.NewHashWithStateText(*lastOc.HashState); err != nil {
-		return o, readerContentLen, err
-	}
 	object = &Object{}
 	if err = db.Where("objectId = ?", o.ID).Find(&object.ObjectChunks).Error; err != nil {
 		return o, readerContentLen, err
 	}
 
-	if o.FileCountWithTrashed(db)+db.Model(o).Association("Histories").Count() <= 1 {
+	// object is modified in place only if no other file or history refers to
+	// it, otherwise a new object is made from its chunks, which are referred
+	// to by the new object from now on so that they aren't modified in place
+	if refCount, err = lockObjectRefCount(o.ID, db); err != nil {
+		return o, readerContentLen, err
+	}
+	if refCount <= 1 {
 		object.ID = o.ID
+		object.RefCount = refCount
+		object.LastReadAt = o.LastReadAt
 		object.CreatedAt = o.CreatedAt
 		object.UpdatedAt = o.UpdatedAt
 	} else {
 		for index := range object.ObjectChunks {
 			object.ObjectChunks[index].ID = 0
 		}
+		if err = AddChunkRefs(objectChunkIDs(object.ObjectChunks), 1, db); err != nil {
+			return o, readerContentLen, err
+		}
 	}
+	referred := objectChunkIDs(object.ObjectChunks)
 
-	if err = o.completeLastChunk(reader, object, stateHash, &readerContentLen, rootPath, db); err != nil {
-		return o, readerContentLen, err
-	}
+	if isContentDefinedChunking() {
+		if stateHash, err = o.rechunkLastChunk(lastOc, reader, object, &readerContentLen, rootPath, db); err != nil {
+			return o, readerContentLen, err
+		}
+	} else {
+		if stateHash, err = resumeObjectHash(lastOc); err != nil {
+			return o, readerContentLen, err
+		}
 
-	if err = o.appendRestContent(lastOc, reader, object, stateHash, &readerContentLen, rootPath, db); err != nil {
-		return o, readerContentLen, err
+		if err = o.completeLastChunk(reader, object, stateHash, &readerContentLen, rootPath, db); err != nil {
+			return o, readerContentLen, err
+		}
+
+		if err = o.appendRestContent(lastOc, reader, object, stateHash, &readerContentLen, rootPath, db); err != nil {
+			return o, readerContentLen, err
+		}
 	}
 
-	objectHashValue := hex.EncodeToString(stateHash.Sum(nil))
-	if object, err := FindObjectByHash(objectHashValue, db); err == nil && object != nil {
-		return object, readerContentLen, nil
+	objectHashValue := stateHash.hexSum()
+	if reused, err := reuseObjectByHash(objectHashValue, db); err == nil && reused != nil {
+		if object.ID == o.ID {
+			return reused, readerContentLen, nil
+		}
+		return reused, readerContentLen, AddChunkRefs(referred, -1, db)
 	}
 
 	object.Size = objectSize + readerContentLen
 	object.Hash = objectHashValue
+	if object.Digests, err = stateHash.digestsText(); err != nil {
+		return
+	}
 	if err = db.Save(object).Error; err != nil {
 		return
 	}
@@ -246,100 +300,554 @@ func (o *Object) AppendFromReader(reader io.Reader, rootPath *string, db *gorm.D
 			return
 		}
 	}
+	if err = AddChunkRefs(objectChunkIDs(object.ObjectChunks), 1, db); err != nil {
+		return
+	}
 
-	return object, readerContentLen, nil
+	return object, readerContentLen, AddChunkRefs(referred, -1, db)
+}
+
+// orderedObjectChunks returns the object chunks of o ordered by number, their
+// chunks and the offset of object at which each chunk starts.
+func (o *Object) orderedObjectChunks(db *gorm.DB) (ocs []ObjectChunk, chunks []Chunk, starts []int, err error) {
+	if err = db.Where("objectId = ?", o.ID).Order("number asc").Find(&ocs).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	if chunks, err = o.OrderedChunks(db); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(chunks) != len(ocs) || len(chunks) == 0 {
+		return nil, nil, nil, ErrObjectChunksBroken
+	}
+
+	starts = make([]int, len(chunks))
+	for index := 1; index < len(chunks); index++ {
+		starts[index] = starts[index-1] + chunks[index-1].Size
+	}
+	return ocs, chunks, starts, nil
+}
+
+// chunkIndexAt returns the index of the chunk which the byte at pos belongs
+// to, pos at the end of object belongs to the last chunk.
+func chunkIndexAt(starts []int, pos int) int {
+	index := sort.Search(len(starts), func(i int) bool { return starts[i] > pos }) - 1
+	if index < 0 {
+		return 0
+	}
+	return index
+}
+
+// hashStateBefore returns the hash of object restored to the state before the
+// chunk at index.
+func hashStateBefore(ocs []ObjectChunk, index int) (*objectHash, error) {
+	if index == 0 {
+		return newObjectHash(), nil
+	}
+	return resumeObjectHash(&ocs[index-1])
+}
+
+// keptObjectChunks copies the object chunks before index for a new object.
+func keptObjectChunks(ocs []ObjectChunk, index int) []ObjectChunk {
+	kept := make([]ObjectChunk, index)
+	copy(kept, ocs[:index])
+	for i := range kept {
+		kept[i].ID = 0
+		kept[i].ObjectID = 0
+		kept[i].Number = i + 1
+	}
+	return kept
+}
+
+// WriteAt makes the draft of a new object whose content is the content of o
+// overwritten by the content of reader from offset, which may extend it. Only
+// the chunks overlapped by the written content are rewritten, the chunks
+// before are shared as is, the chunks after are shared too, but read once to
+// hash the new content.
+func (o *Object) WriteAt(offset int, reader io.Reader, rootPath *string, db *gorm.DB) (draft *ObjectDraft, written int, err error) {
+	if offset < 0 || offset > o.Size {
+		return nil, 0, ErrOffsetOutOfRange
+	}
+
+	var (
+		ocs       []ObjectChunk
+		chunks    []Chunk
+		starts    []int
+		content   []byte
+		stateHash *objectHash
+		oc        []ObjectChunk
+		size      int
+		first     int
+		last      int
+		counter   = &countReader{reader: reader}
+	)
+
+	if ocs, chunks, starts, err = o.orderedObjectChunks(db); err != nil {
+		return nil, 0, err
+	}
+	first = chunkIndexAt(starts, offset)
+	if stateHash, err = hashStateBefore(ocs, first); err != nil {
+		return nil, 0, err
+	}
+	if content, err = chunks[first].Content(rootPath, db); err != nil {
+		return nil, 0, err
+	}
+
+	// the rest of the last overlapped chunk is known only once reader ends
+	suffix := &lazyReader{open: func() (io.Reader, error) {
+		var (
+			end         = offset + counter.count
+			lastContent = content
+			err         error
+		)
+		last = first
+		if end > offset {
+			if last = chunkIndexAt(starts, end-1); last < first {
+				last = first
+			}
+		}
+		if last != first {
+			if lastContent, err = chunks[last].Content(rootPath, db); err != nil {
+				return nil, err
+			}
+		}
+		if rest := end - starts[last]; rest < len(lastContent) {
+			return bytes.NewReader(lastContent[rest:]), nil
+		}
+		return bytes.NewReader(nil), nil
+	}}
+
+	reader = io.MultiReader(bytes.NewReader(content[:offset-starts[first]]), counter, suffix)
+	oc, size, err = createChunksFromReader(reader, first, rootPath, stateHash, db)
+	if err != nil {
+		return nil, 0, err
+	}
+	oc = append(keptObjectChunks(ocs, first), oc...)
+	size += starts[first]
+
+	for index := last + 1; index < len(chunks); index++ {
+		item := ObjectChunk{ChunkID: chunks[index].ID, Number: len(oc) + 1}
+		if content, err = chunks[index].Content(rootPath, db); err != nil {
+			return nil, 0, err
+		}
+		if _, err = stateHash.Write(content); err != nil {
+			return nil, 0, err
+		}
+		if err = stateHash.saveState(&item); err != nil {
+			return nil, 0, err
+		}
+		oc = append(oc, item)
+		size += len(content)
+	}
+
+	return &ObjectDraft{oc: oc, size: size, hash: stateHash}, counter.count, nil
+}
+
+// Truncate makes a new object whose content is the first size bytes of o,
+// the chunks before the cut are shared, only the chunk cut is rewritten.
+func (o *Object) Truncate(size int, rootPath *string, db *gorm.DB) (object *Object, err error) {
+	if size < 0 || size > o.Size {
+		return nil, ErrOffsetOutOfRange
+	}
+	if size == o.Size {
+		return o, nil
+	}
+	if size == 0 {
+		return CreateEmptyObject(rootPath, db)
+	}
+
+	var (
+		ocs       []ObjectChunk
+		chunks    []Chunk
+		starts    []int
+		content   []byte
+		stateHash *objectHash
+		oc        []ObjectChunk
+		cut       int
+	)
+
+	if ocs, chunks, starts, err = o.orderedObjectChunks(db); err != nil {
+		return nil, err
+	}
+	cut = chunkIndexAt(starts, size-1)
+	if stateHash, err = hashStateBefore(ocs, cut); err != nil {
+		return nil, err
+	}
+	if content, err = chunks[cut].Content(rootPath, db); err != nil {
+		return nil, err
+	}
+
+	reader := bytes.NewReader(content[:size-starts[cut]])
+	if oc, _, err = createChunksFromReader(reader, cut, rootPath, stateHash, db); err != nil {
+		return nil, err
+	}
+	oc = append(keptObjectChunks(ocs, cut), oc...)
+
+	return saveObjectWithChunks(oc, size, stateHash, db)
+}
+
+// ComposeObjects makes an object whose content is the content of objects in
+// order. The chunks are referred to as they are, only the last chunk of an
+// object which isn't full is rechunked along with the first chunk of the
+// next one. The hash states saved for the first object are reused, the
+// chunks after it are read once to hash them.
+func ComposeObjects(objects []*Object, rootPath *string, db *gorm.DB) (object *Object, err error) {
+	var (
+		oc        []ObjectChunk
+		size      int
+		stateHash *objectHash
+	)
+
+	for _, o := range objects {
+		var (
+			ocs    []ObjectChunk
+			chunks []Chunk
+			start  int
+		)
+		if o.Size == 0 {
+			continue
+		}
+		if ocs, chunks, _, err = o.orderedObjectChunks(db); err != nil {
+			return nil, err
+		}
+		size += o.Size
+
+		if len(oc) == 0 {
+			oc = keptObjectChunks(ocs, len(ocs))
+			if stateHash, err = resumeObjectHash(&ocs[len(ocs)-1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if oc, stateHash, err = rechunkBoundary(oc, &chunks[0], rootPath, db); err != nil {
+			return nil, err
+		}
+		if stateHash == nil {
+			if stateHash, err = resumeObjectHash(&oc[len(oc)-1]); err != nil {
+				return nil, err
+			}
+		} else {
+			start = 1
+		}
+
+		for index := start; index < len(chunks); index++ {
+			var (
+				content []byte
+				item    = ObjectChunk{ChunkID: chunks[index].ID, Number: len(oc) + 1}
+			)
+			if content, err = chunks[index].Content(rootPath, db); err != nil {
+				return nil, err
+			}
+			if _, err = stateHash.Write(content); err != nil {
+				return nil, err
+			}
+			if err = stateHash.saveState(&item); err != nil {
+				return nil, err
+			}
+			oc = append(oc, item)
+		}
+	}
+
+	if size == 0 {
+		return CreateEmptyObject(rootPath, db)
+	}
+	return saveObjectWithChunks(oc, size, stateHash, db)
+}
+
+// rechunkBoundary rechunks the last chunk of oc along with next if the last
+// chunk doesn't end at a cut, that is it isn't full under fixed size chunking,
+// the hash after them is returned. Otherwise oc is returned as is with a nil
+// hash.
+func rechunkBoundary(oc []ObjectChunk, next *Chunk, rootPath *string, db *gorm.DB) ([]ObjectChunk, *objectHash, error) {
+	var (
+		last        = &Chunk{}
+		lastContent []byte
+		nextContent []byte
+		rechunked   []ObjectChunk
+		stateHash   *objectHash
+		err         error
+	)
+
+	if err = db.Where("id = ?", oc[len(oc)-1].ChunkID).First(last).Error; err != nil {
+		return nil, nil, err
+	}
+	if !isContentDefinedChunking() && last.Size >= ChunkSize {
+		return oc, nil, nil
+	}
+	if lastContent, err = last.Content(rootPath, db); err != nil {
+		return nil, nil, err
+	}
+	// chunks of any size may end at a cut under content defined chunking
+	if isContentDefinedChunking() && chunker.IsCut(lastContent, chunkerOptions()) {
+		return oc, nil, nil
+	}
+
+	if stateHash, err = hashStateBefore(oc, len(oc)-1); err != nil {
+		return nil, nil, err
+	}
+	if nextContent, err = next.Content(rootPath, db); err != nil {
+		return nil, nil, err
+	}
+
+	reader := io.MultiReader(bytes.NewReader(lastContent), bytes.NewReader(nextContent))
+	if rechunked, _, err = createChunksFromReader(reader, len(oc)-1, rootPath, stateHash, db); err != nil {
+		return nil, nil, err
+	}
+	return append(oc[:len(oc)-1], rechunked...), stateHash, nil
+}
+
+// countReader counts the bytes read from reader.
+type countReader struct {
+	reader io.Reader
+	count  int
+}
+
+func (c *countReader) Read(p []byte) (n int, err error) {
+	n, err = c.reader.Read(p)
+	c.count += n
+	return n, err
+}
+
+// lazyReader reads from the reader made by open on the first read.
+type lazyReader struct {
+	open   func() (io.Reader, error)
+	reader io.Reader
+}
+
+func (l *lazyReader) Read(p []byte) (n int, err error) {
+	if l.reader == nil {
+		if l.reader, err = l.open(); err != nil {
+			return 0, err
+		}
+	}
+	return l.reader.Read(p)
 }
 
 func (o *Object) Reader(rootPath *string, db *gorm.DB) (io.ReadSeeker, error) {
+	if err := o.markRead(db); err != nil {
+		return nil, err
+	}
 	return NewObjectReader(o, rootPath, db)
 }
 
+// lastReadInterval limits how often the read time of an object is written.
+const lastReadInterval = time.Hour
+
+// markRead records the read time of object for tiering, updatedAt is kept
+// since the object isn't modified.
+func (o *Object) markRead(db *gorm.DB) error {
+	now := time.Now()
+	if o.LastReadAt != nil && now.Sub(*o.LastReadAt) < lastReadInterval {
+		return nil
+	}
+	o.LastReadAt = &now
+	return db.Model(o).UpdateColumns(map[string]interface{}{
+		"lastReadAt": now,
+		"updatedAt":  gorm.Expr("updatedAt"),
+	}).Error
+}
+
 func FindObjectByHash(h string, db *gorm.DB) (*Object, error) {
 	var object Object
 	var err = db.Where("hash = ?", h).First(&object).Error
 	return &object, err
 }
 
+// copyForApp returns o if app may reuse it as it is, otherwise a copy of its
+// content stored for app, which is encrypted by the data key of app.
+func (o *Object) copyForApp(app *App, rootPath *string, db *gorm.DB) (*Object, error) {
+	var (
+		count  int
+		reader io.Reader
+	)
+
+	cond, args, err := reusableObjectCond("objects", withApp(db, app.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Table("objects").Where("id = ?", o.ID).Where(cond, args...).Count(&count).Error; err != nil || count > 0 {
+		return o, err
+	}
+
+	if reader, err = NewObjectReader(o, rootPath, db); err != nil {
+		return nil, err
+	}
+	return CreateObjectFromReader(reader, rootPath, withApp(db, app.ID))
+}
+
+// reuseObjectByHash is like reuseChunkByHash but for objects.
+func reuseObjectByHash(h string, db *gorm.DB) (*Object, error) {
+	cond, args, err := reusableObjectCond("objects", db)
+	if err != nil {
+		return nil, err
+	}
+	object := &Object{}
+	if err = db.Where("hash = ?", h).Where(cond, args...).First(object).Error; err != nil {
+		return nil, err
+	}
+	result := db.Model(object).UpdateColumn("updatedAt", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return object, nil
+}
+
 func createChunksForObject(
 	reader io.Reader,
 	rootPath *string,
-	objectHash hash.Hash,
+	objectHash *objectHash,
 	db *gorm.DB,
 ) (oc []ObjectChunk, size int, err error) {
-	var (
-		readerOver bool
-		readerBuf  = bytes.NewBuffer(nil)
-	)
-	for index := 0; ; {
+	return createChunksFromReader(reader, 0, rootPath, objectHash, db)
+}
+
+// createChunksFromReader splits the content of reader into chunks numbered
+// after lastNumber by the configured chunker. Only one chunk sized buffer is
+// used, so the memory usage doesn't grow with the length of reader.
+func createChunksFromReader(
+	reader io.Reader,
+	lastNumber int,
+	rootPath *string,
+	stateHash *objectHash,
+	db *gorm.DB,
+) (oc []ObjectChunk, size int, err error) {
+	var c chunker.Chunker
+
+	if c, err = chunker.New(reader, chunkerOptions()); err != nil {
+		return nil, 0, err
+	}
+
+	for number := lastNumber + 1; ; number++ {
 		var (
-			chunk     *Chunk
-			content   = make([]byte, ChunkSize-readerBuf.Len())
-			readLen   int
-			hashState string
+			chunk   *Chunk
+			content []byte
 		)
-		if readLen, err = reader.Read(content); err != nil {
+
+		if content, err = c.Next(); err != nil {
 			if err == io.EOF {
-				err = nil
-				readerOver = true
-			} else {
-				return
+				return oc, size, nil
 			}
+			return nil, 0, err
 		}
 
-		if readLen > 0 {
-			if _, err = readerBuf.Write(content[:readLen]); err != nil {
-				return
-			}
+		if chunk, err = CreateChunkFromBytes(content, rootPath, db); err != nil {
+			return nil, 0, err
 		}
-
-		if (readerOver || readerBuf.Len() == ChunkSize) && readerBuf.Len() > 0 {
-			writeContent := readerBuf.Bytes()
-			if chunk, err = CreateChunkFromBytes(writeContent, rootPath, db); err != nil {
-				return
-			}
-			if _, err = objectHash.Write(writeContent); err != nil {
-				return
-			}
-			if hashState, err = sha2562.GetHashStateText(objectHash); err != nil {
-				return
-			}
-			oc = append(oc, ObjectChunk{
-				ChunkID:   chunk.ID,
-				Number:    index + 1,
-				HashState: &hashState,
-			})
-			size += len(writeContent)
-			readerBuf.Reset()
-			index++
+		if _, err = stateHash.Write(content); err != nil {
+			return nil, 0, err
 		}
-		if readerOver {
-			break
+		item := ObjectChunk{ChunkID: chunk.ID, Number: number}
+		if err = stateHash.saveState(&item); err != nil {
+			return nil, 0, err
 		}
+		oc = append(oc, item)
+		size += len(content)
 	}
-	return
+}
+
+// ObjectDraft is the content of an object whose chunks are stored but not
+// referred to yet, the object is only made of them once it's saved. Its chunks
+// are kept by the garbage collector for the grace period only.
+type ObjectDraft struct {
+	appID uint64
+	oc    []ObjectChunk
+	size  int
+	hash  *objectHash
+}
+
+func newObjectDraft(reader io.Reader, rootPath *string, db *gorm.DB) (draft *ObjectDraft, err error) {
+	draft = &ObjectDraft{hash: newObjectHash()}
+	if draft.oc, draft.size, err = createChunksForObject(reader, rootPath, draft.hash, db); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// WriteObjectDraft stores the content of reader as chunks of app, it doesn't
+// need to be in the transaction saving the draft, so that a long content isn't
+// read while the locks of the transaction are held.
+func WriteObjectDraft(app *App, reader io.Reader, rootPath *string, db *gorm.DB) (*ObjectDraft, error) {
+	draft, err := newObjectDraft(reader, rootPath, withApp(db, app.ID))
+	if err != nil {
+		return nil, err
+	}
+	draft.appID = app.ID
+	return draft, nil
+}
+
+func (d *ObjectDraft) Size() int {
+	return d.size
+}
+
+// Save makes the object of d, the chunks are referred to by it from now on.
+func (d *ObjectDraft) Save(rootPath *string, db *gorm.DB) (*Object, error) {
+	if d.appID != 0 {
+		db = withApp(db, d.appID)
+	}
+	if d.size == 0 {
+		return CreateEmptyObject(rootPath, db)
+	}
+	return saveObjectWithChunks(d.oc, d.size, d.hash, db)
 }
 
 func CreateObjectFromReader(reader io.Reader, rootPath *string, db *gorm.DB) (object *Object, err error) {
+	var draft *ObjectDraft
+
+	if draft, err = newObjectDraft(reader, rootPath, db); err != nil {
+		return nil, err
+	}
+
+	return draft.Save(rootPath, db)
+}
+
+// CreateObjectFromChunks builds an object from chunks which have already been
+// stored, their content is replayed in order to compute the hash of object.
+func CreateObjectFromChunks(chunks []Chunk, rootPath *string, db *gorm.DB) (object *Object, err error) {
 	var (
 		oc         []ObjectChunk
 		size       int
-		objectHash = sha256.New()
+		objectHash = newObjectHash()
 	)
 
-	if oc, size, err = createChunksForObject(reader, rootPath, objectHash, db); err != nil {
-		return nil, err
+	for index := range chunks {
+		var (
+			reader io.ReadCloser
+			item   = ObjectChunk{ChunkID: chunks[index].ID, Number: index + 1}
+		)
+		if reader, err = chunks[index].Reader(rootPath, db); err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(objectHash, reader)
+		_ = reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err = objectHash.saveState(&item); err != nil {
+			return nil, err
+		}
+		oc = append(oc, item)
+		size += chunks[index].Size
 	}
 
 	if size == 0 {
 		return CreateEmptyObject(rootPath, db)
 	}
 
-	objectHashValue := hex.EncodeToString(objectHash.Sum(nil))
-	if object, err = FindObjectByHash(objectHashValue, db); err == nil && object != nil {
+	return saveObjectWithChunks(oc, size, objectHash, db)
+}
+
+func saveObjectWithChunks(oc []ObjectChunk, size int, h *objectHash, db *gorm.DB) (object *Object, err error) {
+	if object, err = reuseObjectByHash(h.hexSum(), db); err == nil && object != nil {
 		return object, nil
 	}
 
-	object = &Object{Size: size, Hash: objectHashValue}
+	object = &Object{Size: size, Hash: h.hexSum()}
+	if object.Digests, err = h.digestsText(); err != nil {
+		return nil, err
+	}
 	if err = db.Save(object).Error; err != nil {
 		return
 	}
@@ -350,20 +858,20 @@ func CreateObjectFromReader(reader io.Reader, rootPath *string, db *gorm.DB) (ob
 			return
 		}
 	}
-	return object, nil
+	return object, AddChunkRefs(objectChunkIDs(oc), 1, db)
 }
 
 func CreateEmptyObject(rootPath *string, db *gorm.DB) (*Object, error) {
 	var (
-		h                = sha256.New()
+		h                = newObjectHash()
 		err              error
 		chunk            *Chunk
 		object           *Object
-		hashState        string
-		emptyContentHash = hex.EncodeToString(h.Sum(nil))
+		emptyContentHash = h.hexSum()
+		item             = ObjectChunk{Number: 1}
 	)
 
-	if object, err = FindObjectByHash(emptyContentHash, db); err == nil && object != nil {
+	if object, err = reuseObjectByHash(emptyContentHash, db); err == nil && object != nil {
 		return object, nil
 	}
 
@@ -371,21 +879,22 @@ func CreateEmptyObject(rootPath *string, db *gorm.DB) (*Object, error) {
 		return nil, err
 	}
 
-	if hashState, err = sha2562.GetHashStateText(h); err != nil {
+	item.ChunkID = chunk.ID
+	if err = h.saveState(&item); err != nil {
 		return nil, err
 	}
 
 	object = &Object{
-		Size: 0,
-		Hash: emptyContentHash,
-		ObjectChunks: []ObjectChunk{
-			{
-				ChunkID:   chunk.ID,
-				Number:    1,
-				HashState: &hashState,
-			},
-		},
-	}
-
-	return object, db.Set("gorm:association_autocreate", true).Save(object).Error
+		Size:         0,
+		Hash:         emptyContentHash,
+		ObjectChunks: []ObjectChunk{item},
+	}
+	if object.Digests, err = h.digestsText(); err != nil {
+		return nil, err
+	}
+
+	if err = db.Set("gorm:association_autocreate", true).Save(object).Error; err != nil {
+		return nil, err
+	}
+	return object, AddChunkRefs([]uint64{chunk.ID}, 1, db)
 }
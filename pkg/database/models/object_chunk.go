@@ -7,13 +7,14 @@ import (
 )
 
 type ObjectChunk struct {
-	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
-	ObjectID  uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:objectId"`
-	ChunkID   uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:chunkId"`
-	Number    int       `gorm:"type:int;column:number"`
-	HashState *string   `gorm:"type:CHAR(64) NOT NULL;UNIQUE;column:hashState"`
-	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
-	UpdatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+	ID           uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	ObjectID     uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:objectId"`
+	ChunkID      uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:chunkId"`
+	Number       int       `gorm:"type:int;column:number"`
+	HashState    *string   `gorm:"type:CHAR(64) NOT NULL;UNIQUE;column:hashState"`
+	DigestStates *string   `gorm:"type:TEXT;column:digestStates"`
+	CreatedAt    time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt    time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
 
 	Object Object `gorm:"foreignkey:objectId;association_autoupdate:false;association_autocreate:false"`
 	Chunk  Chunk  `gorm:"foreignkey:chunkId;association_autoupdate:false;association_autocreate:false"`
@@ -3,8 +3,7 @@ package models
 import (
 	"errors"
 	"io"
-	"math"
-	"os"
+	"sort"
 
 	"github.com/jinzhu/gorm"
 )
@@ -20,9 +19,10 @@ type objectReader struct {
 	db                 *gorm.DB
 	object             *Object
 	rootPath           *string
-	currentChunkReader *os.File
-	totalChunkNumber   int
-	currentChunkNumber int
+	chunks             []Chunk
+	offsets            []int
+	currentChunkReader io.ReadCloser
+	currentChunkIndex  int
 	alreadyReadCount   int
 }
 
@@ -32,53 +32,58 @@ func NewObjectReader(object *Object, rootPath *string, db *gorm.DB) (io.ReadSeek
 	}
 
 	var (
-		err              error
-		firstChunk       *Chunk
-		chunkReader      *os.File
-		totalChunkNumber int
+		err         error
+		chunks      []Chunk
+		offsets     []int
+		offset      int
+		chunkReader io.ReadCloser
 	)
 
-	if totalChunkNumber, err = object.LastChunkNumber(db); err != nil {
+	if chunks, err = object.OrderedChunks(db); err != nil {
 		return nil, err
 	}
 
-	if totalChunkNumber == 0 {
+	if len(chunks) == 0 {
 		return nil, ErrObjectNoChunks
 	}
 
-	if firstChunk, err = object.ChunkWithNumber(1, db); err != nil {
-		return nil, err
+	// chunks may have different sizes, the start offset of each chunk is
+	// recorded to locate the chunk when seeking
+	offsets = make([]int, len(chunks))
+	for index, chunk := range chunks {
+		offsets[index] = offset
+		offset += chunk.Size
 	}
 
-	if chunkReader, err = firstChunk.Reader(rootPath); err != nil {
+	if chunkReader, err = chunks[0].Reader(rootPath, db); err != nil {
 		return nil, err
 	}
 
 	return &objectReader{
 		db:                 db,
 		object:             object,
-		currentChunkReader: chunkReader,
 		rootPath:           rootPath,
-		currentChunkNumber: 1,
-		totalChunkNumber:   totalChunkNumber,
+		chunks:             chunks,
+		offsets:            offsets,
+		currentChunkReader: chunkReader,
 	}, nil
 }
 
 func (or *objectReader) Read(p []byte) (readCount int, err error) {
 	if or.alreadyReadCount >= or.object.Size {
-		_ = or.currentChunkReader.Close()
+		or.closeCurrentChunk()
 		return 0, io.EOF
 	}
 	defer func() { or.alreadyReadCount += readCount }()
 	readCount, err = or.currentChunkReader.Read(p)
 	if err != nil && err == io.EOF {
-		_ = or.currentChunkReader.Close()
-		or.currentChunkNumber++
-		var nextChunk *Chunk
-		if nextChunk, err = or.object.ChunkWithNumber(or.currentChunkNumber, or.db); err != nil {
-			return
+		nextChunkIndex := or.currentChunkIndex + 1
+		or.closeCurrentChunk()
+		if nextChunkIndex >= len(or.chunks) {
+			return readCount, io.EOF
 		}
-		if or.currentChunkReader, err = nextChunk.Reader(or.rootPath); err != nil {
+		or.currentChunkIndex = nextChunkIndex
+		if or.currentChunkReader, err = or.chunks[or.currentChunkIndex].Reader(or.rootPath, or.db); err != nil {
 			return readCount, err
 		}
 		return readCount, nil
@@ -103,37 +108,29 @@ func (or *objectReader) Seek(offset int64, whence int) (abs int64, err error) {
 	}
 	if abs >= int64(or.object.Size) {
 		or.alreadyReadCount = int(abs)
-		or.currentChunkNumber = or.totalChunkNumber
 		return abs, nil
 	}
+
 	var (
-		currentChunk       *Chunk
-		currentChunkReader *os.File
-		currentChunkNumber = int(math.Ceil(float64(abs) / float64(ChunkSize)))
+		currentChunkReader io.ReadCloser
+		currentChunkIndex  = sort.Search(len(or.offsets), func(i int) bool { return or.offsets[i] > int(abs) }) - 1
+		chunkOffset        = abs - int64(or.offsets[currentChunkIndex])
 	)
 
-	if abs%ChunkSize == 0 {
-		currentChunkNumber++
-	}
-
-	if currentChunkNumber == or.currentChunkNumber {
-		currentChunkReader = or.currentChunkReader
-	} else {
-		if currentChunk, err = or.object.ChunkWithNumber(currentChunkNumber, or.db); err != nil {
-			return 0, nil
-		}
-		if currentChunkReader, err = currentChunk.Reader(or.rootPath); err != nil {
-			return 0, err
-		}
-	}
-	if _, err = currentChunkReader.Seek(abs%ChunkSize, io.SeekStart); err != nil {
+	// the chunk is reopened from the offset, stores can't seek an opened chunk
+	if currentChunkReader, err = or.chunks[currentChunkIndex].RangeReader(chunkOffset, -1, or.rootPath, or.db); err != nil {
 		return 0, err
 	}
-	if currentChunkNumber != or.currentChunkNumber {
-		_ = or.currentChunkReader.Close()
-	}
+	or.closeCurrentChunk()
 	or.currentChunkReader = currentChunkReader
-	or.currentChunkNumber = currentChunkNumber
+	or.currentChunkIndex = currentChunkIndex
 	or.alreadyReadCount = int(abs)
 	return abs, nil
 }
+
+// closeCurrentChunk closes the reader of current chunk, the chunk is reopened
+// by Seek if the object is read again.
+func (or *objectReader) closeCurrentChunk() {
+	_ = or.currentChunkReader.Close()
+	or.currentChunkIndex = -1
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	PackOpen int8 = iota
+	PackSealed
+	PackRetired
+)
+
+// packMaxAge is how long a pack is appended to at most, a pack left open by a
+// process which is gone is taken as sealed after twice as long.
+const packMaxAge = time.Hour
+
+var (
+	ErrChunkPacked   = errors.New("packed chunk can't be moved")
+	ErrPackDiverged  = errors.New("replicas of pack have different sizes")
+	ErrPackerStopped = errors.New("chunks can't be packed, the packer isn't started or no local hot root is configured")
+)
+
+// Pack is an append-only file keeping small chunks, each packed chunk records
+// its pack, offset and stored size. The placements of a packed chunk are the
+// roots of its pack.
+type Pack struct {
+	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	Roots     string    `gorm:"type:VARCHAR(255) NOT NULL;column:roots"`
+	Status    int8      `gorm:"type:TINYINT(4) NOT NULL;column:status;DEFAULT:0"`
+	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+}
+
+func (p *Pack) TableName() string {
+	return "packs"
+}
+
+// PackKey returns the key of pack in the store, which isn't a chunk key.
+func PackKey(id uint64) string {
+	return "packs/" + strconv.FormatUint(id, 10)
+}
+
+func (p *Pack) Key() string {
+	return PackKey(p.ID)
+}
+
+// PlacedRoots returns the roots of pack which are configured.
+func (p *Pack) PlacedRoots(roots []*storage.Root) []*storage.Root {
+	var placed []*storage.Root
+	for _, name := range strings.Split(p.Roots, ",") {
+		if root := storage.FindRoot(roots, name); root != nil {
+			placed = append(placed, root)
+		}
+	}
+	return placed
+}
+
+// IsClosed tells whether pack isn't appended to anymore.
+func (p *Pack) IsClosed() bool {
+	return p.Status != PackOpen || time.Since(p.CreatedAt) > 2*packMaxAge
+}
+
+func (p *Pack) Retire(db *gorm.DB) error {
+	p.Status = PackRetired
+	return db.Model(p).Update("status", PackRetired).Error
+}
+
+type packWriter struct {
+	mutex sync.Mutex
+	db    *gorm.DB
+	pack  *Pack
+	roots []*storage.Root
+	size  int64
+}
+
+var packer packWriter
+
+// StartPacker makes the small chunks written from now on appended to packs,
+// the packs are recorded by db, outside of the transactions writing chunks.
+func StartPacker(db *gorm.DB) (stop func()) {
+	packer.mutex.Lock()
+	packer.db = db
+	packer.mutex.Unlock()
+
+	return func() {
+		packer.mutex.Lock()
+		packer.seal()
+		packer.db = nil
+		packer.mutex.Unlock()
+	}
+}
+
+// packStored appends stored to the current pack on roots, and returns the
+// pack, the roots keeping it and the offset stored is at. pack is nil if the
+// packer isn't started, stored isn't small, or no hot root can be appended to.
+func packStored(stored []byte, roots []*storage.Root) (pack *Pack, packRoots []*storage.Root, offset int64, err error) {
+	var conf = config.DefaultConfig.Chunk.Pack
+
+	if len(stored) == 0 || len(stored) >= conf.Threshold {
+		return nil, nil, 0, nil
+	}
+
+	packer.mutex.Lock()
+	defer packer.mutex.Unlock()
+
+	if packer.db == nil {
+		return nil, nil, 0, nil
+	}
+	if packer.pack != nil && (packer.size+int64(len(stored)) > conf.MaxSize || time.Since(packer.pack.CreatedAt) > packMaxAge) {
+		packer.seal()
+	}
+	if packer.pack == nil {
+		if err = packer.open(roots); err != nil || packer.pack == nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	for _, root := range packer.roots {
+		var at int64
+		if at, err = root.Store.(storage.Appender).Append(packer.pack.Key(), stored); err == nil && at != packer.size {
+			err = ErrPackDiverged
+		}
+		if err != nil {
+			// bytes appended to some replicas are dead, the pack is given up
+			packer.seal()
+			return nil, nil, 0, err
+		}
+	}
+	offset = packer.size
+	packer.size += int64(len(stored))
+	return packer.pack, packer.roots, offset, nil
+}
+
+func (pw *packWriter) open(roots []*storage.Root) error {
+	var (
+		appendable = storage.Appendable(storage.TierRoots(roots, storage.TierHot))
+		pack       = &Pack{Status: PackOpen}
+		placed     []*storage.Root
+		names      []string
+		err        error
+	)
+	if len(appendable) == 0 {
+		return nil
+	}
+	if err = pw.db.Create(pack).Error; err != nil {
+		return err
+	}
+	if placed, err = storage.Place(pack.Key(), appendable, ChunkReplicas()); err != nil {
+		return err
+	}
+	for _, root := range placed {
+		names = append(names, root.Name)
+	}
+	pack.Roots = strings.Join(names, ",")
+	if err = pw.db.Model(pack).Update("roots", pack.Roots).Error; err != nil {
+		return err
+	}
+	pw.pack, pw.roots, pw.size = pack, placed, 0
+	return nil
+}
+
+// seal stops appending to the current pack. A failure to record it is
+// ignored, the pack is taken as sealed after a while anyway.
+func (pw *packWriter) seal() {
+	if pw.pack == nil {
+		return
+	}
+	_ = pw.db.Model(pw.pack).Update("status", PackSealed).Error
+	pw.pack, pw.roots, pw.size = nil, nil, 0
+}
+
+func (c *Chunk) IsPacked() bool {
+	return c.PackID != 0
+}
+
+// StoredKey returns the key of the file keeping chunk, which is the key of
+// its pack for a packed chunk.
+func (c *Chunk) StoredKey() (string, error) {
+	if c.IsPacked() {
+		return PackKey(c.PackID), nil
+	}
+	return c.Key()
+}
+
+// ReadStored reads the stored content of chunk from store, a packed chunk is
+// read from its section of the pack.
+func (c *Chunk) ReadStored(store storage.ChunkStore) ([]byte, error) {
+	if !c.IsPacked() {
+		key, err := c.Key()
+		if err != nil {
+			return nil, err
+		}
+		return store.Get(key)
+	}
+	reader, err := store.Range(PackKey(c.PackID), c.PackOffset, int64(c.StoredSize))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// putPacked appends stored to a pack if it is small, the placements of chunk
+// are replaced by the roots of the pack.
+func (c *Chunk) putPacked(stored []byte, rootPath *string, db *gorm.DB) (packed bool, err error) {
+	var (
+		roots     []*storage.Root
+		pack      *Pack
+		packRoots []*storage.Root
+		offset    int64
+	)
+	if roots, err = ChunkRoots(rootPath); err != nil {
+		return false, err
+	}
+	if pack, packRoots, offset, err = packStored(stored, roots); err != nil || pack == nil {
+		return false, err
+	}
+	return true, c.setPack(pack.ID, offset, packRoots, db)
+}
+
+func (c *Chunk) setPack(packID uint64, offset int64, roots []*storage.Root, db *gorm.DB) error {
+	if err := replaceChunkPlacements(c.ID, roots, db); err != nil {
+		return err
+	}
+	c.PackID, c.PackOffset = packID, offset
+	return db.Model(c).UpdateColumns(map[string]interface{}{
+		"packId":     c.PackID,
+		"packOffset": c.PackOffset,
+	}).Error
+}
+
+func replaceChunkPlacements(chunkID uint64, roots []*storage.Root, db *gorm.DB) error {
+	if err := db.Where("chunkId = ?", chunkID).Delete(&ChunkPlacement{}).Error; err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if err := AddChunkPlacement(chunkID, root.Name, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Repack copies packed chunk from its pack to the current one. The chunk is
+// left as it is if it has been changed meanwhile, then moved is false.
+func (c *Chunk) Repack(rootPath *string, db *gorm.DB) (moved bool, err error) {
+	var (
+		roots     []*storage.Root
+		current   []*storage.Root
+		stored    []byte
+		pack      *Pack
+		packRoots []*storage.Root
+		offset    int64
+	)
+	if roots, err = ChunkRoots(rootPath); err != nil {
+		return false, err
+	}
+	if current, err = c.ReplicaRoots(rootPath, db); err != nil {
+		return false, err
+	}
+	if stored, _, err = c.GoodReplica(current, db); err != nil {
+		return false, err
+	}
+	if pack, packRoots, offset, err = packStored(stored, roots); err != nil {
+		return false, err
+	}
+	if pack == nil {
+		return false, ErrPackerStopped
+	}
+
+	tx := db.Begin()
+	if err = tx.Error; err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil || !moved {
+			tx.Rollback()
+		} else {
+			err = tx.Commit().Error
+		}
+	}()
+
+	result := tx.Model(&Chunk{}).
+		Where("id = ? AND packId = ? AND packOffset = ?", c.ID, c.PackID, c.PackOffset).
+		UpdateColumns(map[string]interface{}{"packId": pack.ID, "packOffset": offset})
+	if err = result.Error; err != nil || result.RowsAffected == 0 {
+		return false, err
+	}
+	if err = replaceChunkPlacements(c.ID, packRoots, tx); err != nil {
+		return false, err
+	}
+	c.PackID, c.PackOffset = pack.ID, offset
+	return true, nil
+}
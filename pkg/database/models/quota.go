@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota is exceeded")
+
+// Usage of an app or a token against its quota, a quota of 0 is unlimited.
+// The usage of an app is the logical size and number of its files which
+// aren't deleted, the usage of a token is what has been written through it.
+type Usage struct {
+	Bytes      int64 `json:"bytes"`
+	Files      int64 `json:"files"`
+	QuotaBytes int64 `json:"quotaBytes"`
+	QuotaFiles int64 `json:"quotaFiles"`
+}
+
+// Exceeded tells whether usage, which has grown by bytes and files, is over
+// its quota. Only what has grown is checked, so shrinking is always allowed.
+func (u *Usage) Exceeded(bytes, files int64) bool {
+	if bytes > 0 && u.QuotaBytes > 0 && u.Bytes > u.QuotaBytes {
+		return true
+	}
+	return files > 0 && u.QuotaFiles > 0 && u.Files > u.QuotaFiles
+}
+
+// RemainingBytes returns the bytes which can still be written, it is negative
+// if there isn't a byte quota.
+func (u *Usage) RemainingBytes() int64 {
+	if u.QuotaBytes <= 0 {
+		return -1
+	}
+	if u.Bytes >= u.QuotaBytes {
+		return 0
+	}
+	return u.QuotaBytes - u.Bytes
+}
+
+// Usage returns the usage of app, the size of its root directory aggregates
+// the sizes of its files.
+func (app *App) Usage(db *gorm.DB) (*Usage, error) {
+	return app.usage(false, db)
+}
+
+// LockUsage is like Usage, but the root directory of app is locked until the
+// transaction of db ends. Writes of the app update the size of its root
+// directory anyway, so they are serialized by it. The root directory is created
+// if the app doesn't have one yet, so that its first writes are serialized too.
+func (app *App) LockUsage(db *gorm.DB) (*Usage, error) {
+	return app.usage(true, db)
+}
+
+func (app *App) usage(lock bool, db *gorm.DB) (*Usage, error) {
+	var (
+		root  = &File{}
+		files int64
+		err   error
+	)
+	if lock {
+		db = db.Set("gorm:query_option", "FOR UPDATE")
+	}
+	// an app which hasn't written anything may not have a root directory yet
+	err = db.Where("appId = ? and pid = 0 and name = ''", app.ID).First(root).Error
+	if lock && gorm.IsRecordNotFoundError(err) {
+		// a concurrent insert waits for this one, and doesn't insert again
+		created := &File{UID: UID(), AppID: app.ID, IsDir: IsDir}
+		if err = db.Set("gorm:insert_option", "ON DUPLICATE KEY UPDATE id = id").Create(created).Error; err != nil {
+			return nil, err
+		}
+		err = db.Where("appId = ? and pid = 0 and name = ''", app.ID).First(root).Error
+	}
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	if err = db.Set("gorm:query_option", "").Model(&File{}).Where("appId = ? and isDir = 0", app.ID).Count(&files).Error; err != nil {
+		return nil, err
+	}
+	return &Usage{Bytes: int64(root.Size), Files: files, QuotaBytes: app.QuotaBytes, QuotaFiles: app.QuotaFiles}, nil
+}
+
+func (t *Token) Usage() *Usage {
+	return &Usage{Bytes: t.UsedBytes, Files: t.UsedFiles, QuotaBytes: t.QuotaBytes, QuotaFiles: t.QuotaFiles}
+}
+
+// AddUsage records bytes and files written through token, the used counters
+// of token are reloaded then, as they may be added to by other requests.
+func (t *Token) AddUsage(bytes, files int64, db *gorm.DB) error {
+	var row struct {
+		UsedBytes int64 `gorm:"column:usedBytes"`
+		UsedFiles int64 `gorm:"column:usedFiles"`
+	}
+	if bytes == 0 && files == 0 {
+		return nil
+	}
+	err := db.Model(&Token{}).Where("id = ?", t.ID).UpdateColumns(map[string]interface{}{
+		"usedBytes": gorm.Expr("usedBytes + ?", bytes),
+		"usedFiles": gorm.Expr("usedFiles + ?", files),
+	}).Error
+	if err != nil {
+		return err
+	}
+	if err = db.Raw("SELECT usedBytes, usedFiles FROM tokens WHERE id = ?", t.ID).Scan(&row).Error; err != nil {
+		return err
+	}
+	t.UsedBytes, t.UsedFiles = row.UsedBytes, row.UsedFiles
+	return nil
+}
+
+// SetQuota sets the quota of app, 0 means unlimited.
+func (app *App) SetQuota(bytes, files int64, db *gorm.DB) error {
+	app.QuotaBytes, app.QuotaFiles = bytes, files
+	return db.Model(app).Updates(map[string]interface{}{"quotaBytes": bytes, "quotaFiles": files}).Error
+}
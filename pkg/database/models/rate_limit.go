@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+
+	"medea/pkg/config"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ParseRateLimit parses a rate limit saved as json, nothing or an empty text
+// is no limit.
+func ParseRateLimit(text *string) (*config.RateLimit, error) {
+	limit := &config.RateLimit{}
+	if text == nil || len(*text) == 0 {
+		return limit, nil
+	}
+	if err := json.Unmarshal([]byte(*text), limit); err != nil {
+		return nil, config.ErrInvalidRateLimit
+	}
+	if err := limit.Validate(); err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+// RateLimitText encodes limit to be saved, nil if it limits nothing.
+func RateLimitText(limit *config.RateLimit) (*string, error) {
+	if limit == nil || !limit.Limited() {
+		return nil, nil
+	}
+	if err := limit.Validate(); err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(limit)
+	if err != nil {
+		return nil, err
+	}
+	text := string(content)
+	return &text, nil
+}
+
+// RateLimits returns the rate limit of app, a broken one limits nothing.
+func (app *App) RateLimits() *config.RateLimit {
+	if limit, err := ParseRateLimit(app.RateLimit); err == nil {
+		return limit
+	}
+	return &config.RateLimit{}
+}
+
+// SetRateLimit sets the rate limit of app, nil removes it.
+func (app *App) SetRateLimit(limit *config.RateLimit, db *gorm.DB) (err error) {
+	if app.RateLimit, err = RateLimitText(limit); err != nil {
+		return err
+	}
+	return db.Model(app).Update("rateLimit", app.RateLimit).Error
+}
+
+// RateLimits returns the rate limit of token, a broken one limits nothing.
+func (t *Token) RateLimits() *config.RateLimit {
+	if limit, err := ParseRateLimit(t.RateLimit); err == nil {
+		return limit
+	}
+	return &config.RateLimit{}
+}
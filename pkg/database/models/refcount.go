@@ -0,0 +1,75 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// The reference count of an object is the number of files, trashed or not,
+// and histories referring to it. The reference count of a chunk is the number
+// of object chunks and upload parts referring to it. They are maintained in
+// the transactions changing the references, updatedAt is kept since the rows
+// themselves aren't modified.
+
+// AddObjectRef adds delta to the reference count of object, nothing is done
+// for the zero id of directories.
+func AddObjectRef(objectID uint64, delta int, db *gorm.DB) error {
+	if objectID == 0 || delta == 0 {
+		return nil
+	}
+	return db.Model(&Object{}).Where("id = ?", objectID).UpdateColumns(map[string]interface{}{
+		"refCount":  gorm.Expr("refCount + ?", delta),
+		"updatedAt": gorm.Expr("updatedAt"),
+	}).Error
+}
+
+// AddChunkRefs adds delta to the reference counts of chunks, a chunk listed n
+// times gets n times delta.
+func AddChunkRefs(chunkIDs []uint64, delta int, db *gorm.DB) error {
+	var (
+		times  = make(map[uint64]int)
+		groups = make(map[int][]uint64)
+	)
+	for _, id := range chunkIDs {
+		times[id]++
+	}
+	for id, n := range times {
+		groups[n] = append(groups[n], id)
+	}
+	for n, ids := range groups {
+		err := db.Model(&Chunk{}).Where("id IN (?)", ids).UpdateColumns(map[string]interface{}{
+			"refCount":  gorm.Expr("refCount + ?", n*delta),
+			"updatedAt": gorm.Expr("updatedAt"),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockObjectRefCount reads the reference count of object with a locking read,
+// so that it doesn't change until the transaction of db ends.
+func lockObjectRefCount(objectID uint64, db *gorm.DB) (count int, err error) {
+	var row struct {
+		RefCount int `gorm:"column:refCount"`
+	}
+	err = db.Raw("SELECT refCount FROM objects WHERE id = ? FOR UPDATE", objectID).Scan(&row).Error
+	return row.RefCount, err
+}
+
+// lockChunkRefCount is like lockObjectRefCount but for chunks.
+func lockChunkRefCount(chunkID uint64, db *gorm.DB) (count int, err error) {
+	var row struct {
+		RefCount int `gorm:"column:refCount"`
+	}
+	err = db.Raw("SELECT refCount FROM chunks WHERE id = ? FOR UPDATE", chunkID).Scan(&row).Error
+	return row.RefCount, err
+}
+
+func objectChunkIDs(oc []ObjectChunk) []uint64 {
+	ids := make([]uint64, 0, len(oc))
+	for _, item := range oc {
+		ids = append(ids, item.ChunkID)
+	}
+	return ids
+}
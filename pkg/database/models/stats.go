@@ -0,0 +1,227 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const statsDayFormat = "2006-01-02"
+
+// DailyStat is the traffic of an app in a day, through a token or without one
+// if TokenID is 0.
+type DailyStat struct {
+	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	Day       time.Time `gorm:"type:DATE NOT NULL;column:day"`
+	AppID     uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:appId"`
+	TokenID   uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:tokenId;DEFAULT:0"`
+	Requests  int64     `gorm:"type:BIGINT(20);column:requests;DEFAULT:0"`
+	BytesIn   int64     `gorm:"type:BIGINT(20);column:bytesIn;DEFAULT:0"`
+	BytesOut  int64     `gorm:"type:BIGINT(20);column:bytesOut;DEFAULT:0"`
+	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+}
+
+func (s *DailyStat) TableName() string {
+	return "daily_stats"
+}
+
+// FindDailyStats returns the stats of app from day from to day to, those of
+// a token only if token isn't nil, otherwise summed over all tokens.
+func FindDailyStats(app *App, token *Token, from, to time.Time, db *gorm.DB) (stats []DailyStat, err error) {
+	db = db.Table("daily_stats").
+		Where("appId = ? and day between ? and ?", app.ID, from.Format(statsDayFormat), to.Format(statsDayFormat)).
+		Order("day asc")
+	if token != nil {
+		err = db.Where("tokenId = ?", token.ID).Find(&stats).Error
+		return stats, err
+	}
+	err = db.Select("day, appId, sum(requests) as requests, sum(bytesIn) as bytesIn, sum(bytesOut) as bytesOut").
+		Group("day, appId").Scan(&stats).Error
+	return stats, err
+}
+
+type statsKey struct {
+	day            string
+	appID, tokenID uint64
+}
+
+type statsDelta struct {
+	requests, bytesIn, bytesOut int64
+}
+
+type fileAccess struct {
+	downloads  uint64
+	accessedAt time.Time
+}
+
+// stats gathers the counters in memory, they are written in batches by the
+// goroutine of StartStats, and nothing is gathered before it's started.
+var stats struct {
+	mutex   sync.Mutex
+	started bool
+	files   map[uint64]*fileAccess
+	daily   map[statsKey]*statsDelta
+}
+
+// RecordDownload counts a read of the content of the file of fileID.
+func RecordDownload(fileID uint64) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	if !stats.started {
+		return
+	}
+	access, ok := stats.files[fileID]
+	if !ok {
+		access = &fileAccess{}
+		stats.files[fileID] = access
+	}
+	access.downloads++
+	access.accessedAt = time.Now()
+}
+
+// RecordRequest counts a request of app through the token of tokenID, which is
+// 0 for a request without token, along with the bytes of its body and response.
+func RecordRequest(appID, tokenID uint64, bytesIn, bytesOut int64) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	if !stats.started {
+		return
+	}
+	key := statsKey{day: time.Now().Format(statsDayFormat), appID: appID, tokenID: tokenID}
+	delta, ok := stats.daily[key]
+	if !ok {
+		delta = &statsDelta{}
+		stats.daily[key] = delta
+	}
+	delta.requests++
+	delta.bytesIn += bytesIn
+	delta.bytesOut += bytesOut
+}
+
+// StartStats writes the gathered counters every interval, and once more when
+// stopped. Counters failed to be written are kept for the next time.
+func StartStats(db *gorm.DB, interval time.Duration, onError func(err error)) (stop func()) {
+	var (
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+		ticker  = time.NewTicker(interval)
+	)
+
+	stats.mutex.Lock()
+	stats.started = true
+	stats.files = make(map[uint64]*fileAccess)
+	stats.daily = make(map[statsKey]*statsDelta)
+	stats.mutex.Unlock()
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				if err := flushStats(db); err != nil {
+					onError(err)
+				}
+				return
+			case <-ticker.C:
+				if err := flushStats(db); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+		stats.mutex.Lock()
+		stats.started = false
+		stats.mutex.Unlock()
+	}
+}
+
+func flushStats(db *gorm.DB) error {
+	stats.mutex.Lock()
+	files, daily := stats.files, stats.daily
+	stats.files = make(map[uint64]*fileAccess)
+	stats.daily = make(map[statsKey]*statsDelta)
+	stats.mutex.Unlock()
+
+	if err := flushFileAccesses(files, db); err != nil {
+		mergeStats(files, daily)
+		return err
+	}
+	if err := flushDailyStats(daily, db); err != nil {
+		mergeStats(nil, daily)
+		return err
+	}
+	return nil
+}
+
+// flushFileAccesses writes the accesses of files, which are removed once
+// written, so that only the ones left are merged back on failure.
+func flushFileAccesses(files map[uint64]*fileAccess, db *gorm.DB) error {
+	for fileID, access := range files {
+		// updatedAt is kept as the content isn't modified
+		if err := db.Exec(
+			"UPDATE files SET downloadCount = downloadCount + ?, lastAccessedAt = ?, updatedAt = updatedAt WHERE id = ?",
+			access.downloads, access.accessedAt, fileID,
+		).Error; err != nil {
+			return err
+		}
+		delete(files, fileID)
+	}
+	return nil
+}
+
+func flushDailyStats(daily map[statsKey]*statsDelta, db *gorm.DB) error {
+	if len(daily) == 0 {
+		return nil
+	}
+
+	var (
+		rows   = make([]string, 0, len(daily))
+		values = make([]interface{}, 0, len(daily)*6)
+	)
+	for key, delta := range daily {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?)")
+		values = append(values, key.day, key.appID, key.tokenID, delta.requests, delta.bytesIn, delta.bytesOut)
+	}
+	return db.Exec(
+		"INSERT INTO daily_stats (day, appId, tokenId, requests, bytesIn, bytesOut) VALUES "+strings.Join(rows, ", ")+
+			" ON DUPLICATE KEY UPDATE requests = requests + VALUES(requests),"+
+			" bytesIn = bytesIn + VALUES(bytesIn), bytesOut = bytesOut + VALUES(bytesOut)",
+		values...,
+	).Error
+}
+
+// mergeStats adds back the counters which failed to be written.
+func mergeStats(files map[uint64]*fileAccess, daily map[statsKey]*statsDelta) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	for fileID, access := range files {
+		if current, ok := stats.files[fileID]; ok {
+			current.downloads += access.downloads
+			if access.accessedAt.After(current.accessedAt) {
+				current.accessedAt = access.accessedAt
+			}
+		} else {
+			stats.files[fileID] = access
+		}
+	}
+	for key, delta := range daily {
+		if current, ok := stats.daily[key]; ok {
+			current.requests += delta.requests
+			current.bytesIn += delta.bytesIn
+			current.bytesOut += delta.bytesOut
+		} else {
+			stats.daily[key] = delta
+		}
+	}
+}
@@ -18,9 +18,14 @@ type Token struct {
 	Secret         *string    `gorm:"type:CHAR(32)"`
 	AppID          uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:appId"`
 	IP             *string    `gorm:"type:VARCHAR(1500);column:ip"`
-	AvailableTimes int        `gorm:"type:int(10);column:availableTimes;DEFAULT:-1"`
+	AvailableTimes int        `gorm:"type:int(10);column:availableTimes;DEFAULT:0"`
 	ReadOnly       int8       `gorm:"type:tinyint;column:readOnly;DEFAULT:0"`
 	Path           string     `gorm:"type:tinyint;column:path"`
+	QuotaBytes     int64      `gorm:"type:BIGINT(20);column:quotaBytes;DEFAULT:0"`
+	QuotaFiles     int64      `gorm:"type:BIGINT(20);column:quotaFiles;DEFAULT:0"`
+	UsedBytes      int64      `gorm:"type:BIGINT(20);column:usedBytes;DEFAULT:0"`
+	UsedFiles      int64      `gorm:"type:BIGINT(20);column:usedFiles;DEFAULT:0"`
+	RateLimit      *string    `gorm:"type:TEXT;column:rateLimit"`
 	ExpiredAt      *time.Time `gorm:"type:TIMESTAMP;column:expiredAt"`
 	CreatedAt      time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
 	UpdatedAt      time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
@@ -67,7 +72,8 @@ func (t *Token) UpdateAvailableTimes(inc int, db *gorm.DB) error {
 }
 
 func NewToken(
-	app *App, path string, expiredAt *time.Time, ip, secret *string, availableTimes int, readOnly int8, db *gorm.DB,
+	app *App, path string, expiredAt *time.Time, ip, secret *string, availableTimes int, readOnly int8,
+	quotaBytes, quotaFiles int64, rateLimit *string, db *gorm.DB,
 ) (*Token, error) {
 	var (
 		token = &Token{
@@ -78,6 +84,9 @@ func NewToken(
 			AvailableTimes: availableTimes,
 			ReadOnly:       readOnly,
 			Path:           path,
+			QuotaBytes:     quotaBytes,
+			QuotaFiles:     quotaFiles,
+			RateLimit:      rateLimit,
 			ExpiredAt:      expiredAt,
 			App:            *app,
 		}
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	UploadSessionPending   = int8(0)
+	UploadSessionCompleted = int8(1)
+	UploadSessionAborted   = int8(2)
+)
+
+const UploadSessionExpiration = 7 * 24 * time.Hour
+
+var (
+	ErrUploadSessionClosed  = errors.New("upload session has already been completed or aborted")
+	ErrUploadSessionExpired = errors.New("upload session is expired")
+	ErrInvalidPartNumber    = errors.New("part number must be greater than 0")
+	ErrUploadPartsMissing   = errors.New("upload parts are not continuous")
+)
+
+type UploadSession struct {
+	ID         uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	UID        string     `gorm:"type:CHAR(32) NOT NULL;UNIQUE;column:uid"`
+	AppID      uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:appId"`
+	Path       string     `gorm:"type:VARCHAR(1000) NOT NULL;column:path"`
+	Hidden     int8       `gorm:"type:tinyint;column:hidden;DEFAULT:0"`
+	OnConflict int8       `gorm:"type:tinyint;column:onConflict;DEFAULT:0"`
+	Status     int8       `gorm:"type:tinyint;column:status;DEFAULT:0"`
+	FileID     uint64     `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:fileId;DEFAULT:0"`
+	ExpiredAt  *time.Time `gorm:"type:TIMESTAMP(6);column:expiredAt"`
+	CreatedAt  time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt  time.Time  `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+
+	App   App          `gorm:"foreignkey:appId;association_autoupdate:false;association_autocreate:false"`
+	Parts []UploadPart `gorm:"foreignkey:sessionId;association_autoupdate:false;association_autocreate:false"`
+}
+
+func (us *UploadSession) TableName() string {
+	return "upload_sessions"
+}
+
+type UploadPart struct {
+	ID        uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT;primary_key"`
+	SessionID uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:sessionId"`
+	Number    int       `gorm:"type:int;column:number"`
+	ChunkID   uint64    `gorm:"type:BIGINT(20) UNSIGNED NOT NULL;column:chunkId"`
+	Size      int       `gorm:"type:int;column:size"`
+	Hash      string    `gorm:"type:CHAR(64) NOT NULL;column:hash"`
+	CreatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:createdAt"`
+	UpdatedAt time.Time `gorm:"type:TIMESTAMP(6) NOT NULL;DEFAULT:CURRENT_TIMESTAMP(6);column:updatedAt"`
+
+	Chunk Chunk `gorm:"foreignkey:chunkId;association_autoupdate:false;association_autocreate:false"`
+}
+
+func (up *UploadPart) TableName() string {
+	return "upload_parts"
+}
+
+func (us *UploadSession) IsExpired() bool {
+	return us.ExpiredAt != nil && us.ExpiredAt.Before(time.Now())
+}
+
+func (us *UploadSession) CanBeAccessedByToken(token *Token) error {
+	if token.AppID != us.AppID {
+		return ErrAccessDenied
+	}
+	if !strings.HasPrefix(us.Path, token.Path) {
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+func (us *UploadSession) checkWritable() error {
+	if us.Status != UploadSessionPending {
+		return ErrUploadSessionClosed
+	}
+	if us.IsExpired() {
+		return ErrUploadSessionExpired
+	}
+	return nil
+}
+
+// PutPart stores the content of part number as a chunk, a part which has
+// already been uploaded is replaced, so parts can be retried in any order.
+func (us *UploadSession) PutPart(number int, p []byte, rootPath *string, db *gorm.DB) (part *UploadPart, err error) {
+	var chunk *Chunk
+
+	if number < 1 {
+		return nil, ErrInvalidPartNumber
+	}
+
+	if err = us.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if chunk, err = CreateChunkFromBytes(p, rootPath, withApp(db, us.AppID)); err != nil {
+		return nil, err
+	}
+
+	return us.putChunk(number, chunk, db)
+}
+
+// ChunkDigest is the hash and size of a chunk which a client has.
+type ChunkDigest struct {
+	Hash string
+	Size int
+}
+
+// ownedChunkCond matches the chunks referred to by the files, the histories or
+// the upload sessions of an app.
+const ownedChunkCond = `(EXISTS (SELECT 1 FROM object_chunk oc JOIN files f ON f.objectId = oc.objectId
+		WHERE oc.chunkId = c.id AND f.appId = ?)
+	OR EXISTS (SELECT 1 FROM object_chunk oc JOIN histories h ON h.objectId = oc.objectId JOIN files f ON f.id = h.fileId
+		WHERE oc.chunkId = c.id AND f.appId = ?)
+	OR EXISTS (SELECT 1 FROM upload_parts p JOIN upload_sessions s ON s.id = p.sessionId
+		WHERE p.chunkId = c.id AND s.appId = ?))`
+
+// Negotiate makes the chunks of digests which are already stored the parts of
+// session numbered in order, and returns the numbers of the parts which still
+// have to be uploaded. Only the chunks the app of session refers to, and may
+// reuse by its data key, are taken, so content can't be claimed by knowing its
+// hash. The parts numbered beyond
+// digests, and the parts left over at the missing numbers, are deleted.
+func (us *UploadSession) Negotiate(digests []ChunkDigest, db *gorm.DB) (missing []int, err error) {
+	var (
+		owned   = make(map[string]*Chunk)
+		hashes  []string
+		keyCond string
+		keyArgs []interface{}
+	)
+
+	if err = us.checkWritable(); err != nil {
+		return nil, err
+	}
+	if keyCond, keyArgs, err = reusableChunkCond("c", withApp(db, us.AppID)); err != nil {
+		return nil, err
+	}
+
+	for _, digest := range digests {
+		if _, ok := owned[digest.Hash]; !ok {
+			owned[digest.Hash] = nil
+			hashes = append(hashes, digest.Hash)
+		}
+	}
+	for start := 0; start < len(hashes); start += 500 {
+		var (
+			end    = start + 500
+			chunks []Chunk
+		)
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		// the chunks are locked, so they aren't collected before the parts
+		// referring to them are committed
+		err = db.Raw(
+			"SELECT c.* FROM chunks c WHERE c.hash IN (?) AND "+ownedChunkCond+" AND "+keyCond+" FOR UPDATE",
+			append([]interface{}{hashes[start:end], us.AppID, us.AppID, us.AppID}, keyArgs...)...,
+		).Scan(&chunks).Error
+		if err != nil {
+			return nil, err
+		}
+		for index := range chunks {
+			owned[chunks[index].Hash] = &chunks[index]
+		}
+	}
+
+	for index, digest := range digests {
+		chunk := owned[digest.Hash]
+		if chunk == nil || chunk.Size != digest.Size {
+			missing = append(missing, index+1)
+			continue
+		}
+		if _, err = us.putChunk(index+1, chunk, db); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil, us.deletePartsWhere(db, "sessionId = ? and number > ?", us.ID, len(digests))
+	}
+	return missing, us.deletePartsWhere(
+		db, "sessionId = ? and (number > ? or number in (?))", us.ID, len(digests), missing,
+	)
+}
+
+// putChunk makes chunk the content of part number.
+func (us *UploadSession) putChunk(number int, chunk *Chunk, db *gorm.DB) (part *UploadPart, err error) {
+	// the chunk of the part being replaced isn't referred to by it any more
+	var replaced UploadPart
+	err = db.Set("gorm:query_option", "FOR UPDATE").Where("sessionId = ? and number = ?", us.ID, number).First(&replaced).Error
+	if err == nil {
+		err = AddChunkRefs([]uint64{replaced.ChunkID}, -1, db)
+	}
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	if err = AddChunkRefs([]uint64{chunk.ID}, 1, db); err != nil {
+		return nil, err
+	}
+
+	part = &UploadPart{
+		SessionID: us.ID,
+		Number:    number,
+		ChunkID:   chunk.ID,
+		Size:      chunk.Size,
+		Hash:      chunk.Hash,
+		Chunk:     *chunk,
+	}
+
+	err = db.Set(
+		"gorm:insert_option",
+		"ON DUPLICATE KEY UPDATE chunkId = VALUES(chunkId), size = VALUES(size), hash = VALUES(hash), updatedAt = VALUES(updatedAt)",
+	).Create(part).Error
+
+	return part, err
+}
+
+func (us *UploadSession) ListParts(db *gorm.DB) (parts []UploadPart, err error) {
+	err = db.Where("sessionId = ?", us.ID).Order("number asc").Find(&parts).Error
+	return parts, err
+}
+
+func (us *UploadSession) orderedChunks(db *gorm.DB) (chunks []Chunk, err error) {
+	var parts []UploadPart
+
+	if err = db.Preload("Chunk").Where("sessionId = ?", us.ID).Order("number asc").Find(&parts).Error; err != nil {
+		return nil, err
+	}
+
+	for index, part := range parts {
+		if part.Number != index+1 {
+			return nil, fmt.Errorf("%w: part %d is missing", ErrUploadPartsMissing, index+1)
+		}
+		chunks = append(chunks, part.Chunk)
+	}
+
+	return chunks, nil
+}
+
+// Complete assembles the uploaded parts in number order into an object and
+// saves it to the path of session.
+func (us *UploadSession) Complete(rootPath *string, db *gorm.DB) (file *File, err error) {
+	var (
+		chunks []Chunk
+		object *Object
+	)
+
+	if err = us.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if us.App.ID == 0 {
+		if err = db.Preload("App").Find(us).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if chunks, err = us.orderedChunks(db); err != nil {
+		return nil, err
+	}
+
+	if object, err = CreateObjectFromChunks(chunks, rootPath, db); err != nil {
+		return nil, err
+	}
+
+	if file, err = SaveObjectToPath(&us.App, us.Path, object, us.Hidden, us.OnConflict, db); err != nil {
+		return nil, err
+	}
+
+	us.Status = UploadSessionCompleted
+	us.FileID = file.ID
+	if err = db.Model(us).Updates(map[string]interface{}{"status": us.Status, "fileId": us.FileID}).Error; err != nil {
+		return nil, err
+	}
+
+	return file, us.deleteParts(db)
+}
+
+func (us *UploadSession) Abort(db *gorm.DB) error {
+	if us.Status != UploadSessionPending {
+		return ErrUploadSessionClosed
+	}
+	us.Status = UploadSessionAborted
+	if err := db.Model(us).Update("status", us.Status).Error; err != nil {
+		return err
+	}
+	return us.deleteParts(db)
+}
+
+// deleteParts deletes the parts of session and releases their chunks.
+func (us *UploadSession) deleteParts(db *gorm.DB) error {
+	return us.deletePartsWhere(db, "sessionId = ?", us.ID)
+}
+
+func (us *UploadSession) deletePartsWhere(db *gorm.DB, query string, args ...interface{}) error {
+	var chunkIDs []uint64
+	if err := db.Model(&UploadPart{}).Where(query, args...).Pluck("chunkId", &chunkIDs).Error; err != nil {
+		return err
+	}
+	if err := AddChunkRefs(chunkIDs, -1, db); err != nil {
+		return err
+	}
+	return db.Where(query, args...).Delete(&UploadPart{}).Error
+}
+
+func NewUploadSession(app *App, path string, hidden, onConflict int8, db *gorm.DB) (*UploadSession, error) {
+	var (
+		expiredAt = time.Now().Add(UploadSessionExpiration)
+		session   = &UploadSession{
+			UID:        UID(),
+			AppID:      app.ID,
+			Path:       path,
+			Hidden:     hidden,
+			OnConflict: onConflict,
+			ExpiredAt:  &expiredAt,
+			App:        *app,
+		}
+	)
+	return session, db.Create(session).Error
+}
+
+func FindUploadSessionByUID(uid string, db *gorm.DB) (*UploadSession, error) {
+	var (
+		session = &UploadSession{}
+		err     error
+	)
+	if err = db.Preload("App").Where("uid = ?", uid).Find(session).Error; err != nil {
+		return session, err
+	}
+	return session, nil
+}
@@ -0,0 +1,125 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"medea/pkg/config"
+)
+
+const KeySize = 32
+
+var (
+	ErrInvalidKey       = errors.New("key must be 32 bytes encoded by base64")
+	ErrNoMasterKey      = errors.New("master key isn't configured")
+	ErrInvalidEncrypted = errors.New("encrypted content is too short")
+)
+
+// ParseKey decodes a base64 encoded AES-256 key.
+func ParseKey(text string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// LoadMasterKey reads the master key from config, or from the key file if
+// the key isn't given directly.
+func LoadMasterKey(conf *config.Encryption) ([]byte, error) {
+	if conf.MasterKey != "" {
+		return ParseKey(conf.MasterKey)
+	}
+	if conf.MasterKeyFile != "" {
+		return LoadKeyFile(conf.MasterKeyFile)
+	}
+	return nil, ErrNoMasterKey
+}
+
+func LoadKeyFile(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(content))
+}
+
+// KeyID identifies a master key without revealing it.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext by AES-256-GCM, the random nonce is prepended to
+// the result. additionalData is authenticated but not encrypted.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func Open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidEncrypted
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Wrap encrypts a data key by the master key.
+func Wrap(masterKey, dataKey []byte) (string, error) {
+	sealed, err := Seal(masterKey, dataKey, nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func Unwrap(masterKey []byte, wrapped string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return Open(masterKey, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,495 @@
+package fsck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"medea/pkg/database/models"
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/time/rate"
+)
+
+const QuarantinePrefix = "quarantine/"
+
+const (
+	KindMissing    = "missing"
+	KindTruncated  = "truncated"
+	KindCorrupted  = "corrupted"
+	KindUnreadable = "unreadable"
+	KindStray      = "stray"
+	KindIncomplete = "incomplete"
+	KindDamaged    = "damaged"
+	KindMismatch   = "mismatch"
+)
+
+type Options struct {
+	Workers    int
+	BatchSize  int
+	RateLimit  int64 // bytes read per second, 0 means unlimited
+	Quarantine bool
+	SkipHash   bool // skip replaying chunks to verify hashes of objects
+	RootPath   *string
+}
+
+type Issue struct {
+	Kind        string `json:"kind"`
+	ChunkID     uint64 `json:"chunkId,omitempty"`
+	ObjectID    uint64 `json:"objectId,omitempty"`
+	Root        string `json:"root,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Detail      string `json:"detail"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+type Report struct {
+	Chunks      int64          `json:"chunks"`
+	Objects     int64          `json:"objects"`
+	StoredFiles int64          `json:"storedFiles"`
+	BytesRead   int64          `json:"bytesRead"`
+	Summary     map[string]int `json:"summary"`
+	Issues      []Issue        `json:"issues"`
+	StartedAt   time.Time      `json:"startedAt"`
+	FinishedAt  time.Time      `json:"finishedAt"`
+}
+
+type checker struct {
+	db        *gorm.DB
+	opts      Options
+	roots     []*storage.Root
+	limiter   *rate.Limiter
+	mutex     sync.Mutex
+	badChunks map[uint64]bool
+	report    *Report
+}
+
+// Run checks every chunk file against the size and hash of its chunk, every
+// object against its chunks, and looks for chunk files without chunk.
+func Run(db *gorm.DB, opts Options) (report *Report, err error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	c := &checker{
+		db:        db,
+		opts:      opts,
+		badChunks: make(map[uint64]bool),
+		report:    &Report{Summary: make(map[string]int), Issues: []Issue{}, StartedAt: time.Now()},
+	}
+	if opts.RateLimit > 0 {
+		burst := int(opts.RateLimit)
+		if burst < models.ChunkSize {
+			burst = models.ChunkSize
+		}
+		c.limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), burst)
+	}
+	if c.roots, err = models.ChunkRoots(opts.RootPath); err != nil {
+		return nil, err
+	}
+
+	for _, check := range []func() error{c.checkChunks, c.checkObjects, c.checkStrayFiles} {
+		if err = check(); err != nil {
+			return c.report, err
+		}
+	}
+
+	sort.SliceStable(c.report.Issues, func(i, j int) bool {
+		a, b := c.report.Issues[i], c.report.Issues[j]
+		if a.ChunkID != b.ChunkID {
+			return a.ChunkID < b.ChunkID
+		}
+		return a.ObjectID < b.ObjectID
+	})
+	c.report.FinishedAt = time.Now()
+	return c.report, nil
+}
+
+func (c *checker) addIssue(issue Issue) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.report.Issues = append(c.report.Issues, issue)
+	c.report.Summary[issue.Kind]++
+}
+
+func (c *checker) markBadChunk(id uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.badChunks[id] = true
+}
+
+func (c *checker) isBadChunk(id uint64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.badChunks[id]
+}
+
+func (c *checker) count(counter *int64, n int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	*counter += n
+}
+
+// read gets the stored content of key from store, the bytes read are
+// limited by the rate limit.
+func (c *checker) read(store storage.ChunkStore, chunk *models.Chunk) ([]byte, error) {
+	stored, err := chunk.ReadStored(store)
+	if err != nil {
+		return nil, err
+	}
+	return stored, c.wait(len(stored))
+}
+
+func (c *checker) wait(n int) error {
+	c.count(&c.report.BytesRead, int64(n))
+	if c.limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		m := n
+		if m > c.limiter.Burst() {
+			m = c.limiter.Burst()
+		}
+		if err := c.limiter.WaitN(context.Background(), m); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
+
+// parallel calls fn for every item produced by produce by bounded workers.
+func parallel(workers int, produce func(items chan<- interface{}) error, fn func(item interface{})) error {
+	var (
+		items = make(chan interface{}, workers)
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				fn(item)
+			}
+		}()
+	}
+	err := produce(items)
+	close(items)
+	wg.Wait()
+	return err
+}
+
+func (c *checker) checkChunks() error {
+	return parallel(c.opts.Workers, func(items chan<- interface{}) error {
+		var lastID uint64
+		for {
+			var chunks []models.Chunk
+			if err := c.db.Where("id > ?", lastID).Order("id").Limit(c.opts.BatchSize).Find(&chunks).Error; err != nil {
+				return err
+			}
+			if len(chunks) == 0 {
+				return nil
+			}
+			lastID = chunks[len(chunks)-1].ID
+			for index := range chunks {
+				items <- &chunks[index]
+			}
+		}
+	}, func(item interface{}) {
+		c.checkChunk(item.(*models.Chunk))
+	})
+}
+
+// checkChunk checks every replica of chunk. A chunk without placement may be
+// on any root, it is missing only if no root has it.
+func (c *checker) checkChunk(chunk *models.Chunk) {
+	var (
+		key        string
+		placements []models.ChunkPlacement
+		missing    []Issue
+		good       int
+		err        error
+	)
+
+	c.count(&c.report.Chunks, 1)
+	if key, err = chunk.StoredKey(); err != nil {
+		c.addIssue(Issue{Kind: KindCorrupted, ChunkID: chunk.ID, Detail: err.Error()})
+		c.markBadChunk(chunk.ID)
+		return
+	}
+	if placements, err = chunk.Placements(c.db); err != nil {
+		c.addIssue(Issue{Kind: KindUnreadable, ChunkID: chunk.ID, Key: key, Detail: err.Error()})
+		c.markBadChunk(chunk.ID)
+		return
+	}
+
+	for _, root := range models.PlacedRoots(placements, c.roots) {
+		issue := c.checkReplica(chunk, key, root)
+		switch {
+		case issue == nil:
+			good++
+		case issue.Kind == KindMissing:
+			missing = append(missing, *issue)
+		default:
+			c.addIssue(*issue)
+		}
+	}
+
+	if len(placements) == 0 && len(missing) > 1 {
+		if good > 0 {
+			missing = nil
+		} else {
+			missing = []Issue{{Kind: KindMissing, ChunkID: chunk.ID, Key: key, Detail: "chunk file doesn't exist in any root"}}
+		}
+	}
+	for _, issue := range missing {
+		c.addIssue(issue)
+	}
+	if good == 0 {
+		c.markBadChunk(chunk.ID)
+	}
+}
+
+func (c *checker) checkReplica(chunk *models.Chunk, key string, root *storage.Root) *Issue {
+	var (
+		stored  []byte
+		content []byte
+		err     error
+		issue   = &Issue{ChunkID: chunk.ID, Root: root.Name, Key: key}
+	)
+
+	if stored, err = c.read(root.Store, chunk); err != nil {
+		issue.Kind, issue.Detail = KindUnreadable, err.Error()
+		if err == storage.ErrNotExist {
+			issue.Kind, issue.Detail = KindMissing, "chunk file doesn't exist"
+		}
+		return issue
+	}
+
+	switch {
+	case len(stored) < chunk.StoredSize:
+		issue.Kind = KindTruncated
+		issue.Detail = fmt.Sprintf("%d of %d bytes are stored", len(stored), chunk.StoredSize)
+	case len(stored) > chunk.StoredSize:
+		issue.Kind = KindCorrupted
+		issue.Detail = fmt.Sprintf("%d bytes are stored but %d are expected", len(stored), chunk.StoredSize)
+	default:
+		if content, err = chunk.DecodeContent(stored, c.db); err != nil {
+			issue.Kind, issue.Detail = KindCorrupted, fmt.Sprintf("failed to decode: %s", err)
+		} else if len(content) != chunk.Size {
+			issue.Kind = KindCorrupted
+			issue.Detail = fmt.Sprintf("size is %d but %d is expected", len(content), chunk.Size)
+		} else if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != chunk.Hash {
+			issue.Kind = KindCorrupted
+			issue.Detail = fmt.Sprintf("hash is %s", hex.EncodeToString(sum[:]))
+		}
+	}
+	if issue.Kind == "" {
+		return nil
+	}
+
+	if c.opts.Quarantine && chunk.IsPacked() {
+		issue.Detail += ", packed chunk isn't quarantined"
+	} else if c.opts.Quarantine {
+		if err = quarantine(root.Store, key, stored); err != nil {
+			issue.Detail += fmt.Sprintf(", failed to quarantine: %s", err)
+		} else {
+			issue.Quarantined = true
+		}
+	}
+	return issue
+}
+
+// quarantine moves a bad chunk file under QuarantinePrefix, so that reading
+// falls back to other replicas instead of returning bad content.
+func quarantine(store storage.ChunkStore, key string, stored []byte) error {
+	if err := store.Put(QuarantinePrefix+key, stored); err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+func (c *checker) checkObjects() error {
+	return parallel(c.opts.Workers, func(items chan<- interface{}) error {
+		var lastID uint64
+		for {
+			var objects []models.Object
+			if err := c.db.Where("id > ?", lastID).Order("id").Limit(c.opts.BatchSize).Find(&objects).Error; err != nil {
+				return err
+			}
+			if len(objects) == 0 {
+				return nil
+			}
+			lastID = objects[len(objects)-1].ID
+			for index := range objects {
+				items <- &objects[index]
+			}
+		}
+	}, func(item interface{}) {
+		c.checkObject(item.(*models.Object))
+	})
+}
+
+func (c *checker) checkObject(object *models.Object) {
+	var (
+		ocs     []models.ObjectChunk
+		chunks  []models.Chunk
+		byID    = make(map[uint64]*models.Chunk)
+		ids     []uint64
+		size    int
+		damaged bool
+		issue   = Issue{ObjectID: object.ID}
+	)
+
+	c.count(&c.report.Objects, 1)
+	if err := c.db.Where("objectId = ?", object.ID).Order("number asc").Find(&ocs).Error; err != nil {
+		issue.Kind, issue.Detail = KindUnreadable, err.Error()
+		c.addIssue(issue)
+		return
+	}
+	if len(ocs) == 0 {
+		issue.Kind, issue.Detail = KindIncomplete, "object has no chunk"
+		c.addIssue(issue)
+		return
+	}
+
+	for _, oc := range ocs {
+		ids = append(ids, oc.ChunkID)
+	}
+	if err := c.db.Where("id IN (?)", ids).Find(&chunks).Error; err != nil {
+		issue.Kind, issue.Detail = KindUnreadable, err.Error()
+		c.addIssue(issue)
+		return
+	}
+	for index := range chunks {
+		byID[chunks[index].ID] = &chunks[index]
+	}
+
+	for index, oc := range ocs {
+		if oc.Number != index+1 {
+			issue.Kind = KindIncomplete
+			issue.Detail = fmt.Sprintf("chunk number %d is found where %d is expected", oc.Number, index+1)
+			c.addIssue(issue)
+			return
+		}
+		chunk, ok := byID[oc.ChunkID]
+		if !ok {
+			issue.Kind, issue.ChunkID = KindIncomplete, oc.ChunkID
+			issue.Detail = fmt.Sprintf("chunk %d of number %d doesn't exist", oc.ChunkID, oc.Number)
+			c.addIssue(issue)
+			return
+		}
+		if c.isBadChunk(chunk.ID) {
+			c.addIssue(Issue{
+				Kind:     KindDamaged,
+				ObjectID: object.ID,
+				ChunkID:  chunk.ID,
+				Detail:   fmt.Sprintf("chunk %d of number %d is bad", chunk.ID, oc.Number),
+			})
+			damaged = true
+		}
+		size += chunk.Size
+	}
+
+	if size != object.Size {
+		issue.Kind = KindMismatch
+		issue.Detail = fmt.Sprintf("size of chunks is %d but object size is %d", size, object.Size)
+		c.addIssue(issue)
+		return
+	}
+	if damaged || c.opts.SkipHash {
+		return
+	}
+
+	objectHash := sha256.New()
+	for _, oc := range ocs {
+		chunk := byID[oc.ChunkID]
+		content, err := chunk.Content(c.opts.RootPath, c.db)
+		if err == nil {
+			err = c.wait(len(content))
+		}
+		if err != nil {
+			issue.Kind, issue.ChunkID = KindUnreadable, chunk.ID
+			issue.Detail = fmt.Sprintf("failed to replay chunk %d: %s", chunk.ID, err)
+			c.addIssue(issue)
+			return
+		}
+		_, _ = objectHash.Write(content)
+	}
+	if sum := hex.EncodeToString(objectHash.Sum(nil)); sum != object.Hash {
+		issue.Kind = KindMismatch
+		issue.Detail = fmt.Sprintf("hash of chunks is %s", sum)
+		c.addIssue(issue)
+	}
+}
+
+// checkStrayFiles looks for the chunk files whose chunk doesn't exist, they
+// are reported only and left to the garbage collector.
+func (c *checker) checkStrayFiles() error {
+	for _, root := range c.roots {
+		if err := c.checkStrayFilesOf(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkStrayFilesOf(root *storage.Root) error {
+	var batch []*storage.Info
+
+	flush := func() error {
+		var (
+			ids      = make([]uint64, 0, len(batch))
+			existing []uint64
+		)
+		for _, info := range batch {
+			id, _ := models.ChunkIDFromKey(info.Key)
+			ids = append(ids, id)
+		}
+		if err := c.db.Model(&models.Chunk{}).Where("id IN (?)", ids).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		exists := make(map[uint64]bool, len(existing))
+		for _, id := range existing {
+			exists[id] = true
+		}
+		for index, info := range batch {
+			if !exists[ids[index]] {
+				c.addIssue(Issue{
+					Kind:   KindStray,
+					Root:   root.Name,
+					Key:    info.Key,
+					Detail: fmt.Sprintf("%d bytes modified at %s", info.Size, info.ModTime.Format(time.RFC3339)),
+				})
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	err := root.Store.List("", func(info *storage.Info) error {
+		if _, ok := models.ChunkIDFromKey(info.Key); !ok {
+			return nil
+		}
+		c.count(&c.report.StoredFiles, 1)
+		if batch = append(batch, info); len(batch) < c.opts.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return flush()
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+package gc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/database/models"
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+	"github.com/op/go-logging"
+)
+
+const lockName = "medea:gc"
+
+var ErrRunning = errors.New("another garbage collection is running")
+
+// Trashed files can still be read and restored, so they keep their objects
+// like the files which aren't deleted.
+const garbageObjectCond = `o.updatedAt < ?
+	AND NOT EXISTS (SELECT 1 FROM files f WHERE f.objectId = o.id)
+	AND NOT EXISTS (SELECT 1 FROM histories h WHERE h.objectId = o.id)`
+
+// A chunk is garbage if no live object nor part of a pending upload session
+// refers to it.
+const garbageChunkCond = `c.updatedAt < ?
+	AND NOT EXISTS (SELECT 1 FROM object_chunk oc JOIN objects o ON o.id = oc.objectId
+		WHERE oc.chunkId = c.id AND NOT (` + garbageObjectCond + `))
+	AND NOT EXISTS (SELECT 1 FROM upload_parts p JOIN upload_sessions s ON s.id = p.sessionId
+		WHERE p.chunkId = c.id AND s.status = ? AND (s.expiredAt IS NULL OR s.expiredAt > ?))`
+
+const garbageUploadPartCond = `upload_parts.updatedAt < ?
+	AND NOT EXISTS (SELECT 1 FROM upload_sessions s WHERE s.id = upload_parts.sessionId
+		AND s.status = ? AND (s.expiredAt IS NULL OR s.expiredAt > ?))`
+
+const orphanObjectChunkCond = `object_chunk.createdAt < ?
+	AND NOT EXISTS (SELECT 1 FROM objects o WHERE o.id = object_chunk.objectId)`
+
+// Options of a collection. Rows and chunk files younger than GracePeriod are
+// kept, so the chunks written by uploads which are still running are safe.
+type Options struct {
+	GracePeriod time.Duration
+	BatchSize   int
+	DryRun      bool
+	RootPath    *string
+}
+
+func NewOptions(conf *config.GC) Options {
+	return Options{
+		GracePeriod: time.Duration(conf.GracePeriod) * time.Second,
+		BatchSize:   conf.BatchSize,
+	}
+}
+
+// Report counts what is collected, or what would be collected by a dry run.
+type Report struct {
+	DryRun        bool
+	Objects       int64
+	ObjectBytes   int64
+	ObjectChunks  int64
+	UploadParts   int64
+	Chunks        int64
+	ChunkBytes    int64
+	StrayFiles    int64
+	StrayBytes    int64
+	DeleteErrors  int64
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	LastDeleteErr error
+}
+
+type collector struct {
+	db     *gorm.DB
+	opts   Options
+	now    time.Time
+	cutoff time.Time
+	roots  []*storage.Root
+	report *Report
+}
+
+// Run marks the objects referenced by files and histories, and the chunks
+// referenced by them or by pending upload sessions, then sweeps the others
+// with the rows of object_chunk and upload_parts left behind, and the chunk
+// files which don't belong to any chunk.
+func Run(db *gorm.DB, opts Options) (report *Report, err error) {
+	var unlock func()
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if unlock, err = lock(db); err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	c := &collector{
+		db:     db,
+		opts:   opts,
+		now:    time.Now(),
+		report: &Report{DryRun: opts.DryRun},
+	}
+	c.cutoff = c.now.Add(-opts.GracePeriod)
+	c.report.StartedAt = c.now
+	if c.roots, err = models.ChunkRoots(opts.RootPath); err != nil {
+		return nil, err
+	}
+
+	for _, sweep := range []func() error{
+		c.sweepObjects,
+		c.sweepObjectChunks,
+		c.sweepUploadParts,
+		c.sweepChunks,
+		c.sweepStrayFiles,
+	} {
+		if err = sweep(); err != nil {
+			return c.report, err
+		}
+	}
+
+	c.report.FinishedAt = time.Now()
+	return c.report, nil
+}
+
+// Start runs the collection every interval in background until stop is
+// called, a round is skipped if another process is collecting.
+func Start(db *gorm.DB, opts Options, interval time.Duration, logger *logging.Logger) (stop func()) {
+	var (
+		ticker = time.NewTicker(interval)
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report, err := Run(db, opts)
+				switch {
+				case err == ErrRunning:
+					logger.Debug(err)
+				case err != nil:
+					logger.Errorf("garbage collection failed: %s", err)
+				default:
+					logger.Infof(
+						"garbage collection deleted %d objects, %d chunks (%d bytes), %d stray files (%d bytes)",
+						report.Objects, report.Chunks, report.ChunkBytes, report.StrayFiles, report.StrayBytes,
+					)
+					if report.LastDeleteErr != nil {
+						logger.Errorf("failed to delete %d chunk files: %s", report.DeleteErrors, report.LastDeleteErr)
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// lock makes sure only one collection runs among all processes sharing the
+// database, the lock is released when its connection is closed.
+func lock(db *gorm.DB) (unlock func(), err error) {
+	var (
+		ctx      = context.Background()
+		conn     *sql.Conn
+		acquired sql.NullInt64
+	)
+
+	if conn, err = db.DB().Conn(ctx); err != nil {
+		return nil, err
+	}
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", lockName).Scan(&acquired); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if acquired.Int64 != 1 {
+		_ = conn.Close()
+		return nil, ErrRunning
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		_ = conn.Close()
+	}, nil
+}
+
+// transaction runs fn in a transaction, the candidates selected outside of it
+// must be checked again with locking read inside fn.
+func (c *collector) transaction(fn func(tx *gorm.DB) error) (err error) {
+	tx := c.db.Begin()
+	if err = tx.Error; err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit().Error
+		}
+	}()
+	return fn(tx)
+}
+
+func (c *collector) sweepObjects() error {
+	var lastID uint64
+
+	for {
+		var (
+			objects []models.Object
+			err     error
+		)
+		err = c.db.Raw(
+			"SELECT o.* FROM objects o WHERE o.id > ? AND "+garbageObjectCond+" ORDER BY o.id LIMIT ?",
+			lastID, c.cutoff, c.opts.BatchSize,
+		).Scan(&objects).Error
+		if err != nil || len(objects) == 0 {
+			return err
+		}
+		lastID = objects[len(objects)-1].ID
+
+		err = c.transaction(func(tx *gorm.DB) error {
+			if !c.opts.DryRun {
+				var locked []models.Object
+				err := tx.Raw(
+					"SELECT o.* FROM objects o WHERE o.id IN (?) AND "+garbageObjectCond+" FOR UPDATE",
+					objectIDs(objects), c.cutoff,
+				).Scan(&locked).Error
+				if err != nil || len(locked) == 0 {
+					objects = nil
+					return err
+				}
+				objects = locked
+			}
+
+			var (
+				ids   = objectIDs(objects)
+				count int64
+			)
+			if c.opts.DryRun {
+				if err := tx.Model(&models.ObjectChunk{}).Where("objectId IN (?)", ids).Count(&count).Error; err != nil {
+					return err
+				}
+			} else {
+				var chunkIDs []uint64
+				if err := tx.Model(&models.ObjectChunk{}).Where("objectId IN (?)", ids).Pluck("chunkId", &chunkIDs).Error; err != nil {
+					return err
+				}
+				if err := models.AddChunkRefs(chunkIDs, -1, tx); err != nil {
+					return err
+				}
+				result := tx.Where("objectId IN (?)", ids).Delete(&models.ObjectChunk{})
+				if result.Error != nil {
+					return result.Error
+				}
+				count = result.RowsAffected
+				if err := tx.Where("id IN (?)", ids).Delete(&models.Object{}).Error; err != nil {
+					return err
+				}
+			}
+
+			c.report.ObjectChunks += count
+			c.report.Objects += int64(len(objects))
+			for _, object := range objects {
+				c.report.ObjectBytes += int64(object.Size)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *collector) sweepObjectChunks() error {
+	count, err := c.deleteInBatches(&models.ObjectChunk{}, "object_chunk", orphanObjectChunkCond, c.cutoff)
+	c.report.ObjectChunks += count
+	return err
+}
+
+func (c *collector) sweepUploadParts() error {
+	count, err := c.deleteInBatches(
+		&models.UploadPart{}, "upload_parts", garbageUploadPartCond,
+		c.cutoff, models.UploadSessionPending, c.now,
+	)
+	c.report.UploadParts += count
+	return err
+}
+
+type chunkRef struct {
+	ID      uint64
+	ChunkID uint64 `gorm:"column:chunkId"`
+}
+
+// deleteInBatches deletes the rows of table matching cond and releases the
+// chunks they refer to, or only counts them in a dry run.
+func (c *collector) deleteInBatches(model interface{}, table, cond string, args ...interface{}) (total int64, err error) {
+	if c.opts.DryRun {
+		err = c.db.Model(model).Where(cond, args...).Count(&total).Error
+		return total, err
+	}
+
+	for {
+		var refs []chunkRef
+		err = c.db.Raw("SELECT id, chunkId FROM "+table+" WHERE "+cond+" LIMIT ?", append(args, c.opts.BatchSize)...).
+			Scan(&refs).Error
+		if err != nil || len(refs) == 0 {
+			return total, err
+		}
+
+		err = c.transaction(func(tx *gorm.DB) error {
+			var (
+				locked   []chunkRef
+				ids      []uint64
+				chunkIDs []uint64
+			)
+			for _, ref := range refs {
+				ids = append(ids, ref.ID)
+			}
+			err := tx.Raw("SELECT id, chunkId FROM "+table+" WHERE id IN (?) AND "+cond+" FOR UPDATE", append([]interface{}{ids}, args...)...).
+				Scan(&locked).Error
+			if err != nil || len(locked) == 0 {
+				return err
+			}
+			ids = ids[:0]
+			for _, ref := range locked {
+				ids = append(ids, ref.ID)
+				chunkIDs = append(chunkIDs, ref.ChunkID)
+			}
+			if err = models.AddChunkRefs(chunkIDs, -1, tx); err != nil {
+				return err
+			}
+			result := tx.Where("id IN (?)", ids).Delete(model)
+			total += result.RowsAffected
+			return result.Error
+		})
+		if err != nil || len(refs) < c.opts.BatchSize {
+			return total, err
+		}
+	}
+}
+
+func (c *collector) sweepChunks() error {
+	var lastID uint64
+
+	for {
+		var (
+			chunks []models.Chunk
+			err    error
+		)
+		err = c.db.Raw(
+			"SELECT c.* FROM chunks c WHERE c.id > ? AND "+garbageChunkCond+" ORDER BY c.id LIMIT ?",
+			lastID, c.cutoff, c.cutoff, models.UploadSessionPending, c.now, c.opts.BatchSize,
+		).Scan(&chunks).Error
+		if err != nil || len(chunks) == 0 {
+			return err
+		}
+		lastID = chunks[len(chunks)-1].ID
+
+		placements := make(map[uint64][]models.ChunkPlacement)
+		if !c.opts.DryRun {
+			// rows are deleted before the files, a failure leaves stray files
+			// only, which are collected next time
+			err = c.transaction(func(tx *gorm.DB) error {
+				var (
+					locked []models.Chunk
+					placed []models.ChunkPlacement
+				)
+				err := tx.Raw(
+					"SELECT c.* FROM chunks c WHERE c.id IN (?) AND "+garbageChunkCond+" FOR UPDATE",
+					chunkIDs(chunks), c.cutoff, c.cutoff, models.UploadSessionPending, c.now,
+				).Scan(&locked).Error
+				if chunks = locked; err != nil || len(locked) == 0 {
+					return err
+				}
+				ids := chunkIDs(chunks)
+				if err = tx.Where("chunkId IN (?)", ids).Find(&placed).Error; err != nil {
+					return err
+				}
+				for _, placement := range placed {
+					placements[placement.ChunkID] = append(placements[placement.ChunkID], placement)
+				}
+				if err = tx.Where("chunkId IN (?)", ids).Delete(&models.ChunkPlacement{}).Error; err != nil {
+					return err
+				}
+				return tx.Where("id IN (?)", ids).Delete(&models.Chunk{}).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, chunk := range chunks {
+			c.report.Chunks++
+			c.report.ChunkBytes += int64(chunk.StoredSize)
+			if c.opts.DryRun || chunk.IsPacked() {
+				// the section of a packed chunk is reclaimed by compaction
+				continue
+			}
+			key, err := chunk.Key()
+			for _, root := range models.PlacedRoots(placements[chunk.ID], c.roots) {
+				c.deleteFile(root.Store, key, err)
+			}
+		}
+	}
+}
+
+// sweepStrayFiles deletes the chunk files older than grace period whose chunk
+// doesn't exist, they are left by failed uploads and failed deletions.
+func (c *collector) sweepStrayFiles() error {
+	for _, root := range c.roots {
+		if err := c.sweepStrayFilesOf(root.Store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *collector) sweepStrayFilesOf(store storage.ChunkStore) error {
+	var batch []*storage.Info
+
+	flush := func() error {
+		var (
+			ids      = make([]uint64, 0, len(batch))
+			existing []uint64
+		)
+		for _, info := range batch {
+			id, _ := models.ChunkIDFromKey(info.Key)
+			ids = append(ids, id)
+		}
+		if err := c.db.Model(&models.Chunk{}).Where("id IN (?)", ids).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		exists := make(map[uint64]bool, len(existing))
+		for _, id := range existing {
+			exists[id] = true
+		}
+
+		for index, info := range batch {
+			if exists[ids[index]] {
+				continue
+			}
+			c.report.StrayFiles++
+			c.report.StrayBytes += info.Size
+			if !c.opts.DryRun {
+				c.deleteFile(store, info.Key, nil)
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	err := store.List("", func(info *storage.Info) error {
+		if _, ok := models.ChunkIDFromKey(info.Key); !ok || !info.ModTime.Before(c.cutoff) {
+			return nil
+		}
+		batch = append(batch, info)
+		if len(batch) < c.opts.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return flush()
+	}
+	return nil
+}
+
+func (c *collector) deleteFile(store storage.ChunkStore, key string, err error) {
+	if err == nil {
+		err = store.Delete(key)
+	}
+	if err != nil {
+		c.report.DeleteErrors++
+		c.report.LastDeleteErr = err
+	}
+}
+
+func objectIDs(objects []models.Object) []uint64 {
+	ids := make([]uint64, len(objects))
+	for index := range objects {
+		ids[index] = objects[index].ID
+	}
+	return ids
+}
+
+func chunkIDs(chunks []models.Chunk) []uint64 {
+	ids := make([]uint64, len(chunks))
+	for index := range chunks {
+		ids[index] = chunks[index].ID
+	}
+	return ids
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"medea/pkg/database/models"
+	"medea/pkg/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+var archiveContentTypes = map[string]string{
+	service.ArchiveZip:   "application/zip",
+	service.ArchiveTar:   "application/x-tar",
+	service.ArchiveTarGz: "application/gzip",
+}
+
+type directoryArchiveInput struct {
+	Token   string  `form:"token" binding:"required"`
+	Nonce   string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	Sign    *string `form:"sign" binding:"omitempty"`
+	SubDir  *string `form:"subDir,default=/" binding:"omitempty"`
+	Format  *string `form:"format,default=zip" binding:"omitempty"`
+	Include *string `form:"include" binding:"omitempty"`
+	Exclude *string `form:"exclude" binding:"omitempty"`
+	Hidden  *bool   `form:"hidden,default=0" binding:"omitempty"`
+}
+
+// DirectoryArchiveHandler streams the subtree of a directory as zip or tar,
+// the archive is built on the fly so its length isn't known in advance.
+func DirectoryArchiveHandler(ctx *gin.Context) {
+	var (
+		ip                       = ctx.ClientIP()
+		db                       = ctx.MustGet("db").(*gorm.DB)
+		err                      error
+		token                    = ctx.MustGet("token").(*models.Token)
+		input                    = ctx.MustGet("inputParam").(*directoryArchiveInput)
+		requestID                = ctx.GetInt64("requestId")
+		directoryArchiveSrv      *service.DirectoryArchive
+		directoryArchiveSrvValue interface{}
+		directoryArchiveSrvResp  *service.DirectoryArchiveResponse
+	)
+
+	directoryArchiveSrv = &service.DirectoryArchive{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		IP:          &ip,
+		SubDir:      *input.SubDir,
+		Format:      *input.Format,
+		Include:     splitPatterns(input.Include),
+		Exclude:     splitPatterns(input.Exclude),
+	}
+	if input.Hidden != nil && *input.Hidden {
+		directoryArchiveSrv.Hidden = 1
+	}
+
+	if isTesting {
+		directoryArchiveSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = directoryArchiveSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		ctx.JSON(400, &Response{
+			RequestID: requestID,
+			Success:   false,
+			Errors:    generateErrors(err, ""),
+		})
+		return
+	}
+
+	if directoryArchiveSrvValue, err = directoryArchiveSrv.Execute(context.Background()); err != nil {
+		ctx.JSON(400, &Response{
+			RequestID: requestID,
+			Success:   false,
+			Errors:    generateErrors(err, ""),
+		})
+		return
+	}
+	directoryArchiveSrvResp = directoryArchiveSrvValue.(*service.DirectoryArchiveResponse)
+
+	ctx.Set("ignoreRespBody", true)
+	ctx.Header("Content-Type", archiveContentTypes[directoryArchiveSrvResp.Format])
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, directoryArchiveSrvResp.Name))
+	ctx.Status(http.StatusOK)
+
+	// the status is sent already, a failure can only cut the archive short
+	if _, err = directoryArchiveSrvResp.WriteTo(ctx.Writer); err != nil {
+		_ = ctx.Error(err)
+	}
+}
+
+// splitPatterns splits the comma separated glob patterns of s.
+func splitPatterns(s *string) []string {
+	var patterns []string
+	if s == nil {
+		return nil
+	}
+	for _, pattern := range strings.Split(*s, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"medea/pkg/service"
+	"medea/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkPreconditions evaluates the conditional headers of RFC 9110 against
+// etag and modified of a file, it returns the status to respond with instead
+// of the content, or 0 if the request goes on.
+func checkPreconditions(r *http.Request, etag string, modified time.Time) int {
+	var (
+		safe = r.Method == http.MethodGet || r.Method == http.MethodHead
+		t    time.Time
+		err  error
+	)
+	modified = modified.UTC().Truncate(time.Second)
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !utils.MatchETag(ifMatch, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err = http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modified.After(t) {
+		return http.StatusPreconditionFailed
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if !utils.MatchETag(ifNoneMatch, etag, true) {
+			return 0
+		}
+		if safe {
+			return http.StatusNotModified
+		}
+		return http.StatusPreconditionFailed
+	}
+
+	if safe {
+		if t, err = http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// respondPrecondition responds status of checkPreconditions, 304 carries the
+// validators of the file only.
+func respondPrecondition(ctx *gin.Context, status int, headers map[string]string) {
+	if status != http.StatusNotModified {
+		ctx.JSON(status, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   false,
+			Errors:    generateErrors(service.ErrPreconditionFailed, "precondition"),
+		})
+		return
+	}
+
+	for _, key := range []string{"ETag", "Last-Modified", "Digest", "Repr-Digest"} {
+		if value, ok := headers[key]; ok {
+			ctx.Header(key, value)
+		}
+	}
+	ctx.Set("ignoreRespBody", true)
+	ctx.Status(status)
+	ctx.Writer.WriteHeaderNow()
+}
+
+// writeHeaders responds headers only, as to HEAD requests.
+func writeHeaders(ctx *gin.Context, headers map[string]string) {
+	for key, value := range headers {
+		ctx.Header(key, value)
+	}
+	ctx.Set("ignoreRespBody", true)
+	ctx.Status(http.StatusOK)
+	ctx.Writer.WriteHeaderNow()
+}
+
+// ifMatch returns the If-Match header of request, nil if it isn't given.
+func ifMatch(ctx *gin.Context) *string {
+	if value := ctx.GetHeader("If-Match"); value != "" {
+		return &value
+	}
+	return nil
+}
+
+// preconditionCode is 412 if err is a failed If-Match, code otherwise.
+func preconditionCode(err error, code int) int {
+	if err == service.ErrPreconditionFailed {
+		return http.StatusPreconditionFailed
+	}
+	return code
+}
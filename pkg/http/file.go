@@ -3,6 +3,8 @@ package http
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -13,10 +15,8 @@ import (
 	"net/http"
 	"path"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"medea/pkg/utils"
 
@@ -26,10 +26,6 @@ import (
 
 var testingChunkRootPath *string
 
-var ErrWrongRangeHeader = errors.New("http range header format error")
-
-var ErrWrongHTTPRange = errors.New("wrong http range header, start must be less than end")
-
 const binaryContentType = "application/octet-stream"
 
 type fileCreateInput struct {
@@ -47,25 +43,72 @@ type fileCreateInput struct {
 
 type fileReadInput struct {
 	Token         string  `form:"token" binding:"required"`
-	FileUID       string  `form:"fileUid" binding:"required"`
+	FileUID       *string `form:"fileUid" binding:"omitempty"`
+	Path          *string `form:"path" binding:"omitempty,max=1000"`
 	Nonce         *string `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
 	Sign          *string `form:"sign" binding:"omitempty"`
 	OpenInBrowser bool    `form:"openInBrowser,default=0" binding:"omitempty"`
 }
 
 type fileUpdateInput struct {
+	Token    string  `form:"token" binding:"required"`
+	FileUID  *string `form:"fileUid" binding:"omitempty"`
+	FilePath *string `form:"filePath" binding:"omitempty,max=1000"`
+	Nonce    string  `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
+	Sign     *string `form:"sign" binding:"omitempty"`
+	Hidden   *int8   `form:"hidden" binding:"omitempty"`
+	Path     *string `form:"path" binding:"required,max=1000"`
+}
+
+type fileCopyInput struct {
+	Token     string  `form:"token" binding:"required"`
+	FileUID   *string `form:"fileUid" binding:"omitempty"`
+	FilePath  *string `form:"filePath" binding:"omitempty,max=1000"`
+	Nonce     string  `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
+	Sign      *string `form:"sign" binding:"omitempty"`
+	Path      string  `form:"path" binding:"required,max=1000"`
+	Overwrite *bool   `form:"overwrite,default=0" binding:"omitempty"`
+	Rename    *bool   `form:"rename,default=0" binding:"omitempty"`
+	DstToken  *string `form:"dstToken" binding:"omitempty"`
+	DstSign   *string `form:"dstSign" binding:"omitempty"`
+}
+
+type fileComposeInput struct {
+	Token     string  `form:"token" binding:"required"`
+	FileUIDs  *string `form:"fileUids" binding:"omitempty"`
+	Paths     *string `form:"paths" binding:"omitempty"`
+	Nonce     string  `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
+	Sign      *string `form:"sign" binding:"omitempty"`
+	Path      string  `form:"path" binding:"required,max=1000"`
+	Overwrite *bool   `form:"overwrite,default=0" binding:"omitempty"`
+	Rename    *bool   `form:"rename,default=0" binding:"omitempty"`
+	Hidden    *bool   `form:"hidden,default=0" binding:"omitempty"`
+}
+
+type fileWriteInput struct {
 	Token   string  `form:"token" binding:"required"`
-	FileUID string  `form:"fileUid" binding:"required"`
-	Nonce   string  `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
+	FileUID *string `form:"fileUid" binding:"omitempty"`
+	Path    *string `form:"path" binding:"omitempty,max=1000"`
+	Nonce   string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
 	Sign    *string `form:"sign" binding:"omitempty"`
+	Offset  *int    `form:"offset" binding:"required,min=0"`
 	Hidden  *int8   `form:"hidden" binding:"omitempty"`
-	Path    *string `form:"path" binding:"required,max=1000"`
+}
+
+type fileTruncateInput struct {
+	Token   string  `form:"token" binding:"required"`
+	FileUID *string `form:"fileUid" binding:"omitempty"`
+	Path    *string `form:"path" binding:"omitempty,max=1000"`
+	Nonce   string  `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
+	Sign    *string `form:"sign" binding:"omitempty"`
+	Size    *int    `form:"size" binding:"required,min=0"`
 }
 
 type fileDeleteInput struct {
 	Token   string  `form:"token" binding:"required"`
 	Nonce   string  `form:"nonce" header:"X-Request-Nonce" binding:"omitempty,min=32,max=48"`
-	FileUID string  `form:"fileUid" binding:"required"`
+	FileUID *string `form:"fileUid" binding:"omitempty"`
+	Path    *string `form:"path" binding:"omitempty,max=1000"`
 	Force   bool    `form:"force,default=0"  binding:"omitempty"`
 	Sign    *string `form:"sign" binding:"omitempty"`
 }
@@ -80,6 +123,22 @@ type directoryListInput struct {
 	Offset *int    `form:"offset,default=0" binding:"omitempty,min=0"`
 }
 
+// findFile finds the file of uid, or else of path within the scope of token,
+// pathKey is the name of the path param, it returns the param the file is
+// found by for reporting errors.
+func findFile(uid, path *string, pathKey string, token *models.Token, db *gorm.DB) (*models.File, string, error) {
+	switch {
+	case uid != nil && len(*uid) > 0:
+		file, err := models.FindFileByUID(*uid, false, db)
+		return file, "fileUid", err
+	case path != nil && len(*path) > 0:
+		file, err := models.FindFileByPath(&token.App, token.PathWithScope(*path), db, false)
+		return file, pathKey, err
+	default:
+		return nil, "fileUid", fmt.Errorf("either fileUid or %s is required", pathKey)
+	}
+}
+
 func FileCreateHandler(ctx *gin.Context) {
 	var (
 		fh     *multipart.FileHeader
@@ -136,12 +195,13 @@ func FileCreateHandler(ctx *gin.Context) {
 		}
 
 		reader = buf
-		if reErrors = validateHashAndSize(input, buf); reErrors != nil {
+		if reErrors = validateHashAndSize(input.Hash, input.Size, buf); reErrors != nil {
 			return
 		}
 	}
 
 	fileCreateSrv.Reader = reader
+	fileCreateSrv.IfMatch = ifMatch(ctx)
 	setFileCreateSrv(input, fileCreateSrv)
 
 	if err := fileCreateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
@@ -150,6 +210,7 @@ func FileCreateHandler(ctx *gin.Context) {
 	}
 
 	if fileCreateValue, err = fileCreateSrv.Execute(context.Background()); err != nil {
+		code = preconditionCode(err, code)
 		reErrors = generateErrors(err, "")
 		return
 	}
@@ -182,12 +243,109 @@ func setFileCreateSrv(input *fileCreateInput, fileCreateSrv *service.FileCreate)
 	}
 }
 
-func validateHashAndSize(input *fileCreateInput, buf *bytes.Buffer) (reErrors map[string][]string) {
-	if input.Hash != nil || input.Size != nil {
-		if input.Size != nil && buf.Len() != *input.Size {
+// FileStreamHandler creates a file from the request body without buffering
+// it, the body is either the raw content or a multipart stream with a "file"
+// part, all params are taken from the query string.
+func FileStreamHandler(ctx *gin.Context) {
+	var (
+		err    error
+		reader io.Reader
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+
+		db            = ctx.MustGet("db").(*gorm.DB)
+		ip            = ctx.ClientIP()
+		input         = ctx.MustGet("inputParam").(*fileCreateInput)
+		fileCreateSrv = &service.FileCreate{
+			BaseService: service.BaseService{DB: db},
+			IP:          &ip,
+			Path:        input.Path,
+			Token:       ctx.MustGet("token").(*models.Token),
+			Size:        input.Size,
+			Hash:        input.Hash,
+		}
+
+		fileCreateValue interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if reader, err = streamBodyReader(ctx); err != nil {
+		reErrors = generateErrors(err, "file")
+		return
+	}
+
+	fileCreateSrv.Reader = reader
+	fileCreateSrv.IfMatch = ifMatch(ctx)
+	setFileCreateSrv(input, fileCreateSrv)
+
+	if err := fileCreateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if fileCreateValue, err = fileCreateSrv.Execute(context.Background()); err != nil {
+		code = preconditionCode(err, code)
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(fileCreateValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
+
+func streamBodyReader(ctx *gin.Context) (io.Reader, error) {
+	var (
+		err    error
+		part   *multipart.Part
+		reader *multipart.Reader
+	)
+
+	if ctx.ContentType() != gin.MIMEMultipartPOSTForm {
+		return ctx.Request.Body, nil
+	}
+
+	// QueryFormMiddleware fills MultipartForm with the query params,
+	// it must be cleared before the body can be read as a stream
+	ctx.Request.MultipartForm = nil
+	if reader, err = ctx.Request.MultipartReader(); err != nil {
+		return nil, err
+	}
+
+	for {
+		if part, err = reader.NextPart(); err != nil {
+			if err == io.EOF {
+				return nil, http.ErrMissingFile
+			}
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+func validateHashAndSize(hash *string, size *int, buf *bytes.Buffer) (reErrors map[string][]string) {
+	if hash != nil || size != nil {
+		if size != nil && buf.Len() != *size {
 			reErrors = generateErrors(errors.New("the size of file doesn't match"), "size")
 		}
-		if input.Hash != nil {
+		if hash != nil {
 			var (
 				h   string
 				err error
@@ -195,7 +353,7 @@ func validateHashAndSize(input *fileCreateInput, buf *bytes.Buffer) (reErrors ma
 			if h, err = utils.Sha256Hash2String(buf.Bytes()); err != nil {
 				reErrors = generateErrors(err, "")
 			}
-			if h != *input.Hash {
+			if h != *hash {
 				reErrors = generateErrors(errors.New("the hash of file doesn't match"), "hash")
 			}
 		}
@@ -209,6 +367,7 @@ func FileInfoHandler(ctx *gin.Context) {
 		db               = ctx.MustGet("db").(*gorm.DB)
 		err              error
 		file             *models.File
+		field            string
 		token            = ctx.MustGet("token").(*models.Token)
 		input            = ctx.MustGet("inputParam").(*fileReadInput)
 		requestID        = ctx.GetInt64("requestId")
@@ -217,11 +376,11 @@ func FileInfoHandler(ctx *gin.Context) {
 		fileReadSrvValue interface{}
 	)
 
-	if file, err = models.FindFileByUID(input.FileUID, false, db); err != nil {
+	if file, field, err = findFile(input.FileUID, input.Path, "path", token, db); err != nil {
 		ctx.JSON(400, &Response{
 			RequestID: requestID,
 			Success:   false,
-			Errors:    generateErrors(err, "fileUid"),
+			Errors:    generateErrors(err, field),
 		})
 		return
 	}
@@ -256,20 +415,17 @@ func FileInfoHandler(ctx *gin.Context) {
 	}
 
 	readerSeeker = fileReadSrvValue.(io.ReadSeeker)
-	rangeHeader := ctx.Request.Header.Get("Range")
-	if rangeHeader == "" {
-		readAllContent(ctx, readerSeeker, file, input)
+	headers := fileHeaders(file, input.OpenInBrowser)
+	if status := checkPreconditions(ctx.Request, headers["ETag"], file.UpdatedAt); status != 0 {
+		respondPrecondition(ctx, status, headers)
 		return
 	}
-
-	headers := map[string]string{
-		"ETag":                file.Object.Hash,
-		"Accept-Ranges":       "bytes",
-		"Content-Type":        binaryContentType,
-		"Last-Modified":       file.UpdatedAt.Format(time.RFC1123),
-		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, file.Name),
+	if ctx.Request.Header.Get("Range") == "" {
+		readAllContent(ctx, readerSeeker, file, headers)
+		return
 	}
-	headers["Content-Length"] = strconv.Itoa(file.Size)
+
+	headers["Content-Type"] = binaryContentType
 	ctx.Set("ignoreRespBody", true)
 
 	for k, v := range headers {
@@ -284,6 +440,7 @@ func FileReadHandler(ctx *gin.Context) {
 		db               = ctx.MustGet("db").(*gorm.DB)
 		err              error
 		file             *models.File
+		field            string
 		token            = ctx.MustGet("token").(*models.Token)
 		input            = ctx.MustGet("inputParam").(*fileReadInput)
 		requestID        = ctx.GetInt64("requestId")
@@ -292,11 +449,11 @@ func FileReadHandler(ctx *gin.Context) {
 		fileReadSrvValue interface{}
 	)
 
-	if file, err = models.FindFileByUID(input.FileUID, false, db); err != nil {
+	if file, field, err = findFile(input.FileUID, input.Path, "path", token, db); err != nil {
 		ctx.JSON(400, &Response{
 			RequestID: requestID,
 			Success:   false,
-			Errors:    generateErrors(err, "fileUid"),
+			Errors:    generateErrors(err, field),
 		})
 		return
 	}
@@ -330,81 +487,101 @@ func FileReadHandler(ctx *gin.Context) {
 		return
 	}
 	fileReaderSeeker = fileReadSrvValue.(io.ReadSeeker)
-	rangeHeader := ctx.Request.Header.Get("Range")
-	if rangeHeader == "" {
-		readAllContent(ctx, fileReaderSeeker, file, input)
+	serveFile(ctx, fileReaderSeeker, file, input.OpenInBrowser)
+}
+
+// serveFile responds the content of file read from fileReaderSeeker, along with
+// the handling of conditional, HEAD and range requests.
+func serveFile(ctx *gin.Context, fileReaderSeeker io.ReadSeeker, file *models.File, openInBrowser bool) {
+	headers := fileHeaders(file, openInBrowser)
+	if status := checkPreconditions(ctx.Request, headers["ETag"], file.UpdatedAt); status != 0 {
+		respondPrecondition(ctx, status, headers)
 		return
 	}
-	rangeHeaderPattern := regexp.MustCompile(`^bytes=(?P<start>\d*)-(?P<end>\d*)$`)
-	if !rangeHeaderPattern.Match([]byte(rangeHeader)) {
-		ctx.JSON(400, &Response{
-			RequestID: requestID,
-			Success:   false,
-			Errors:    generateErrors(ErrWrongRangeHeader, ""),
-		})
+	// range handling isn't defined for HEAD, the whole file is described
+	if ctx.Request.Method == http.MethodHead {
+		writeHeaders(ctx, headers)
 		return
 	}
-	rangePosition := strings.TrimPrefix(rangeHeader, "bytes=")
-	rangeStart := 0
-	rangeEnd := file.Size
-	if rangePosition == "-" {
-		readAllContent(ctx, fileReaderSeeker, file, input)
+	models.RecordDownload(file.ID)
+
+	rangeHeader := ctx.Request.Header.Get("Range")
+	if rangeHeader == "" || !matchIfRange(ctx.Request.Header.Get("If-Range"), headers["ETag"], file.UpdatedAt) {
+		readAllContent(ctx, fileReaderSeeker, file, headers)
 		return
-	} else if strings.HasPrefix(rangePosition, "-") {
-		rangeEnd, _ = strconv.Atoi(strings.TrimPrefix(rangePosition, "-"))
-	} else if strings.HasSuffix(rangePosition, "-") {
-		rangeStart, _ = strconv.Atoi(strings.TrimSuffix(rangePosition, "-"))
-	} else {
-		rangePositionSplit := strings.Split(rangePosition, "-")
-		rangeStart, _ = strconv.Atoi(rangePositionSplit[0])
-		rangeEnd, _ = strconv.Atoi(rangePositionSplit[1])
 	}
 
-	if rangeStart > rangeEnd {
-		ctx.JSON(400, &Response{
-			RequestID: requestID,
-			Success:   false,
-			Errors:    generateErrors(ErrWrongHTTPRange, ""),
-		})
+	ranges, err := parseRange(rangeHeader, int64(file.Size))
+	if err == ErrUnsatisfiableRange {
+		rangeNotSatisfiable(ctx, file)
+		return
+	}
+	// a malformed Range header is ignored as RFC 9110 allows
+	if err != nil {
+		readAllContent(ctx, fileReaderSeeker, file, headers)
 		return
 	}
-	readRangeContent(ctx, fileReaderSeeker, file, input, rangeStart, rangeEnd)
+	readRanges(ctx, fileReaderSeeker, file, headers, ranges)
+}
+
+// fileETag is the strong entity tag of file, which is the hash of its object.
+func fileETag(file *models.File) string {
+	return utils.ETag(file.Object.Hash)
 }
 
-func readAllContent(ctx *gin.Context, readerSeeker io.ReadSeeker, file *models.File, input *fileReadInput) {
+// fileHeaders returns the headers describing the whole content of file.
+func fileHeaders(file *models.File, openInBrowser bool) map[string]string {
 	headers := map[string]string{
-		"ETag":                file.Object.Hash,
+		"ETag":                fileETag(file),
 		"Accept-Ranges":       "bytes",
 		"Content-Type":        binaryContentType,
-		"Last-Modified":       file.UpdatedAt.Format(time.RFC1123),
+		"Content-Length":      strconv.Itoa(file.Size),
+		"Last-Modified":       file.UpdatedAt.UTC().Format(http.TimeFormat),
 		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, file.Name),
 	}
-	headers["Content-Length"] = strconv.Itoa(file.Size)
 	if contentType := mime.TypeByExtension(path.Ext(file.Name)); contentType != "" {
 		headers["Content-Type"] = contentType
 	}
-	if input.OpenInBrowser {
+	if openInBrowser {
 		headers["Content-Disposition"] = fmt.Sprintf(`inline; filename="%s"`, file.Name)
 	}
+	setDigestHeaders(headers, &file.Object)
+	return headers
+}
+
+func readAllContent(ctx *gin.Context, readerSeeker io.ReadSeeker, file *models.File, headers map[string]string) {
 	ctx.Set("ignoreRespBody", true)
 	ctx.DataFromReader(http.StatusOK, int64(file.Size), headers["Content-Type"], readerSeeker, headers)
 }
 
-func readRangeContent(ctx *gin.Context, readerSeeker io.ReadSeeker, file *models.File, input *fileReadInput, start, end int) {
-	if _, err := readerSeeker.Seek(int64(start), io.SeekStart); err != nil {
-		ctx.JSON(400, &Response{
-			RequestID: ctx.GetInt64("requestId"),
-			Success:   false,
-			Errors:    generateErrors(err, ""),
-		})
-		return
+var (
+	digestNames     = map[string]string{models.DigestSHA256: "SHA-256", models.DigestSHA512: "SHA-512", models.DigestMD5: "MD5", models.DigestCRC32C: "CRC32C"}
+	reprDigestNames = map[string]string{models.DigestSHA256: "sha-256", models.DigestSHA512: "sha-512", models.DigestMD5: "md5", models.DigestCRC32C: "crc32c"}
+)
+
+// setDigestHeaders sets the digests of the whole content of object as Digest
+// (RFC 3230) and Repr-Digest (RFC 9530), also for range responses.
+func setDigestHeaders(headers map[string]string, object *models.Object) {
+	var (
+		digest     []string
+		reprDigest []string
+		sums       = object.DigestValues()
+	)
+
+	for _, algorithm := range append([]string{models.DigestSHA256}, models.ExtraDigests...) {
+		sum, err := hex.DecodeString(sums[algorithm])
+		if err != nil || len(sum) == 0 {
+			continue
+		}
+		value := base64.StdEncoding.EncodeToString(sum)
+		digest = append(digest, digestNames[algorithm]+"="+value)
+		reprDigest = append(reprDigest, reprDigestNames[algorithm]+"=:"+value+":")
+	}
+
+	if len(digest) > 0 {
+		headers["Digest"] = strings.Join(digest, ",")
+		headers["Repr-Digest"] = strings.Join(reprDigest, ", ")
 	}
-	limitSize := int64(end-start) + 1
-	limitReader := io.LimitReader(readerSeeker, limitSize)
-	ctx.Set("ignoreRespBody", true)
-	ctx.DataFromReader(http.StatusPartialContent, limitSize, binaryContentType, limitReader, map[string]string{
-		"Content-Range": fmt.Sprintf("%d-%d/%d", start, end, file.Size),
-	})
 }
 
 var ErrInvalidSortTypes = errors.New("invalid sort types, only one of type, -type, name, -name, time and -time")
@@ -498,6 +675,7 @@ func FileUpdateHandler(ctx *gin.Context) {
 		db                 = ctx.MustGet("db").(*gorm.DB)
 		err                error
 		file               *models.File
+		field              string
 		token              = ctx.MustGet("token").(*models.Token)
 		input              = ctx.MustGet("inputParam").(*fileUpdateInput)
 		fileUpdateSrv      *service.FileUpdate
@@ -518,8 +696,8 @@ func FileUpdateHandler(ctx *gin.Context) {
 		})
 	}()
 
-	if file, err = models.FindFileByUID(input.FileUID, false, db); err != nil {
-		reErrors = generateErrors(err, "fileUid")
+	if file, field, err = findFile(input.FileUID, input.FilePath, "filePath", token, db); err != nil {
+		reErrors = generateErrors(err, field)
 		return
 	}
 
@@ -527,11 +705,12 @@ func FileUpdateHandler(ctx *gin.Context) {
 		BaseService: service.BaseService{
 			DB: db,
 		},
-		Token:  token,
-		File:   file,
-		IP:     &ip,
-		Hidden: input.Hidden,
-		Path:   input.Path,
+		Token:   token,
+		File:    file,
+		IP:      &ip,
+		Hidden:  input.Hidden,
+		Path:    input.Path,
+		IfMatch: ifMatch(ctx),
 	}
 
 	if isTesting {
@@ -544,6 +723,7 @@ func FileUpdateHandler(ctx *gin.Context) {
 	}
 
 	if fileUpdateSrvValue, err = fileUpdateSrv.Execute(context.Background()); err != nil {
+		code = preconditionCode(err, code)
 		reErrors = generateErrors(err, "")
 		return
 	}
@@ -557,12 +737,333 @@ func FileUpdateHandler(ctx *gin.Context) {
 	success = true
 }
 
+// FileCopyHandler copies a file or directory, to another app if dstToken is
+// given, the request is then signed by dstSign with the secret of dstToken too.
+func FileCopyHandler(ctx *gin.Context) {
+	var (
+		ip               = ctx.ClientIP()
+		db               = ctx.MustGet("db").(*gorm.DB)
+		err              error
+		file             *models.File
+		field            string
+		dstToken         *models.Token
+		token            = ctx.MustGet("token").(*models.Token)
+		input            = ctx.MustGet("inputParam").(*fileCopyInput)
+		fileCopySrv      *service.FileCopy
+		fileCopySrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if file, field, err = findFile(input.FileUID, input.FilePath, "filePath", token, db); err != nil {
+		reErrors = generateErrors(err, field)
+		return
+	}
+
+	if input.DstToken != nil && *input.DstToken != token.UID {
+		if dstToken, err = models.FindTokenByUID(*input.DstToken, db); err != nil {
+			reErrors = generateErrors(err, "dstToken")
+			return
+		}
+		if dstToken.Secret != nil && !validateSignature(ctx, *dstToken.Secret, "dstSign") {
+			reErrors = generateErrors(errors.New("request param dstSign error"), "dstSign")
+			return
+		}
+	}
+
+	fileCopySrv = &service.FileCopy{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		File:        file,
+		IP:          &ip,
+		DstToken:    dstToken,
+		Path:        input.Path,
+	}
+	if input.Overwrite != nil && *input.Overwrite {
+		fileCopySrv.Overwrite = 1
+	}
+	if input.Rename != nil && *input.Rename {
+		fileCopySrv.Rename = 1
+	}
+
+	if isTesting {
+		fileCopySrv.RootPath = testingChunkRootPath
+	}
+
+	if err = fileCopySrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if fileCopySrvValue, err = fileCopySrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(fileCopySrvValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
+
+// FileComposeHandler saves the concatenated content of the files listed by
+// fileUids, comma separated, to path.
+func FileComposeHandler(ctx *gin.Context) {
+	var (
+		ip                  = ctx.ClientIP()
+		db                  = ctx.MustGet("db").(*gorm.DB)
+		err                 error
+		files               []*models.File
+		entries             []string
+		token               = ctx.MustGet("token").(*models.Token)
+		input               = ctx.MustGet("inputParam").(*fileComposeInput)
+		list                = input.FileUIDs
+		listKey             = "fileUids"
+		fileComposeSrv      *service.FileCompose
+		fileComposeSrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	// the files are listed by fileUids, or else by paths under the token
+	if list == nil || len(*list) == 0 {
+		list, listKey = input.Paths, "paths"
+	}
+	if list == nil || len(*list) == 0 {
+		reErrors = generateErrors(errors.New("either fileUids or paths is required"), "fileUids")
+		return
+	}
+	if entries = strings.Split(*list, ","); len(entries) > service.MaxComposeFiles {
+		reErrors = generateErrors(fmt.Errorf("at most %d files can be composed", service.MaxComposeFiles), listKey)
+		return
+	}
+	for _, entry := range entries {
+		var (
+			file      *models.File
+			uid, path *string
+		)
+		if entry = strings.TrimSpace(entry); listKey == "paths" {
+			path = &entry
+		} else {
+			uid = &entry
+		}
+		if file, _, err = findFile(uid, path, listKey, token, db); err != nil {
+			reErrors = generateErrors(err, listKey)
+			return
+		}
+		files = append(files, file)
+	}
+
+	fileComposeSrv = &service.FileCompose{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		Files:       files,
+		IP:          &ip,
+		Path:        input.Path,
+	}
+	if input.Hidden != nil && *input.Hidden {
+		fileComposeSrv.Hidden = 1
+	}
+	if input.Overwrite != nil && *input.Overwrite {
+		fileComposeSrv.Overwrite = 1
+	}
+	if input.Rename != nil && *input.Rename {
+		fileComposeSrv.Rename = 1
+	}
+
+	if isTesting {
+		fileComposeSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = fileComposeSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if fileComposeSrvValue, err = fileComposeSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(fileComposeSrvValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
+
+// FileWriteHandler writes the request body at offset of a file without
+// buffering it, the body is taken as FileStreamHandler does.
+func FileWriteHandler(ctx *gin.Context) {
+	var (
+		ip                = ctx.ClientIP()
+		db                = ctx.MustGet("db").(*gorm.DB)
+		err               error
+		file              *models.File
+		field             string
+		reader            io.Reader
+		token             = ctx.MustGet("token").(*models.Token)
+		input             = ctx.MustGet("inputParam").(*fileWriteInput)
+		fileWriteSrv      *service.FileWrite
+		fileWriteSrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if file, field, err = findFile(input.FileUID, input.Path, "path", token, db); err != nil {
+		reErrors = generateErrors(err, field)
+		return
+	}
+
+	if reader, err = streamBodyReader(ctx); err != nil {
+		reErrors = generateErrors(err, "file")
+		return
+	}
+
+	fileWriteSrv = &service.FileWrite{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		File:        file,
+		IP:          &ip,
+		Reader:      reader,
+		Offset:      *input.Offset,
+		Hidden:      input.Hidden,
+	}
+
+	if isTesting {
+		fileWriteSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = fileWriteSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if fileWriteSrvValue, err = fileWriteSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(fileWriteSrvValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
+
+func FileTruncateHandler(ctx *gin.Context) {
+	var (
+		ip                   = ctx.ClientIP()
+		db                   = ctx.MustGet("db").(*gorm.DB)
+		err                  error
+		file                 *models.File
+		field                string
+		token                = ctx.MustGet("token").(*models.Token)
+		input                = ctx.MustGet("inputParam").(*fileTruncateInput)
+		fileTruncateSrv      *service.FileTruncate
+		fileTruncateSrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if file, field, err = findFile(input.FileUID, input.Path, "path", token, db); err != nil {
+		reErrors = generateErrors(err, field)
+		return
+	}
+
+	fileTruncateSrv = &service.FileTruncate{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		File:        file,
+		IP:          &ip,
+		Size:        *input.Size,
+	}
+
+	if isTesting {
+		fileTruncateSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = fileTruncateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if fileTruncateSrvValue, err = fileTruncateSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(fileTruncateSrvValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
+
 func FileDeleteHandler(ctx *gin.Context) {
 	var (
 		ip                 = ctx.ClientIP()
 		db                 = ctx.MustGet("db").(*gorm.DB)
 		err                error
 		file               *models.File
+		field              string
 		token              = ctx.MustGet("token").(*models.Token)
 		input              = ctx.MustGet("inputParam").(*fileDeleteInput)
 		requestID          = ctx.GetInt64("requestId")
@@ -584,8 +1085,8 @@ func FileDeleteHandler(ctx *gin.Context) {
 		})
 	}()
 
-	if file, err = models.FindFileByUID(input.FileUID, false, db); err != nil {
-		reErrors = generateErrors(err, "fileUid")
+	if file, field, err = findFile(input.FileUID, input.Path, "path", token, db); err != nil {
+		reErrors = generateErrors(err, field)
 		return
 	}
 
@@ -6,6 +6,8 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
+	"net/url"
 	"sort"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	"medea/pkg/database/models"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jinzhu/gorm"
 	"github.com/patrickmn/go-cache"
 	"golang.org/x/time/rate"
@@ -67,6 +70,30 @@ func RecordRequestMiddleware() gin.HandlerFunc {
 	}
 }
 
+// QueryFormMiddleware makes the query string the only source of request
+// params, so binding and signature checking never consume the request body,
+// which is left untouched for streaming.
+func QueryFormMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		query := ctx.Request.URL.Query()
+		ctx.Request.Form = query
+		ctx.Request.PostForm = url.Values{}
+		ctx.Request.MultipartForm = &multipart.Form{Value: query, File: map[string][]*multipart.FileHeader{}}
+		ctx.Set("queryForm", true)
+		ctx.Next()
+	}
+}
+
+// shouldBind binds the params of the request to input, only from the query
+// string after QueryFormMiddleware, as ShouldBind would decode the body by its
+// Content-Type otherwise.
+func shouldBind(ctx *gin.Context, input interface{}) error {
+	if _, ok := ctx.Get("queryForm"); ok {
+		return ctx.ShouldBindWith(input, binding.Query)
+	}
+	return ctx.ShouldBind(input)
+}
+
 func ParseAppMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var (
@@ -76,7 +103,7 @@ func ParseAppMiddleware() gin.HandlerFunc {
 			ok    bool
 			ctxDb interface{}
 		)
-		if err = ctx.ShouldBind(&input); err == nil {
+		if err = shouldBind(ctx, &input); err == nil {
 			if ctxDb, ok = ctx.Get("db"); ok {
 				if app, err = models.FindAppByUID(input.AppUID, ctxDb.(*gorm.DB)); err == nil {
 					reqRecord := ctx.MustGet("reqRecord").(*models.Request)
@@ -116,7 +143,7 @@ func ParseTokenMiddleware() gin.HandlerFunc {
 			requestID = ctx.GetInt64("requestId")
 			reqRecord = ctx.MustGet("reqRecord").(*models.Request)
 		)
-		if err = ctx.ShouldBind(&input); err == nil {
+		if err = shouldBind(ctx, &input); err == nil {
 			if token, err = models.FindTokenByUID(input.Token, db); err != nil {
 				ctx.AbortWithStatusJSON(400, &Response{
 					RequestID: requestID,
@@ -160,7 +187,7 @@ func ConfigContextMiddleware(db *gorm.DB) gin.HandlerFunc {
 
 func SignWithAppMiddleware(input interface{}) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if err := ctx.ShouldBind(input); err != nil {
+		if err := shouldBind(ctx, input); err != nil {
 			ctx.AbortWithStatusJSON(400, &Response{
 				RequestID: ctx.GetInt64("requestId"),
 				Success:   false,
@@ -189,7 +216,7 @@ func SignWithAppMiddleware(input interface{}) gin.HandlerFunc {
 
 func SignWithTokenMiddleware(input interface{}) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		if err := ctx.ShouldBind(input); err != nil {
+		if err := shouldBind(ctx, input); err != nil {
 			ctx.AbortWithStatusJSON(400, &Response{
 				RequestID: ctx.GetInt64("requestId"),
 				Success:   false,
@@ -216,9 +243,15 @@ func SignWithTokenMiddleware(input interface{}) gin.HandlerFunc {
 }
 
 func ValidateRequestSignature(ctx *gin.Context, secret string) bool {
+	return validateSignature(ctx, secret, "sign")
+}
+
+// validateSignature checks the signature in param signKey, which is made of
+// the params other than sign and signKey.
+func validateSignature(ctx *gin.Context, secret, signKey string) bool {
 	var (
 		params    = make(map[string]string)
-		sign      = ctx.Request.FormValue("sign")
+		sign      = ctx.Request.FormValue(signKey)
 		keys      = make([]string, 1)
 		signature = bytes.NewBufferString("")
 		m         = md5.New()
@@ -229,7 +262,7 @@ func ValidateRequestSignature(ctx *gin.Context, secret string) bool {
 	}
 
 	for k, v := range ctx.Request.Form {
-		if k != "sign" {
+		if k != "sign" && k != signKey {
 			params[k] = v[0]
 			keys = append(keys, k)
 		}
@@ -298,7 +331,7 @@ func ReplayAttackMiddleware() gin.HandlerFunc {
 			input     NonceInput
 			err       error
 		)
-		if err = ctx.ShouldBind(&input); err == nil {
+		if err = shouldBind(ctx, &input); err == nil {
 			if input.Nonce != nil {
 				if t, err := models.FindRequestWithAppAndNonce(app, *input.Nonce, db); err == nil && t.ID > 0 {
 					ctx.AbortWithStatusJSON(400, &Response{
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+
+	"medea/pkg/database/models"
+	"medea/pkg/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrPresignSigner      = errors.New("exactly one of appUid and token signs a presigned url")
+	ErrPresignTokenSecret = errors.New("a token without secret can't sign a presigned url")
+	ErrPresignWithoutFile = errors.New("fileUid is required to read by a presigned url")
+	ErrPresignWithoutPath = errors.New("path is required to upload by a presigned url")
+	ErrPresignSign        = errors.New("presigned url sign error")
+)
+
+// presignInput is a presigned url, all of its params are signed by the secret
+// of the app or the token, the signature is the only proof of the url.
+type presignInput struct {
+	AppUID        *string `form:"appUid" binding:"omitempty"`
+	Token         *string `form:"token" binding:"omitempty"`
+	Method        string  `form:"method" binding:"required,oneof=GET PUT"`
+	Expires       int64   `form:"expires" binding:"required,min=1"`
+	IP            *string `form:"ip" binding:"omitempty"`
+	MaxBytes      *int64  `form:"maxBytes" binding:"omitempty,min=0"`
+	FileUID       *string `form:"fileUid" binding:"omitempty"`
+	Path          *string `form:"path" binding:"omitempty,max=1000"`
+	Overwrite     *bool   `form:"overwrite,default=0" binding:"omitempty"`
+	Rename        *bool   `form:"rename,default=0" binding:"omitempty"`
+	Hidden        *bool   `form:"hidden,default=0" binding:"omitempty"`
+	OpenInBrowser bool    `form:"openInBrowser,default=0" binding:"omitempty"`
+	Sign          string  `form:"sign" binding:"required"`
+}
+
+// ParsePresignMiddleware checks the signature of a presigned url by the secret
+// of appUid or token. No token is created or consumed and no nonce is stored,
+// the expiry of the url limits how long it can be replayed.
+func ParsePresignMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var (
+			db        = ctx.MustGet("db").(*gorm.DB)
+			err       error
+			input     = &presignInput{}
+			app       *models.App
+			token     *models.Token
+			secret    string
+			reqRecord = ctx.MustGet("reqRecord").(*models.Request)
+		)
+
+		abort := func(err error, field string) {
+			ctx.AbortWithStatusJSON(400, &Response{
+				RequestID: ctx.GetInt64("requestId"),
+				Success:   false,
+				Errors:    generateErrors(err, field),
+			})
+		}
+
+		if err = shouldBind(ctx, input); err != nil {
+			abort(err, "inputParamError")
+			return
+		}
+
+		switch {
+		case input.AppUID != nil && input.Token == nil:
+			if app, err = models.FindAppByUID(*input.AppUID, db); err != nil {
+				abort(err, "appUid")
+				return
+			}
+			secret = app.Secret
+		case input.Token != nil && input.AppUID == nil:
+			if token, err = models.FindTokenByUID(*input.Token, db); err != nil {
+				abort(err, "token")
+				return
+			}
+			if token.Secret == nil {
+				abort(ErrPresignTokenSecret, "token")
+				return
+			}
+			app, secret = &token.App, *token.Secret
+			reqRecord.Token = &token.UID
+		default:
+			abort(ErrPresignSigner, "appUid")
+			return
+		}
+		reqRecord.AppID = &app.ID
+
+		if !ValidateRequestSignature(ctx, secret) {
+			abort(ErrPresignSign, "sign")
+			return
+		}
+
+		ctx.Set("app", app)
+		if token != nil {
+			ctx.Set("token", token)
+		}
+		ctx.Set("inputParam", input)
+		ctx.Set("presign", &service.Presign{
+			App:      app,
+			Token:    token,
+			Method:   input.Method,
+			Expires:  time.Unix(input.Expires, 0),
+			IP:       input.IP,
+			MaxBytes: input.MaxBytes,
+		})
+		ctx.Next()
+	}
+}
+
+// PresignedReadHandler serves the file of a presigned url as FileReadHandler.
+func PresignedReadHandler(ctx *gin.Context) {
+	var (
+		ip                    = ctx.ClientIP()
+		db                    = ctx.MustGet("db").(*gorm.DB)
+		err                   error
+		file                  *models.File
+		input                 = ctx.MustGet("inputParam").(*presignInput)
+		requestID             = ctx.GetInt64("requestId")
+		presignedReadSrv      *service.PresignedRead
+		presignedReadSrvValue interface{}
+	)
+
+	respondError := func(err error, field string) {
+		ctx.JSON(400, &Response{
+			RequestID: requestID,
+			Success:   false,
+			Errors:    generateErrors(err, field),
+		})
+	}
+
+	if input.FileUID == nil {
+		respondError(ErrPresignWithoutFile, "fileUid")
+		return
+	}
+
+	if file, err = models.FindFileByUID(*input.FileUID, false, db); err != nil {
+		respondError(err, "fileUid")
+		return
+	}
+
+	presignedReadSrv = &service.PresignedRead{
+		BaseService: service.BaseService{DB: db},
+		Presign:     ctx.MustGet("presign").(*service.Presign),
+		File:        file,
+		IP:          &ip,
+		Method:      ctx.Request.Method,
+	}
+
+	if isTesting {
+		presignedReadSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = presignedReadSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		respondError(err, "")
+		return
+	}
+
+	if presignedReadSrvValue, err = presignedReadSrv.Execute(context.Background()); err != nil {
+		respondError(err, "")
+		return
+	}
+
+	serveFile(ctx, presignedReadSrvValue.(io.ReadSeeker), file, input.OpenInBrowser)
+}
+
+// PresignedCreateHandler saves the request body to the path of a presigned
+// url, the body is taken as FileStreamHandler does.
+func PresignedCreateHandler(ctx *gin.Context) {
+	var (
+		ip                      = ctx.ClientIP()
+		db                      = ctx.MustGet("db").(*gorm.DB)
+		err                     error
+		reader                  io.Reader
+		input                   = ctx.MustGet("inputParam").(*presignInput)
+		presignedCreateSrv      *service.PresignedCreate
+		presignedCreateSrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if input.Path == nil {
+		reErrors = generateErrors(ErrPresignWithoutPath, "path")
+		return
+	}
+
+	if reader, err = streamBodyReader(ctx); err != nil {
+		reErrors = generateErrors(err, "file")
+		return
+	}
+
+	presignedCreateSrv = &service.PresignedCreate{
+		BaseService: service.BaseService{DB: db},
+		Presign:     ctx.MustGet("presign").(*service.Presign),
+		Path:        *input.Path,
+		IP:          &ip,
+		Method:      ctx.Request.Method,
+		Reader:      reader,
+	}
+	if input.Hidden != nil && *input.Hidden {
+		presignedCreateSrv.Hidden = 1
+	}
+	if input.Overwrite != nil && *input.Overwrite {
+		presignedCreateSrv.Overwrite = 1
+	}
+	if input.Rename != nil && *input.Rename {
+		presignedCreateSrv.Rename = 1
+	}
+
+	if isTesting {
+		presignedCreateSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = presignedCreateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if presignedCreateSrvValue, err = presignedCreateSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(presignedCreateSrvValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"medea/pkg/database/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRanges is the most ranges served in one response, a Range header with
+// more is ignored.
+const maxRanges = 100
+
+var (
+	ErrWrongRangeHeader   = errors.New("http range header format error")
+	ErrUnsatisfiableRange = errors.New("none of the ranges overlaps the file")
+)
+
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// parseRange parses a Range header of RFC 9110 against size. The ranges which
+// can't be satisfied are left out, ErrUnsatisfiableRange is returned if none
+// is left.
+func parseRange(header string, size int64) ([]httpRange, error) {
+	const unit = "bytes="
+	if !strings.HasPrefix(header, unit) {
+		return nil, ErrWrongRangeHeader
+	}
+
+	var (
+		specs  = strings.Split(header[len(unit):], ",")
+		ranges []httpRange
+		parsed int
+	)
+	if len(specs) > maxRanges {
+		return nil, ErrWrongRangeHeader
+	}
+
+	for _, spec := range specs {
+		if spec = textproto.TrimString(spec); spec == "" {
+			continue
+		}
+		index := strings.Index(spec, "-")
+		if index < 0 {
+			return nil, ErrWrongRangeHeader
+		}
+		first, last := spec[:index], spec[index+1:]
+		parsed++
+
+		if first == "" {
+			// suffix range, the last bytes of the file
+			length, err := parseRangeNumber(last)
+			if err != nil {
+				return nil, err
+			}
+			if length == 0 || size == 0 {
+				continue
+			}
+			if length > size {
+				length = size
+			}
+			ranges = append(ranges, httpRange{start: size - length, length: length})
+			continue
+		}
+
+		start, err := parseRangeNumber(first)
+		if err != nil {
+			return nil, err
+		}
+		end := size - 1
+		if last != "" {
+			if end, err = parseRangeNumber(last); err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, ErrWrongRangeHeader
+			}
+		}
+		if start >= size {
+			continue
+		}
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, httpRange{start: start, length: end - start + 1})
+	}
+
+	if parsed == 0 {
+		return nil, ErrWrongRangeHeader
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseRangeNumber(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrWrongRangeHeader
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrWrongRangeHeader
+	}
+	return n, nil
+}
+
+// matchIfRange tells whether the ranges should be served, If-Range holds
+// either an entity tag, which must match etag strongly, or a date, which
+// must be the exact modification time.
+func matchIfRange(ifRange, etag string, modified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Equal(modified.UTC().Truncate(time.Second))
+}
+
+// readRanges writes ranges of the content of readerSeeker, as one part or as
+// multipart/byteranges for several ranges.
+func readRanges(ctx *gin.Context, readerSeeker io.ReadSeeker, file *models.File, headers map[string]string, ranges []httpRange) {
+	var (
+		size        = int64(file.Size)
+		contentType = headers["Content-Type"]
+	)
+	ctx.Set("ignoreRespBody", true)
+
+	if len(ranges) == 1 {
+		if _, err := readerSeeker.Seek(ranges[0].start, io.SeekStart); err != nil {
+			ctx.JSON(400, &Response{
+				RequestID: ctx.GetInt64("requestId"),
+				Success:   false,
+				Errors:    generateErrors(err, ""),
+			})
+			return
+		}
+		headers["Content-Range"] = ranges[0].contentRange(size)
+		delete(headers, "Content-Length")
+		ctx.DataFromReader(http.StatusPartialContent, ranges[0].length, contentType, io.LimitReader(readerSeeker, ranges[0].length), headers)
+		return
+	}
+
+	var (
+		boundary      = models.RandomWithMD5(128)
+		counter       = &countWriter{}
+		reader, write = io.Pipe()
+		mw            = multipart.NewWriter(counter)
+	)
+
+	// the parts are written to a counter first to know the length of body
+	_ = mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		_, _ = mw.CreatePart(r.mimeHeader(contentType, size))
+		counter.count += r.length
+	}
+	_ = mw.Close()
+
+	go func() {
+		mw := multipart.NewWriter(write)
+		_ = mw.SetBoundary(boundary)
+		for _, r := range ranges {
+			part, err := mw.CreatePart(r.mimeHeader(contentType, size))
+			if err == nil {
+				_, err = readerSeeker.Seek(r.start, io.SeekStart)
+			}
+			if err == nil {
+				_, err = io.CopyN(part, readerSeeker, r.length)
+			}
+			if err != nil {
+				_ = write.CloseWithError(err)
+				return
+			}
+		}
+		_ = write.CloseWithError(mw.Close())
+	}()
+
+	delete(headers, "Content-Length")
+	contentType = "multipart/byteranges; boundary=" + boundary
+	headers["Content-Type"] = contentType
+	ctx.DataFromReader(http.StatusPartialContent, counter.count, contentType, reader, headers)
+	_ = reader.Close()
+}
+
+// rangeNotSatisfiable responds 416 along with the size of file.
+func rangeNotSatisfiable(ctx *gin.Context, file *models.File) {
+	ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", file.Size))
+	ctx.JSON(http.StatusRequestedRangeNotSatisfiable, &Response{
+		RequestID: ctx.GetInt64("requestId"),
+		Success:   false,
+		Errors:    generateErrors(ErrUnsatisfiableRange, "range"),
+	})
+}
+
+// countWriter counts the bytes written to it and discards them.
+type countWriter struct {
+	count int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.count += int64(len(p))
+	return len(p), nil
+}
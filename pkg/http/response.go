@@ -40,6 +40,11 @@ func tokenResp(token *models.Token) map[string]interface{} {
 		"expiredAt":      token.ExpiredAt,
 		"path":           token.Path,
 		"secret":         token.Secret,
+		"quotaBytes":     token.QuotaBytes,
+		"quotaFiles":     token.QuotaFiles,
+		"usedBytes":      token.UsedBytes,
+		"usedFiles":      token.UsedFiles,
+		"rateLimit":      token.RateLimits(),
 	}
 
 	if token.ExpiredAt != nil {
@@ -80,7 +85,13 @@ func fileResp(file *models.File, db *gorm.DB) (map[string]interface{}, error) {
 
 	if file.IsDir == 0 {
 		result["hash"] = file.Object.Hash
+		result["digests"] = file.Object.DigestValues()
 		result["ext"] = file.Ext
+		result["downloadCount"] = file.DownloadCount
+	}
+
+	if file.LastAccessedAt != nil {
+		result["lastAccessedAt"] = file.LastAccessedAt.Unix()
 	}
 
 	if file.DeletedAt != nil {
@@ -89,3 +100,46 @@ func fileResp(file *models.File, db *gorm.DB) (map[string]interface{}, error) {
 
 	return result, err
 }
+
+var uploadSessionStatus = map[int8]string{
+	models.UploadSessionPending:   "pending",
+	models.UploadSessionCompleted: "completed",
+	models.UploadSessionAborted:   "aborted",
+}
+
+func uploadSessionResp(session *models.UploadSession) map[string]interface{} {
+	var result = map[string]interface{}{
+		"uploadId":  session.UID,
+		"path":      session.Path,
+		"hidden":    session.Hidden,
+		"status":    uploadSessionStatus[session.Status],
+		"expiredAt": session.ExpiredAt,
+	}
+
+	if session.ExpiredAt != nil {
+		result["expiredAt"] = session.ExpiredAt.Unix()
+	}
+
+	return result
+}
+
+func uploadPartResp(part *models.UploadPart) map[string]interface{} {
+	return map[string]interface{}{
+		"number": part.Number,
+		"size":   part.Size,
+		"hash":   part.Hash,
+	}
+}
+
+func dailyStatsResp(stats []models.DailyStat) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(stats))
+	for _, stat := range stats {
+		result = append(result, map[string]interface{}{
+			"day":      stat.Day.Format("2006-01-02"),
+			"requests": stat.Requests,
+			"bytesIn":  stat.BytesIn,
+			"bytesOut": stat.BytesOut,
+		})
+	}
+	return result
+}
@@ -39,7 +39,7 @@ func Routers() *gin.Engine {
 		}))
 	}
 
-	r.Use(ConfigContextMiddleware(nil), RecordRequestMiddleware())
+	r.Use(ConfigContextMiddleware(nil), RecordRequestMiddleware(), StatsMiddleware(), ThrottleMiddleware())
 
 	if config.DefaultConfig.HTTP.LimitRateByIPEnable {
 		interval := time.Duration(config.DefaultConfig.HTTP.LimitRateByIPInterval * int64(time.Millisecond))
@@ -55,10 +55,32 @@ func Routers() *gin.Engine {
 	requestWithTokenGroup := r.Group("", ParseTokenMiddleware(), ReplayAttackMiddleware())
 	requestWithTokenGroup.POST(brw("/file/create"), SignWithTokenMiddleware(&fileCreateInput{}), FileCreateHandler)
 	requestWithTokenGroup.GET(brw("/file/read"), SignWithTokenMiddleware(&fileReadInput{}), FileReadHandler)
+	requestWithTokenGroup.HEAD(brw("/file/read"), SignWithTokenMiddleware(&fileReadInput{}), FileReadHandler)
 	requestWithTokenGroup.GET(brw("/file/info"), SignWithTokenMiddleware(&fileReadInput{}), FileInfoHandler)
 	requestWithTokenGroup.PATCH(brw("/file/update"), SignWithTokenMiddleware(&fileUpdateInput{}), FileUpdateHandler)
+	requestWithTokenGroup.POST(brw("/file/copy"), SignWithTokenMiddleware(&fileCopyInput{}), FileCopyHandler)
+	requestWithTokenGroup.POST(brw("/file/compose"), SignWithTokenMiddleware(&fileComposeInput{}), FileComposeHandler)
+	requestWithTokenGroup.POST(brw("/file/truncate"), SignWithTokenMiddleware(&fileTruncateInput{}), FileTruncateHandler)
 	requestWithTokenGroup.DELETE(brw("/file/delete"), SignWithTokenMiddleware(&fileDeleteInput{}), FileDeleteHandler)
 	requestWithTokenGroup.GET(brw("/directory/list"), SignWithTokenMiddleware(&directoryListInput{}), DirectoryListHandler)
+	requestWithTokenGroup.GET(brw("/directory/archive"), SignWithTokenMiddleware(&directoryArchiveInput{}), DirectoryArchiveHandler)
+	requestWithTokenGroup.POST(brw("/upload/initiate"), SignWithTokenMiddleware(&uploadInitiateInput{}), UploadInitiateHandler)
+	requestWithTokenGroup.POST(brw("/upload/part"), SignWithTokenMiddleware(&uploadPartInput{}), UploadPartHandler)
+	requestWithTokenGroup.POST(brw("/upload/negotiate"), SignWithTokenMiddleware(&uploadNegotiateInput{}), UploadNegotiateHandler)
+	requestWithTokenGroup.GET(brw("/upload/parts"), SignWithTokenMiddleware(&uploadSessionInput{}), UploadPartListHandler)
+	requestWithTokenGroup.POST(brw("/upload/complete"), SignWithTokenMiddleware(&uploadSessionInput{}), UploadCompleteHandler)
+	requestWithTokenGroup.DELETE(brw("/upload/abort"), SignWithTokenMiddleware(&uploadSessionInput{}), UploadAbortHandler)
+	requestWithTokenGroup.GET(brw("/usage"), SignWithTokenMiddleware(&usageInput{}), UsageHandler)
+	requestWithTokenGroup.GET(brw("/stats"), SignWithTokenMiddleware(&statsInput{}), StatsHandler)
+
+	requestWithStreamGroup := r.Group("", QueryFormMiddleware(), ParseTokenMiddleware(), ReplayAttackMiddleware())
+	requestWithStreamGroup.PUT(brw("/file/create"), SignWithTokenMiddleware(&fileCreateInput{}), FileStreamHandler)
+	requestWithStreamGroup.PUT(brw("/file/write"), SignWithTokenMiddleware(&fileWriteInput{}), FileWriteHandler)
+
+	requestWithPresignGroup := r.Group("", QueryFormMiddleware(), ParsePresignMiddleware())
+	requestWithPresignGroup.GET(brw("/presigned/file"), PresignedReadHandler)
+	requestWithPresignGroup.HEAD(brw("/presigned/file"), PresignedReadHandler)
+	requestWithPresignGroup.PUT(brw("/presigned/file"), PresignedCreateHandler)
 
 	return r
 }
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"time"
+
+	"medea/pkg/database/models"
+	"medea/pkg/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// StatsMiddleware counts the request and the bytes of its body and response
+// into the daily stats of its app and token, if any of them is known once the
+// request is handled.
+func StatsMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		body := &countingReader{ReadCloser: ctx.Request.Body}
+		if ctx.Request.Body != nil {
+			ctx.Request.Body = body
+		}
+		ctx.Next()
+
+		var (
+			appID, tokenID uint64
+			bytesOut       = int64(ctx.Writer.Size())
+		)
+		if value, ok := ctx.Get("token"); ok {
+			token := value.(*models.Token)
+			appID, tokenID = token.AppID, token.ID
+		}
+		if value, ok := ctx.Get("app"); ok {
+			appID = value.(*models.App).ID
+		}
+		if appID == 0 {
+			return
+		}
+		if bytesOut < 0 {
+			bytesOut = 0
+		}
+		models.RecordRequest(appID, tokenID, body.n, bytesOut)
+	}
+}
+
+type statsInput struct {
+	Token string     `form:"token" binding:"required"`
+	Nonce string     `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	From  *time.Time `form:"from" time_format:"2006-01-02" binding:"omitempty"`
+	To    *time.Time `form:"to" time_format:"2006-01-02" binding:"omitempty"`
+	Sign  *string    `form:"sign" binding:"omitempty"`
+}
+
+func StatsHandler(ctx *gin.Context) {
+	var (
+		ip            = ctx.ClientIP()
+		db            = ctx.MustGet("db").(*gorm.DB)
+		err           error
+		token         = ctx.MustGet("token").(*models.Token)
+		input         = ctx.MustGet("inputParam").(*statsInput)
+		statsSrv      *service.Stats
+		statsSrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	statsSrv = &service.Stats{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		IP:          &ip,
+		To:          time.Now(),
+	}
+	if input.To != nil {
+		statsSrv.To = *input.To
+	}
+	// the last 7 days until to by default
+	statsSrv.From = statsSrv.To.AddDate(0, 0, -6)
+	if input.From != nil {
+		statsSrv.From = *input.From
+	}
+
+	if err = statsSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if statsSrvValue, err = statsSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	stats := statsSrvValue.(*service.StatsResponse)
+	result := map[string]interface{}{
+		"app":   nil,
+		"token": dailyStatsResp(stats.Token),
+	}
+	if stats.App != nil {
+		result["app"] = dailyStatsResp(stats.App)
+	}
+	data = result
+	success = true
+	code = 200
+}
@@ -0,0 +1,228 @@
+package http
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/database/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// maxThrottlePiece is the most bytes waited for at once, so that a large
+// write is spread over time instead of waiting long before it.
+const maxThrottlePiece = 32 * 1024
+
+// limiterIdleTimeout is how long a limiter which isn't used by any request is
+// kept, idle limiters are swept at most once in it.
+const limiterIdleTimeout = 10 * time.Minute
+
+// limiterRefreshInterval is how often a request looks up its limiters again,
+// as the rates change along with schedules.
+const limiterRefreshInterval = time.Second
+
+type limiterKey struct {
+	write bool
+	owner string
+	id    uint64
+}
+
+type byteLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed int64
+}
+
+// byteLimiters are the token buckets of bytes by direction and owner, shared
+// by all requests of the owner.
+var (
+	byteLimiters    sync.Map
+	limitersSweptAt = time.Now().UnixNano()
+)
+
+func byteLimiter(key limiterKey, bytesPerSecond int64, now time.Time) *rate.Limiter {
+	var (
+		limit = rate.Limit(bytesPerSecond)
+		burst = int(bytesPerSecond)
+	)
+	if burst > maxThrottlePiece {
+		burst = maxThrottlePiece
+	}
+	sweepByteLimiters(now)
+
+	value, _ := byteLimiters.LoadOrStore(key, &byteLimiterEntry{limiter: rate.NewLimiter(limit, burst), lastUsed: now.UnixNano()})
+	entry := value.(*byteLimiterEntry)
+	// the rate changes along with schedules and updates of the owner, the
+	// burst along with it, which can only be set by a new limiter
+	if entry.limiter.Limit() != limit {
+		entry = &byteLimiterEntry{limiter: rate.NewLimiter(limit, burst), lastUsed: now.UnixNano()}
+		byteLimiters.Store(key, entry)
+	}
+	atomic.StoreInt64(&entry.lastUsed, now.UnixNano())
+	return entry.limiter
+}
+
+// sweepByteLimiters deletes the limiters idle for limiterIdleTimeout, a
+// request still holding one looks it up again on its next refresh.
+func sweepByteLimiters(now time.Time) {
+	var (
+		sweptAt = atomic.LoadInt64(&limitersSweptAt)
+		idle    = now.Add(-limiterIdleTimeout).UnixNano()
+	)
+	if sweptAt > idle || !atomic.CompareAndSwapInt64(&limitersSweptAt, sweptAt, now.UnixNano()) {
+		return
+	}
+	byteLimiters.Range(func(key, value interface{}) bool {
+		if atomic.LoadInt64(&value.(*byteLimiterEntry).lastUsed) < idle {
+			byteLimiters.Delete(key)
+		}
+		return true
+	})
+}
+
+// throttle limits the bytes of a request in one direction by the global rate
+// limit, and by the ones of the app and the token once they are parsed, as
+// the token may be in the body being throttled.
+type throttle struct {
+	ctx   *gin.Context
+	write bool
+
+	app, token     *config.RateLimit
+	appID, tokenID uint64
+
+	cached    []*rate.Limiter
+	refreshAt time.Time
+}
+
+// resolve reports whether the app or the token is newly known.
+func (t *throttle) resolve() (resolved bool) {
+	if t.app == nil {
+		if value, ok := t.ctx.Get("app"); ok {
+			app := value.(*models.App)
+			t.app, t.appID, resolved = app.RateLimits(), app.ID, true
+		}
+	}
+	if t.token == nil {
+		if value, ok := t.ctx.Get("token"); ok {
+			token := value.(*models.Token)
+			t.token, t.tokenID, resolved = token.RateLimits(), token.ID, true
+		}
+	}
+	return resolved
+}
+
+// limiters returns the limiters which apply now, they are cached until the
+// app or the token is known or limiterRefreshInterval passes.
+func (t *throttle) limiters() []*rate.Limiter {
+	var (
+		now      = time.Now()
+		resolved = (t.app == nil || t.token == nil) && t.resolve()
+	)
+	if !resolved && now.Before(t.refreshAt) {
+		return t.cached
+	}
+
+	t.cached, t.refreshAt = t.cached[:0], now.Add(limiterRefreshInterval)
+	add := func(owner string, id uint64, limit *config.RateLimit) {
+		if limit == nil {
+			return
+		}
+		read, write := limit.Rates(now)
+		if t.write {
+			read = write
+		}
+		if read > 0 {
+			key := limiterKey{write: t.write, owner: owner, id: id}
+			t.cached = append(t.cached, byteLimiter(key, read, now))
+		}
+	}
+
+	add("global", 0, &config.DefaultConfig.HTTP.RateLimit)
+	add("app", t.appID, t.app)
+	add("token", t.tokenID, t.token)
+	return t.cached
+}
+
+// wait waits until n bytes are allowed by all limiters, n is at most the
+// burst of any of them.
+func (t *throttle) wait(limiters []*rate.Limiter, n int) error {
+	for _, limiter := range limiters {
+		if err := limiter.WaitN(t.ctx.Request.Context(), n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pieceSize(limiters []*rate.Limiter, n int) int {
+	for _, limiter := range limiters {
+		if burst := limiter.Burst(); n > burst {
+			n = burst
+		}
+	}
+	return n
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	*throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	limiters := r.limiters()
+	if len(limiters) > 0 {
+		p = p[:pieceSize(limiters, len(p))]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && len(limiters) > 0 {
+		if waitErr := r.wait(limiters, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	gin.ResponseWriter
+	*throttle
+}
+
+func (w *throttledWriter) Write(p []byte) (n int, err error) {
+	limiters := w.limiters()
+	if len(limiters) == 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	for len(p) > 0 {
+		piece := p[:pieceSize(limiters, len(p))]
+		if err = w.wait(limiters, len(piece)); err != nil {
+			return n, err
+		}
+		written, err := w.ResponseWriter.Write(piece)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+	}
+	return n, nil
+}
+
+func (w *throttledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// ThrottleMiddleware limits the bytes per second of request bodies by the
+// write rates and of responses by the read rates, of config.HTTP, the app and
+// the token of the request.
+func ThrottleMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.Body != nil {
+			ctx.Request.Body = &throttledReader{ReadCloser: ctx.Request.Body, throttle: &throttle{ctx: ctx, write: true}}
+		}
+		ctx.Writer = &throttledWriter{ResponseWriter: ctx.Writer, throttle: &throttle{ctx: ctx}}
+		ctx.Next()
+	}
+}
@@ -21,6 +21,9 @@ type tokenCreateInput struct {
 	Secret         *string    `form:"secret" binding:"omitempty,min=12,max=32"`
 	AvailableTimes *int       `form:"availableTimes,default=-1" binding:"omitempty,max=2147483647"`
 	ReadOnly       *bool      `form:"readOnly,default=0"`
+	QuotaBytes     int64      `form:"quotaBytes" binding:"omitempty,gte=0"`
+	QuotaFiles     int64      `form:"quotaFiles" binding:"omitempty,gte=0"`
+	RateLimit      *string    `form:"rateLimit" binding:"omitempty,max=10000"`
 }
 
 func TokenCreateHandler(ctx *gin.Context) {
@@ -67,6 +70,9 @@ func TokenCreateHandler(ctx *gin.Context) {
 		ReadOnly:       readOnlyI8,
 		ExpiredAt:      input.ExpiredAt,
 		AvailableTimes: *input.AvailableTimes,
+		QuotaBytes:     input.QuotaBytes,
+		QuotaFiles:     input.QuotaFiles,
+		RateLimit:      input.RateLimit,
 	}
 
 	if err := tokenCreateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
@@ -95,6 +101,9 @@ type tokenUpdateInput struct {
 	Secret         *string    `form:"secret" binding:"omitempty,min=12,max=32"`
 	AvailableTimes *int       `form:"availableTimes" binding:"omitempty,max=2147483647"`
 	ReadOnly       *bool      `form:"readOnly"`
+	QuotaBytes     *int64     `form:"quotaBytes" binding:"omitempty,gte=0"`
+	QuotaFiles     *int64     `form:"quotaFiles" binding:"omitempty,gte=0"`
+	RateLimit      *string    `form:"rateLimit" binding:"omitempty,max=10000"`
 }
 
 func TokenUpdateHandler(ctx *gin.Context) {
@@ -139,6 +148,9 @@ func TokenUpdateHandler(ctx *gin.Context) {
 		ExpiredAt:      input.ExpiredAt,
 		AvailableTimes: input.AvailableTimes,
 		ReadOnly:       &readOnlyI8,
+		QuotaBytes:     input.QuotaBytes,
+		QuotaFiles:     input.QuotaFiles,
+		RateLimit:      input.RateLimit,
 	}
 
 	if err = tokenUpdateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
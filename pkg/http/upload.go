@@ -0,0 +1,460 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"medea/pkg/database/models"
+	"medea/pkg/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+type uploadInitiateInput struct {
+	Token     string  `form:"token" binding:"required"`
+	Nonce     string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	Path      string  `form:"path" binding:"required,max=1000"`
+	Sign      *string `form:"sign" binding:"omitempty"`
+	Overwrite *bool   `form:"overwrite,default=0" binding:"omitempty"`
+	Rename    *bool   `form:"rename,default=0" binding:"omitempty"`
+	Hidden    *bool   `form:"hidden,default=0" binding:"omitempty"`
+}
+
+type uploadPartInput struct {
+	Token    string  `form:"token" binding:"required"`
+	Nonce    string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	Sign     *string `form:"sign" binding:"omitempty"`
+	UploadID string  `form:"uploadId" binding:"required"`
+	Number   int     `form:"number" binding:"required,min=1"`
+	Hash     *string `form:"hash" binding:"omitempty"`
+	Size     *int    `form:"size" binding:"omitempty"`
+}
+
+type uploadSessionInput struct {
+	Token    string  `form:"token" binding:"required"`
+	Nonce    string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	Sign     *string `form:"sign" binding:"omitempty"`
+	UploadID string  `form:"uploadId" binding:"required"`
+}
+
+type uploadNegotiateInput struct {
+	Token    string  `form:"token" binding:"required"`
+	Nonce    string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	Sign     *string `form:"sign" binding:"omitempty"`
+	UploadID string  `form:"uploadId" binding:"required"`
+	Hashes   string  `form:"hashes" binding:"required"`
+	Sizes    string  `form:"sizes" binding:"required"`
+}
+
+func UploadInitiateHandler(ctx *gin.Context) {
+	var (
+		err                 error
+		ip                  = ctx.ClientIP()
+		db                  = ctx.MustGet("db").(*gorm.DB)
+		input               = ctx.MustGet("inputParam").(*uploadInitiateInput)
+		uploadInitiateSrv   *service.UploadInitiate
+		uploadInitiateValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	uploadInitiateSrv = &service.UploadInitiate{
+		BaseService: service.BaseService{DB: db},
+		Token:       ctx.MustGet("token").(*models.Token),
+		IP:          &ip,
+		Path:        input.Path,
+	}
+
+	if input.Hidden != nil && *input.Hidden {
+		uploadInitiateSrv.Hidden = 1
+	}
+	if input.Overwrite != nil && *input.Overwrite {
+		uploadInitiateSrv.Overwrite = 1
+	}
+	if input.Rename != nil && *input.Rename {
+		uploadInitiateSrv.Rename = 1
+	}
+
+	if err = uploadInitiateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if uploadInitiateValue, err = uploadInitiateSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	data = uploadSessionResp(uploadInitiateValue.(*models.UploadSession))
+	code = 200
+	success = true
+}
+
+func UploadPartHandler(ctx *gin.Context) {
+	var (
+		fh      *multipart.FileHeader
+		err     error
+		buf     = bytes.NewBuffer(nil)
+		reader  io.ReadCloser
+		session *models.UploadSession
+
+		ip                = ctx.ClientIP()
+		db                = ctx.MustGet("db").(*gorm.DB)
+		input             = ctx.MustGet("inputParam").(*uploadPartInput)
+		uploadPartPutSrv  *service.UploadPartPut
+		uploadPartPutItem interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if session, err = models.FindUploadSessionByUID(input.UploadID, db); err != nil {
+		reErrors = generateErrors(err, "uploadId")
+		return
+	}
+
+	if fh, err = ctx.FormFile("file"); err != nil {
+		reErrors = generateErrors(err, "file")
+		return
+	}
+
+	if reader, err = fh.Open(); err != nil {
+		reErrors = generateErrors(err, "file")
+		return
+	}
+	defer reader.Close()
+
+	if _, err = io.Copy(buf, reader); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if buf.Len() > models.ChunkSize {
+		reErrors = generateErrors(models.ErrChunkExceedLimit, "file")
+		return
+	}
+
+	if reErrors = validateHashAndSize(input.Hash, input.Size, buf); reErrors != nil {
+		return
+	}
+
+	uploadPartPutSrv = &service.UploadPartPut{
+		BaseService: service.BaseService{DB: db},
+		Token:       ctx.MustGet("token").(*models.Token),
+		Session:     session,
+		IP:          &ip,
+		Number:      input.Number,
+		Content:     buf.Bytes(),
+	}
+
+	if isTesting {
+		uploadPartPutSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = uploadPartPutSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if uploadPartPutItem, err = uploadPartPutSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	data = uploadPartResp(uploadPartPutItem.(*models.UploadPart))
+	code = 200
+	success = true
+}
+
+func UploadPartListHandler(ctx *gin.Context) {
+	var (
+		err     error
+		session *models.UploadSession
+
+		ip                 = ctx.ClientIP()
+		db                 = ctx.MustGet("db").(*gorm.DB)
+		input              = ctx.MustGet("inputParam").(*uploadSessionInput)
+		uploadPartListSrv  *service.UploadPartList
+		uploadPartListItem interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if session, err = models.FindUploadSessionByUID(input.UploadID, db); err != nil {
+		reErrors = generateErrors(err, "uploadId")
+		return
+	}
+
+	uploadPartListSrv = &service.UploadPartList{
+		BaseService: service.BaseService{DB: db},
+		Token:       ctx.MustGet("token").(*models.Token),
+		Session:     session,
+		IP:          &ip,
+	}
+
+	if err = uploadPartListSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if uploadPartListItem, err = uploadPartListSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	parts := uploadPartListItem.([]models.UploadPart)
+	items := make([]map[string]interface{}, len(parts))
+	for index := range parts {
+		items[index] = uploadPartResp(&parts[index])
+	}
+
+	result := uploadSessionResp(session)
+	result["parts"] = items
+	data = result
+	code = 200
+	success = true
+}
+
+// parseChunkDigests parses the comma separated hashes and sizes of chunks.
+func parseChunkDigests(hashes, sizes string) ([]models.ChunkDigest, error) {
+	var (
+		hashList = strings.Split(hashes, ",")
+		sizeList = strings.Split(sizes, ",")
+		digests  = make([]models.ChunkDigest, len(hashList))
+	)
+	if len(hashList) != len(sizeList) {
+		return nil, errors.New("the numbers of hashes and sizes don't match")
+	}
+	for index := range hashList {
+		size, err := strconv.Atoi(strings.TrimSpace(sizeList[index]))
+		if err != nil {
+			return nil, err
+		}
+		digests[index] = models.ChunkDigest{Hash: strings.ToLower(strings.TrimSpace(hashList[index])), Size: size}
+	}
+	return digests, nil
+}
+
+func UploadNegotiateHandler(ctx *gin.Context) {
+	var (
+		err     error
+		session *models.UploadSession
+		digests []models.ChunkDigest
+
+		ip                   = ctx.ClientIP()
+		db                   = ctx.MustGet("db").(*gorm.DB)
+		input                = ctx.MustGet("inputParam").(*uploadNegotiateInput)
+		uploadNegotiateSrv   *service.UploadNegotiate
+		uploadNegotiateValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if session, err = models.FindUploadSessionByUID(input.UploadID, db); err != nil {
+		reErrors = generateErrors(err, "uploadId")
+		return
+	}
+
+	if digests, err = parseChunkDigests(input.Hashes, input.Sizes); err != nil {
+		reErrors = generateErrors(err, "sizes")
+		return
+	}
+
+	uploadNegotiateSrv = &service.UploadNegotiate{
+		BaseService: service.BaseService{DB: db},
+		Token:       ctx.MustGet("token").(*models.Token),
+		Session:     session,
+		IP:          &ip,
+		Digests:     digests,
+	}
+
+	if err = uploadNegotiateSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if uploadNegotiateValue, err = uploadNegotiateSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	missing := uploadNegotiateValue.([]int)
+	if missing == nil {
+		missing = []int{}
+	}
+	data = map[string]interface{}{
+		"uploadId": session.UID,
+		"parts":    len(digests),
+		"missing":  missing,
+	}
+	code = 200
+	success = true
+}
+
+func UploadCompleteHandler(ctx *gin.Context) {
+	var (
+		err     error
+		session *models.UploadSession
+
+		ip                  = ctx.ClientIP()
+		db                  = ctx.MustGet("db").(*gorm.DB)
+		input               = ctx.MustGet("inputParam").(*uploadSessionInput)
+		uploadCompleteSrv   *service.UploadComplete
+		uploadCompleteValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if session, err = models.FindUploadSessionByUID(input.UploadID, db); err != nil {
+		reErrors = generateErrors(err, "uploadId")
+		return
+	}
+
+	uploadCompleteSrv = &service.UploadComplete{
+		BaseService: service.BaseService{DB: db},
+		Token:       ctx.MustGet("token").(*models.Token),
+		Session:     session,
+		IP:          &ip,
+	}
+
+	if isTesting {
+		uploadCompleteSrv.RootPath = testingChunkRootPath
+	}
+
+	if err = uploadCompleteSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if uploadCompleteValue, err = uploadCompleteSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if data, err = fileResp(uploadCompleteValue.(*models.File), db); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	code = 200
+	success = true
+}
+
+func UploadAbortHandler(ctx *gin.Context) {
+	var (
+		err     error
+		session *models.UploadSession
+
+		ip               = ctx.ClientIP()
+		db               = ctx.MustGet("db").(*gorm.DB)
+		input            = ctx.MustGet("inputParam").(*uploadSessionInput)
+		uploadAbortSrv   *service.UploadAbort
+		uploadAbortValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	if session, err = models.FindUploadSessionByUID(input.UploadID, db); err != nil {
+		reErrors = generateErrors(err, "uploadId")
+		return
+	}
+
+	uploadAbortSrv = &service.UploadAbort{
+		BaseService: service.BaseService{DB: db},
+		Token:       ctx.MustGet("token").(*models.Token),
+		Session:     session,
+		IP:          &ip,
+	}
+
+	if err = uploadAbortSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if uploadAbortValue, err = uploadAbortSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	data = uploadSessionResp(uploadAbortValue.(*models.UploadSession))
+	code = 200
+	success = true
+}
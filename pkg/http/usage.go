@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"reflect"
+
+	"medea/pkg/database/models"
+	"medea/pkg/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+type usageInput struct {
+	Token string  `form:"token" binding:"required"`
+	Nonce string  `form:"nonce" header:"X-Request-Nonce" binding:"required,min=32,max=48"`
+	Sign  *string `form:"sign" binding:"omitempty"`
+}
+
+func UsageHandler(ctx *gin.Context) {
+	var (
+		ip            = ctx.ClientIP()
+		db            = ctx.MustGet("db").(*gorm.DB)
+		err           error
+		token         = ctx.MustGet("token").(*models.Token)
+		usageSrv      *service.Usage
+		usageSrvValue interface{}
+
+		code     = 400
+		reErrors map[string][]string
+		success  bool
+		data     interface{}
+	)
+
+	defer func() {
+		ctx.JSON(code, &Response{
+			RequestID: ctx.GetInt64("requestId"),
+			Success:   success,
+			Errors:    reErrors,
+			Data:      data,
+		})
+	}()
+
+	usageSrv = &service.Usage{
+		BaseService: service.BaseService{DB: db},
+		Token:       token,
+		IP:          &ip,
+	}
+
+	if err = usageSrv.Validate(); !reflect.ValueOf(err).IsNil() {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	if usageSrvValue, err = usageSrv.Execute(context.Background()); err != nil {
+		reErrors = generateErrors(err, "")
+		return
+	}
+
+	usage := usageSrvValue.(*service.UsageResponse)
+	data = map[string]interface{}{
+		"app":   usage.App,
+		"token": usage.Token,
+	}
+	success = true
+	code = 200
+}
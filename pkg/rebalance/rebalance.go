@@ -0,0 +1,239 @@
+package rebalance
+
+import (
+	"errors"
+	"fmt"
+
+	"medea/pkg/database/models"
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrUnknownRoot = errors.New("unknown chunk root")
+
+type Options struct {
+	BatchSize int
+	DryRun    bool
+	Drain     []string // roots to be drained besides the configured ones
+	RootPath  *string
+}
+
+type Failure struct {
+	ChunkID uint64
+	Detail  string
+}
+
+type Report struct {
+	DryRun       bool
+	Chunks       int64
+	Moved        int64
+	Copies       int64
+	CopiedBytes  int64
+	Removed      int64
+	RemovedBytes int64
+	Failures     []Failure
+}
+
+type rebalancer struct {
+	db       *gorm.DB
+	opts     Options
+	roots    []*storage.Root
+	replicas int
+	report   *Report
+}
+
+// Run moves the replicas of every chunk to the roots of its tier chosen by
+// placement, a missing replica is copied again from a good one. Copies are made and
+// recorded before the replicas out of place are removed.
+func Run(db *gorm.DB, opts Options) (report *Report, err error) {
+	var roots []*storage.Root
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if roots, err = models.ChunkRoots(opts.RootPath); err != nil {
+		return nil, err
+	}
+
+	r := &rebalancer{
+		db:       db,
+		opts:     opts,
+		replicas: models.ChunkReplicas(),
+		report:   &Report{DryRun: opts.DryRun},
+	}
+	for _, root := range roots {
+		copied := *root
+		r.roots = append(r.roots, &copied)
+	}
+	for _, name := range opts.Drain {
+		root := storage.FindRoot(r.roots, name)
+		if root == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRoot, name)
+		}
+		root.Weight = 0
+	}
+
+	var lastID uint64
+	for {
+		var chunks []models.Chunk
+		if err = db.Where("id > ?", lastID).Order("id").Limit(opts.BatchSize).Find(&chunks).Error; err != nil {
+			return r.report, err
+		}
+		if len(chunks) == 0 {
+			return r.report, nil
+		}
+		lastID = chunks[len(chunks)-1].ID
+		for index := range chunks {
+			if err = r.rebalanceChunk(&chunks[index]); err != nil {
+				return r.report, err
+			}
+		}
+	}
+}
+
+func (r *rebalancer) fail(chunk *models.Chunk, format string, args ...interface{}) {
+	r.report.Failures = append(r.report.Failures, Failure{ChunkID: chunk.ID, Detail: fmt.Sprintf(format, args...)})
+}
+
+// currentRoots returns the roots which have a replica of chunk, a recorded
+// replica whose file is missing doesn't count.
+func (r *rebalancer) currentRoots(chunk *models.Chunk, key string, placements []models.ChunkPlacement) ([]*storage.Root, error) {
+	var current []*storage.Root
+	for _, root := range models.PlacedRoots(placements, r.roots) {
+		if _, err := root.Store.Stat(key); err != nil {
+			if err == storage.ErrNotExist {
+				continue
+			}
+			return nil, err
+		}
+		current = append(current, root)
+	}
+	return current, nil
+}
+
+func (r *rebalancer) rebalanceChunk(chunk *models.Chunk) error {
+	var (
+		key        string
+		placements []models.ChunkPlacement
+		current    []*storage.Root
+		targets    []*storage.Root
+		need       []*storage.Root
+		extra      []*storage.Root
+		recorded   = make(map[string]bool)
+		err        error
+	)
+
+	if chunk.IsPacked() {
+		// packs stay on the roots they are created on, compaction moves them
+		return nil
+	}
+	r.report.Chunks++
+	if key, err = chunk.Key(); err != nil {
+		r.fail(chunk, "%s", err)
+		return nil
+	}
+	if placements, err = chunk.Placements(r.db); err != nil {
+		return err
+	}
+	for _, placement := range placements {
+		recorded[placement.Root] = true
+	}
+	if current, err = r.currentRoots(chunk, key, placements); err != nil {
+		r.fail(chunk, "%s", err)
+		return nil
+	}
+	// chunks are kept in their tier, which is moved by tiering only
+	tier := storage.TierHot
+	if storage.IsCold(models.PlacedRoots(placements, r.roots)) {
+		tier = storage.TierCold
+	}
+	if targets, err = storage.Place(key, storage.TierRoots(r.roots, tier), r.replicas); err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if !containsRoot(current, target) {
+			need = append(need, target)
+		}
+	}
+	for _, root := range current {
+		if !containsRoot(targets, root) {
+			extra = append(extra, root)
+		}
+	}
+	for name := range recorded {
+		if root := storage.FindRoot(r.roots, name); root != nil && !containsRoot(current, root) && !containsRoot(need, root) {
+			// the placement of a lost replica out of place is dropped only
+			extra = append(extra, root)
+		}
+	}
+	if len(need) > 0 || len(extra) > 0 {
+		r.report.Moved++
+	}
+
+	if r.opts.DryRun {
+		r.report.Copies += int64(len(need))
+		r.report.CopiedBytes += int64(len(need) * chunk.StoredSize)
+		for _, root := range extra {
+			if containsRoot(current, root) {
+				r.report.Removed++
+				r.report.RemovedBytes += int64(chunk.StoredSize)
+			}
+		}
+		return nil
+	}
+
+	if len(need) > 0 {
+		stored, _, err := chunk.GoodReplica(current, r.db)
+		if err != nil {
+			r.fail(chunk, "no good replica to copy: %s", err)
+			return nil
+		}
+		for _, root := range need {
+			if err = root.Store.Put(key, stored); err != nil {
+				r.fail(chunk, "failed to copy to %s: %s", root.Name, err)
+				return nil
+			}
+			if err = models.AddChunkPlacement(chunk.ID, root.Name, r.db); err != nil {
+				return err
+			}
+			r.report.Copies++
+			r.report.CopiedBytes += int64(len(stored))
+		}
+	}
+
+	// chunks stored before placements were recorded get their placements
+	for _, root := range current {
+		if containsRoot(targets, root) && !recorded[root.Name] {
+			if err = models.AddChunkPlacement(chunk.ID, root.Name, r.db); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, root := range extra {
+		if err = models.RemoveChunkPlacement(chunk.ID, root.Name, r.db); err != nil {
+			return err
+		}
+		if !containsRoot(current, root) {
+			continue
+		}
+		if err = root.Store.Delete(key); err != nil {
+			r.fail(chunk, "failed to remove from %s: %s", root.Name, err)
+			continue
+		}
+		r.report.Removed++
+		r.report.RemovedBytes += int64(chunk.StoredSize)
+	}
+	return nil
+}
+
+func containsRoot(roots []*storage.Root, root *storage.Root) bool {
+	for _, r := range roots {
+		if r.Name == root.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package refcount
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type Options struct {
+	BatchSize int
+	DryRun    bool
+}
+
+type Report struct {
+	DryRun       bool
+	Objects      int64
+	FixedObjects int64
+	Chunks       int64
+	FixedChunks  int64
+}
+
+type source struct {
+	table  string
+	column string
+}
+
+// counted is a table whose reference counts are the rows of sources referring
+// to it.
+type counted struct {
+	table   string
+	sources []source
+}
+
+var (
+	objectRefs = counted{table: "objects", sources: []source{{"files", "objectId"}, {"histories", "objectId"}}}
+	chunkRefs  = counted{table: "chunks", sources: []source{{"object_chunk", "chunkId"}, {"upload_parts", "chunkId"}}}
+)
+
+type row struct {
+	ID       uint64
+	RefCount int `gorm:"column:refCount"`
+}
+
+type refs struct {
+	RefID uint64 `gorm:"column:refId"`
+	Count int    `gorm:"column:count"`
+}
+
+// Repair recomputes the reference counts of all objects and chunks from the
+// rows referring to them, and corrects the ones which don't match. Each batch
+// is locked while it is recomputed, so it can run along with uploads.
+func Repair(db *gorm.DB, opts Options) (report *Report, err error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	report = &Report{DryRun: opts.DryRun}
+	if err = repairTable(db, objectRefs, opts, &report.Objects, &report.FixedObjects); err != nil {
+		return report, err
+	}
+	return report, repairTable(db, chunkRefs, opts, &report.Chunks, &report.FixedChunks)
+}
+
+func repairTable(db *gorm.DB, c counted, opts Options, checked, fixed *int64) error {
+	var lastID uint64
+	for {
+		var (
+			rows []row
+			err  error
+		)
+		if lastID, rows, err = repairBatch(db, c, lastID, opts, fixed); err != nil || len(rows) == 0 {
+			return err
+		}
+		*checked += int64(len(rows))
+	}
+}
+
+func repairBatch(db *gorm.DB, c counted, lastID uint64, opts Options, fixed *int64) (nextID uint64, rows []row, err error) {
+	var (
+		tx     = db
+		lock   = ""
+		ids    []uint64
+		actual = make(map[uint64]int)
+	)
+
+	if !opts.DryRun {
+		if tx = db.Begin(); tx.Error != nil {
+			return lastID, nil, tx.Error
+		}
+		lock = " FOR UPDATE"
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit().Error
+			}
+		}()
+	}
+
+	// the rows are locked before counting, so the counts read include every
+	// reference committed before
+	err = tx.Raw("SELECT id, refCount FROM "+c.table+" WHERE id > ? ORDER BY id LIMIT ?"+lock, lastID, opts.BatchSize).
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return lastID, nil, err
+	}
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+	}
+	for _, s := range c.sources {
+		var counts []refs
+		err = tx.Raw(
+			"SELECT "+s.column+" AS refId, COUNT(*) AS count FROM "+s.table+" WHERE "+s.column+" IN (?) GROUP BY "+s.column, ids,
+		).Scan(&counts).Error
+		if err != nil {
+			return lastID, nil, err
+		}
+		for _, count := range counts {
+			actual[count.RefID] += count.Count
+		}
+	}
+
+	for _, r := range rows {
+		if r.RefCount == actual[r.ID] {
+			continue
+		}
+		*fixed++
+		if opts.DryRun {
+			continue
+		}
+		err = tx.Table(c.table).Where("id = ?", r.ID).UpdateColumns(map[string]interface{}{
+			"refCount":  actual[r.ID],
+			"updatedAt": gorm.Expr("updatedAt"),
+		}).Error
+		if err != nil {
+			return lastID, nil, err
+		}
+	}
+	return rows[len(rows)-1].ID, rows, nil
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"medea/pkg/database/models"
+
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+)
+
+var (
+	ErrArchiveFile    = errors.New("can't archive a file, only directories")
+	ErrInvalidPattern = errors.New("invalid glob pattern")
+)
+
+type DirectoryArchive struct {
+	BaseService
+
+	Token   *models.Token `validate:"required"`
+	IP      *string       `validate:"omitempty"`
+	SubDir  string        `validate:"omitempty"`
+	Format  string        `validate:"required,oneof=zip tar tar.gz"`
+	Include []string      `validate:"omitempty,max=100"`
+	Exclude []string      `validate:"omitempty,max=100"`
+	Hidden  int8          `validate:"omitempty,oneof=0 1"`
+}
+
+// DirectoryArchiveResponse streams the archive of a directory by WriteTo, the
+// files are read one by one while the archive is written.
+type DirectoryArchiveResponse struct {
+	Name   string
+	Format string
+
+	dir     *models.File
+	archive *DirectoryArchive
+}
+
+func (da *DirectoryArchive) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(da); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if err = ValidateToken(da.DB, da.IP, true, da.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("DirectoryArchive.Token", err))
+	}
+
+	if !ValidatePath(da.SubDir) {
+		validateErrors = append(validateErrors, generateErrorByField("DirectoryArchive.SubDir", ErrInvalidPath))
+	}
+
+	if !validPatterns(da.Include) {
+		validateErrors = append(validateErrors, generateErrorByField("DirectoryArchive.Include", ErrInvalidPattern))
+	}
+
+	if !validPatterns(da.Exclude) {
+		validateErrors = append(validateErrors, generateErrorByField("DirectoryArchive.Exclude", ErrInvalidPattern))
+	}
+
+	return validateErrors
+}
+
+func validPatterns(patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return false
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (da *DirectoryArchive) Execute(ctx context.Context) (interface{}, error) {
+	var (
+		err     error
+		dir     *models.File
+		dirPath = da.Token.PathWithScope(da.SubDir)
+	)
+
+	if err = da.Token.UpdateAvailableTimes(-1, da.DB); err != nil {
+		return nil, err
+	}
+
+	if dir, err = models.FindFileByPath(&da.Token.App, dirPath, da.DB, false); err != nil {
+		return nil, err
+	}
+
+	if dir.IsDir == 0 {
+		return nil, ErrArchiveFile
+	}
+
+	name := dir.Name
+	if name == "" {
+		name = "archive"
+	}
+
+	return &DirectoryArchiveResponse{
+		Name:    name + "." + da.Format,
+		Format:  da.Format,
+		dir:     dir,
+		archive: da,
+	}, nil
+}
+
+// matchPatterns tells whether any of patterns matches the file at relPath, a
+// pattern without a slash is matched against the base name only.
+func matchPatterns(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(relPath)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveWriter is what zip and tar have in common for the archive.
+type archiveWriter interface {
+	writeDir(dir *models.File, relPath string) error
+	writeFile(file *models.File, relPath string, reader io.Reader) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (zw zipArchiveWriter) writeDir(dir *models.File, relPath string) error {
+	header := &zip.FileHeader{Name: relPath + "/", Modified: dir.UpdatedAt}
+	header.SetMode(os.ModeDir | 0755)
+	_, err := zw.CreateHeader(header)
+	return err
+}
+
+func (zw zipArchiveWriter) writeFile(file *models.File, relPath string, reader io.Reader) error {
+	header := &zip.FileHeader{Name: relPath, Method: zip.Deflate, Modified: file.UpdatedAt}
+	header.SetMode(0644)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+	gzip *gzip.Writer
+}
+
+func (tw tarArchiveWriter) writeDir(dir *models.File, relPath string) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     relPath + "/",
+		Mode:     0755,
+		ModTime:  dir.UpdatedAt,
+	})
+}
+
+func (tw tarArchiveWriter) writeFile(file *models.File, relPath string, reader io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     relPath,
+		Mode:     0644,
+		Size:     int64(file.Size),
+		ModTime:  file.UpdatedAt,
+	}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, reader, int64(file.Size))
+	return err
+}
+
+func (tw tarArchiveWriter) Close() error {
+	if err := tw.Writer.Close(); err != nil {
+		return err
+	}
+	if tw.gzip != nil {
+		return tw.gzip.Close()
+	}
+	return nil
+}
+
+// WriteTo writes the archive to w. The directories are written as entries
+// only if there are no include patterns, otherwise most of them would be left
+// empty.
+func (dar *DirectoryArchiveResponse) WriteTo(w io.Writer) (int64, error) {
+	var (
+		err     error
+		aw      archiveWriter
+		counter = &countWriter{w: w}
+		da      = dar.archive
+	)
+
+	switch dar.Format {
+	case ArchiveZip:
+		aw = zipArchiveWriter{zip.NewWriter(counter)}
+	case ArchiveTar:
+		aw = tarArchiveWriter{Writer: tar.NewWriter(counter)}
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(counter)
+		aw = tarArchiveWriter{Writer: tar.NewWriter(gw), gzip: gw}
+	}
+
+	if err = dar.dir.Walk(func(file *models.File, relPath string) error {
+		if file.Hidden == 1 && da.Hidden != 1 {
+			return models.ErrSkipDir
+		}
+		if matchPatterns(da.Exclude, relPath) {
+			return models.ErrSkipDir
+		}
+		if file.IsDir == models.IsDir {
+			if len(da.Include) > 0 {
+				return nil
+			}
+			return aw.writeDir(file, relPath)
+		}
+		if len(da.Include) > 0 && !matchPatterns(da.Include, relPath) {
+			return nil
+		}
+
+		reader, err := file.Reader(da.RootPath, da.DB)
+		if err != nil {
+			return err
+		}
+		return aw.writeFile(file, relPath, reader)
+	}, da.DB); err != nil {
+		return counter.count, err
+	}
+
+	err = aw.Close()
+	return counter.count, err
+}
+
+type countWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -96,6 +96,21 @@ var (
 			Field: "TokenCreate.ReadOnly",
 			Msg:   "readOnly of token is 0 or 1",
 		},
+		"TokenCreate.QuotaBytes": {
+			Code:  10054,
+			Field: "TokenCreate.QuotaBytes",
+			Msg:   "quotaBytes of token must be greater than or equal to 0",
+		},
+		"TokenCreate.QuotaFiles": {
+			Code:  10055,
+			Field: "TokenCreate.QuotaFiles",
+			Msg:   "quotaFiles of token must be greater than or equal to 0",
+		},
+		"TokenCreate.RateLimit": {
+			Code:  10107,
+			Field: "TokenCreate.RateLimit",
+			Msg:   "rateLimit must be json of read, write and schedules, rates are bytes per second and at least 0",
+		},
 
 		"TokenUpdate.Token": {
 			Code:  10008,
@@ -132,6 +147,21 @@ var (
 			Field: "TokenUpdate.AvailableTimes",
 			Msg:   "availableTimes must be a integer, and must be greater than -1, it's optional",
 		},
+		"TokenUpdate.QuotaBytes": {
+			Code:  10056,
+			Field: "TokenUpdate.QuotaBytes",
+			Msg:   "quotaBytes must be greater than or equal to 0, it's optional",
+		},
+		"TokenUpdate.QuotaFiles": {
+			Code:  10057,
+			Field: "TokenUpdate.QuotaFiles",
+			Msg:   "quotaFiles must be greater than or equal to 0, it's optional",
+		},
+		"TokenUpdate.RateLimit": {
+			Code:  10108,
+			Field: "TokenUpdate.RateLimit",
+			Msg:   "rateLimit must be json of read, write and schedules, rates are bytes per second and at least 0",
+		},
 
 		"FileCreate.App": {
 			Code:  10015,
@@ -173,6 +203,21 @@ var (
 			Field: "FileCreate.Operate",
 			Msg:   ErrOnlyOneRenameAppendOverWrite.Error(),
 		},
+		"FileCreate.Size": {
+			Code:  10051,
+			Field: "FileCreate.Size",
+			Msg:   "size must be greater than or equal to 0",
+		},
+		"FileCreate.Hash": {
+			Code:  10052,
+			Field: "FileCreate.Hash",
+			Msg:   "hash must be a hex encoded sha256 string",
+		},
+		"FileCreate.Quota": {
+			Code:  10053,
+			Field: "FileCreate.Quota",
+			Msg:   "storage quota of the app or the token is exceeded",
+		},
 
 		"FileRead.Token": {
 			Code:  10023,
@@ -217,6 +262,232 @@ var (
 			Msg:   "file is required",
 		},
 
+		"FileCopy.Token": {
+			Code:  10063,
+			Field: "FileCopy.Token",
+			Msg:   "token is required",
+		},
+		"FileCopy.File": {
+			Code:  10064,
+			Field: "FileCopy.File",
+			Msg:   "file is required",
+		},
+		"FileCopy.DstToken": {
+			Code:  10065,
+			Field: "FileCopy.DstToken",
+			Msg:   "destination token must be valid and writable",
+		},
+		"FileCopy.Path": {
+			Code:  10066,
+			Field: "FileCopy.Path",
+			Msg:   "path of copy can't be empty, max of length is 1000, and must be a legal unix path",
+		},
+		"FileCopy.Overwrite": {
+			Code:  10067,
+			Field: "FileCopy.Overwrite",
+			Msg:   "overwrite must be 0 or 1",
+		},
+		"FileCopy.Rename": {
+			Code:  10068,
+			Field: "FileCopy.Rename",
+			Msg:   "rename must be 0 or 1",
+		},
+		"FileCopy.Operate": {
+			Code:  10069,
+			Field: "FileCopy.Operate",
+			Msg:   ErrOnlyOneRenameAppendOverWrite.Error(),
+		},
+		"FileCopy.Quota": {
+			Code:  10070,
+			Field: "FileCopy.Quota",
+			Msg:   "storage quota of the app or the token is exceeded",
+		},
+
+		"FileWrite.Token": {
+			Code:  10071,
+			Field: "FileWrite.Token",
+			Msg:   "token is required",
+		},
+		"FileWrite.File": {
+			Code:  10072,
+			Field: "FileWrite.File",
+			Msg:   "file is required",
+		},
+		"FileWrite.Offset": {
+			Code:  10073,
+			Field: "FileWrite.Offset",
+			Msg:   "offset must be between 0 and the size of file",
+		},
+		"FileWrite.Hidden": {
+			Code:  10074,
+			Field: "FileWrite.Hidden",
+			Msg:   "hidden must be 0 or 1",
+		},
+		"FileWrite.Quota": {
+			Code:  10075,
+			Field: "FileWrite.Quota",
+			Msg:   "storage quota of the app or the token is exceeded",
+		},
+
+		"FileTruncate.Token": {
+			Code:  10076,
+			Field: "FileTruncate.Token",
+			Msg:   "token is required",
+		},
+		"FileTruncate.File": {
+			Code:  10077,
+			Field: "FileTruncate.File",
+			Msg:   "file is required",
+		},
+		"FileTruncate.Size": {
+			Code:  10078,
+			Field: "FileTruncate.Size",
+			Msg:   "size must be between 0 and the size of file",
+		},
+
+		"FileCompose.Token": {
+			Code:  10079,
+			Field: "FileCompose.Token",
+			Msg:   "token is required",
+		},
+		"FileCompose.Files": {
+			Code:  10080,
+			Field: "FileCompose.Files",
+			Msg:   fmt.Sprintf("files are required, at most %d files can be composed", MaxComposeFiles),
+		},
+		"FileCompose.Path": {
+			Code:  10081,
+			Field: "FileCompose.Path",
+			Msg:   "path of composed file can't be empty, max of length is 1000, and must be a legal unix path",
+		},
+		"FileCompose.Hidden": {
+			Code:  10082,
+			Field: "FileCompose.Hidden",
+			Msg:   "hidden must be 0 or 1",
+		},
+		"FileCompose.Overwrite": {
+			Code:  10083,
+			Field: "FileCompose.Overwrite",
+			Msg:   "overwrite must be 0 or 1",
+		},
+		"FileCompose.Rename": {
+			Code:  10084,
+			Field: "FileCompose.Rename",
+			Msg:   "rename must be 0 or 1",
+		},
+		"FileCompose.Operate": {
+			Code:  10085,
+			Field: "FileCompose.Operate",
+			Msg:   ErrOnlyOneRenameAppendOverWrite.Error(),
+		},
+		"FileCompose.Quota": {
+			Code:  10086,
+			Field: "FileCompose.Quota",
+			Msg:   "storage quota of the app or the token is exceeded",
+		},
+
+		"DirectoryArchive.Token": {
+			Code:  10087,
+			Field: "DirectoryArchive.Token",
+			Msg:   "token is required",
+		},
+		"DirectoryArchive.SubDir": {
+			Code:  10088,
+			Field: "DirectoryArchive.SubDir",
+			Msg:   "subDir must be a legal unix path",
+		},
+		"DirectoryArchive.Format": {
+			Code:  10089,
+			Field: "DirectoryArchive.Format",
+			Msg:   "format is only allowed to be one of zip tar tar.gz",
+		},
+		"DirectoryArchive.Include": {
+			Code:  10090,
+			Field: "DirectoryArchive.Include",
+			Msg:   "include must be at most 100 legal glob patterns",
+		},
+		"DirectoryArchive.Exclude": {
+			Code:  10091,
+			Field: "DirectoryArchive.Exclude",
+			Msg:   "exclude must be at most 100 legal glob patterns",
+		},
+		"DirectoryArchive.Hidden": {
+			Code:  10092,
+			Field: "DirectoryArchive.Hidden",
+			Msg:   "hidden must be 0 or 1",
+		},
+
+		"PresignedRead.Presign": {
+			Code:  10093,
+			Field: "PresignedRead.Presign",
+			Msg:   "the presigned url is required, and must be neither expired nor used from other methods or ips",
+		},
+		"PresignedRead.File": {
+			Code:  10094,
+			Field: "PresignedRead.File",
+			Msg:   "the file can't be found or accessed by the presigned url",
+		},
+		"PresignedRead.Method": {
+			Code:  10095,
+			Field: "PresignedRead.Method",
+			Msg:   "method must be GET or HEAD",
+		},
+		"PresignedRead.MaxBytes": {
+			Code:  10096,
+			Field: "PresignedRead.MaxBytes",
+			Msg:   "the size of file is beyond maxBytes of the presigned url",
+		},
+		"PresignedCreate.Presign": {
+			Code:  10097,
+			Field: "PresignedCreate.Presign",
+			Msg:   "the presigned url is required, and must be neither expired nor used from other methods or ips",
+		},
+		"PresignedCreate.Path": {
+			Code:  10098,
+			Field: "PresignedCreate.Path",
+			Msg:   "path of file can't be empty, max of length is 1000, and must be a legal unix path",
+		},
+		"PresignedCreate.Method": {
+			Code:  10099,
+			Field: "PresignedCreate.Method",
+			Msg:   "method must be PUT",
+		},
+		"PresignedCreate.Reader": {
+			Code:  10100,
+			Field: "PresignedCreate.Reader",
+			Msg:   "content of file is required",
+		},
+		"PresignedCreate.Hidden": {
+			Code:  10101,
+			Field: "PresignedCreate.Hidden",
+			Msg:   "hidden must be 0 or 1",
+		},
+		"PresignedCreate.Overwrite": {
+			Code:  10102,
+			Field: "PresignedCreate.Overwrite",
+			Msg:   "overwrite must be 0 or 1",
+		},
+		"PresignedCreate.Rename": {
+			Code:  10103,
+			Field: "PresignedCreate.Rename",
+			Msg:   "rename must be 0 or 1",
+		},
+		"PresignedCreate.Operate": {
+			Code:  10104,
+			Field: "PresignedCreate.Operate",
+			Msg:   ErrOnlyOneRenameAppendOverWrite.Error(),
+		},
+		"PresignedCreate.MaxBytes": {
+			Code:  10105,
+			Field: "PresignedCreate.MaxBytes",
+			Msg:   "the size of file is beyond maxBytes of the presigned url",
+		},
+		"PresignedCreate.Quota": {
+			Code:  10106,
+			Field: "PresignedCreate.Quota",
+			Msg:   "storage quota of the app or the token is exceeded",
+		},
+
 		"DirectoryList.Token": {
 			Code:  10031,
 			Field: "DirectoryList.Token",
@@ -242,6 +513,124 @@ var (
 			Field: "DirectoryList.Limit",
 			Msg:   "the min value of limit is 10, and max of limit 20",
 		},
+
+		"UploadInitiate.Token": {
+			Code:  10036,
+			Field: "UploadInitiate.Token",
+			Msg:   "can't find specific token by input params",
+		},
+		"UploadInitiate.Path": {
+			Code:  10037,
+			Field: "UploadInitiate.Path",
+			Msg:   "path of file can't be empty, max of length is 1000, and must be a legal unix path",
+		},
+		"UploadInitiate.Hidden": {
+			Code:  10038,
+			Field: "UploadInitiate.Hidden",
+			Msg:   "hidden must be 0 or 1",
+		},
+		"UploadInitiate.Overwrite": {
+			Code:  10039,
+			Field: "UploadInitiate.Overwrite",
+			Msg:   "overwrite must be 0 or 1",
+		},
+		"UploadInitiate.Rename": {
+			Code:  10040,
+			Field: "UploadInitiate.Rename",
+			Msg:   "rename must be 0 or 1",
+		},
+		"UploadInitiate.Operate": {
+			Code:  10041,
+			Field: "UploadInitiate.Operate",
+			Msg:   ErrOnlyOneRenameAppendOverWrite.Error(),
+		},
+
+		"UploadPartPut.Token": {
+			Code:  10042,
+			Field: "UploadPartPut.Token",
+			Msg:   "token is required",
+		},
+		"UploadPartPut.Session": {
+			Code:  10043,
+			Field: "UploadPartPut.Session",
+			Msg:   "upload session is required",
+		},
+		"UploadPartPut.Number": {
+			Code:  10044,
+			Field: "UploadPartPut.Number",
+			Msg:   "part number must be greater than 0",
+		},
+
+		"UploadPartList.Token": {
+			Code:  10045,
+			Field: "UploadPartList.Token",
+			Msg:   "token is required",
+		},
+		"UploadPartList.Session": {
+			Code:  10046,
+			Field: "UploadPartList.Session",
+			Msg:   "upload session is required",
+		},
+
+		"UploadComplete.Token": {
+			Code:  10047,
+			Field: "UploadComplete.Token",
+			Msg:   "token is required",
+		},
+		"UploadComplete.Session": {
+			Code:  10048,
+			Field: "UploadComplete.Session",
+			Msg:   "upload session is required",
+		},
+		"UploadComplete.Quota": {
+			Code:  10058,
+			Field: "UploadComplete.Quota",
+			Msg:   "storage quota of the app or the token is exceeded",
+		},
+
+		"Usage.Token": {
+			Code:  10059,
+			Field: "Usage.Token",
+			Msg:   "token is required",
+		},
+
+		"Stats.Token": {
+			Code:  10109,
+			Field: "Stats.Token",
+			Msg:   "token is required",
+		},
+		"Stats.Range": {
+			Code:  10110,
+			Field: "Stats.Range",
+			Msg:   "from must not be after to, and at most 366 days are allowed",
+		},
+
+		"UploadAbort.Token": {
+			Code:  10049,
+			Field: "UploadAbort.Token",
+			Msg:   "token is required",
+		},
+		"UploadAbort.Session": {
+			Code:  10050,
+			Field: "UploadAbort.Session",
+			Msg:   "upload session is required",
+		},
+
+		"UploadNegotiate.Token": {
+			Code:  10060,
+			Field: "UploadNegotiate.Token",
+			Msg:   "token is required",
+		},
+		"UploadNegotiate.Session": {
+			Code:  10061,
+			Field: "UploadNegotiate.Session",
+			Msg:   "upload session is required",
+		},
+		"UploadNegotiate.Digests": {
+			Code:  10062,
+			Field: "UploadNegotiate.Digests",
+			Msg:   "chunks must be listed by hex encoded sha256 hashes and sizes, at most 10000 of them",
+		},
 	}
 )
 
@@ -4,12 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"io"
 	"math"
 	"medea/pkg/database/models"
 	"medea/pkg/utils"
-	libPath "path"
 	"strings"
 
 	"github.com/go-playground/validator"
@@ -20,6 +18,10 @@ var (
 	ErrPathExisted                  = errors.New("the path has already existed")
 	ErrOnlyOneRenameAppendOverWrite = errors.New("only one of rename, append and overwrite is allowed")
 	ErrFileHasBeenDeleted           = errors.New("the file has been deleted")
+	ErrSizeMismatch                 = errors.New("the size of file doesn't match")
+	ErrHashMismatch                 = errors.New("the hash of file doesn't match")
+	ErrPreconditionFailed           = errors.New("precondition failed, the file doesn't match If-Match")
+	ErrFileChanged                  = errors.New("the file has been changed by another write meanwhile")
 )
 
 type FileCreate struct {
@@ -33,6 +35,11 @@ type FileCreate struct {
 	Overwrite int8          `validate:"oneof=0 1"`
 	Rename    int8          `validate:"oneof=0 1"`
 	Append    int8          `validate:"oneof=0 1"`
+	Size      *int          `validate:"omitempty,min=0"`
+	Hash      *string       `validate:"omitempty,len=64"`
+	IfMatch   *string       `validate:"omitempty"`
+
+	quota *quota
 }
 
 func (fc *FileCreate) Validate() ValidateErrors {
@@ -65,14 +72,23 @@ func (fc *FileCreate) Validate() ValidateErrors {
 	return validateErrors
 }
 
-func (fc *FileCreate) Execute(ctx context.Context) (interface{}, error) {
+func (fc *FileCreate) Execute(ctx context.Context) (result interface{}, err error) {
 	var (
-		err   error
-		path  = fc.Token.PathWithScope(fc.Path)
-		file  *models.File
-		inTrx = utils.InTransaction(fc.DB)
+		path         = fc.Token.PathWithScope(fc.Path)
+		inTrx        = utils.InTransaction(fc.DB)
+		draft        *models.ObjectDraft
+		digestReader *utils.DigestReader
 	)
 
+	// the content is stored before the transaction, so that its locks aren't
+	// held while the body is read. Appending changes the last chunk of the
+	// file, which is only done within the transaction.
+	if fc.Reader != nil && fc.Append == 0 {
+		if draft, err = fc.writeDraft(path); err != nil {
+			return nil, err
+		}
+	}
+
 	if !inTrx {
 		fc.DB = fc.DB.BeginTx(ctx, &sql.TxOptions{
 			Isolation: sql.LevelReadCommitted,
@@ -81,9 +97,14 @@ func (fc *FileCreate) Execute(ctx context.Context) (interface{}, error) {
 		defer func() {
 			if reErr := recover(); reErr != nil {
 				fc.DB.Rollback()
+				panic(reErr)
 			}
+			if err != nil {
+				fc.DB.Rollback()
+				return
+			}
+			err = fc.DB.Commit().Error
 		}()
-		defer func() { err = fc.DB.Commit().Error }()
 	}
 
 	if err = fc.Token.UpdateAvailableTimes(-1, fc.DB); err != nil {
@@ -94,36 +115,136 @@ func (fc *FileCreate) Execute(ctx context.Context) (interface{}, error) {
 		return models.CreateOrGetLastDirectory(&fc.Token.App, path, fc.DB)
 	}
 
+	if fc.quota, err = beginQuota("FileCreate.Quota", fc.Token, fc.DB); err != nil {
+		return nil, err
+	}
+
+	if draft == nil && (fc.Size != nil || fc.Hash != nil) {
+		digestReader = utils.NewDigestReader(fc.Reader)
+		fc.Reader = digestReader
+	}
+
+	if result, err = fc.execute(path, draft); err != nil {
+		return nil, fc.quota.error(err)
+	}
+
+	if err = fc.quota.end(fc.DB); err != nil || digestReader == nil {
+		return result, err
+	}
+
+	if err = checkDigests(digestReader, fc.Size, fc.Hash); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// writeDraft stores the content of Reader, limited by the quota as it's
+// before the write, and checks its size and hash.
+func (fc *FileCreate) writeDraft(path string) (draft *models.ObjectDraft, err error) {
+	var (
+		file         *models.File
+		q            *quota
+		released     int64
+		reader       = fc.Reader
+		digestReader *utils.DigestReader
+	)
+
+	if fc.Overwrite == 1 {
+		if file, err = models.FindFileByPathWithTrashed(&fc.Token.App, path, fc.DB); err == nil {
+			released = int64(file.Size)
+		}
+	}
+
+	if q, err = peekQuota("FileCreate.Quota", fc.Token, fc.DB); err != nil {
+		return nil, err
+	}
+
+	if fc.Size != nil || fc.Hash != nil {
+		digestReader = utils.NewDigestReader(reader)
+		reader = digestReader
+	}
+
+	if draft, err = models.WriteObjectDraft(&fc.Token.App, q.limit(reader, released), fc.RootPath, fc.DB); err != nil {
+		return nil, q.error(err)
+	}
+
+	if digestReader != nil {
+		return draft, checkDigests(digestReader, fc.Size, fc.Hash)
+	}
+
+	return draft, nil
+}
+
+func checkDigests(digestReader *utils.DigestReader, size *int, hash *string) error {
+	if size != nil && digestReader.Size() != *size {
+		return ErrSizeMismatch
+	}
+
+	if hash != nil && digestReader.Hash() != *hash {
+		return ErrHashMismatch
+	}
+
+	return nil
+}
+
+// execute saves the content to path, from draft if it has been stored, or
+// else from Reader.
+func (fc *FileCreate) execute(path string, draft *models.ObjectDraft) (interface{}, error) {
+	var (
+		err    error
+		file   *models.File
+		object *models.Object
+	)
+
 	if file, err = models.FindFileByPathWithTrashed(&fc.Token.App, path, fc.DB); err != nil && !utils.IsRecordNotFound(err) {
 		return nil, err
 	}
 
-	if file == nil || file.ID == 0 {
-		return models.CreateFileFromReader(&fc.Token.App, path, fc.Reader, fc.Hidden, fc.RootPath, fc.DB)
+	if err = checkIfMatch(fc.IfMatch, file, fc.DB); err != nil {
+		return nil, err
 	}
 
-	if file.DeletedAt != nil && (fc.Append == 1 || fc.Overwrite == 1) {
+	if draft == nil {
+		return fc.append(file)
+	}
+
+	if file != nil && file.ID != 0 && fc.Overwrite == 0 && fc.Rename == 0 {
+		return nil, ErrPathExisted
+	}
+
+	if file != nil && file.DeletedAt != nil && fc.Overwrite == 1 {
 		return nil, ErrFileHasBeenDeleted
 	}
 
+	if object, err = draft.Save(fc.RootPath, fc.DB); err != nil {
+		return nil, err
+	}
+
+	if file == nil || file.ID == 0 {
+		return models.CreateFileWithObject(&fc.Token.App, path, object, fc.Hidden, fc.DB)
+	}
+
 	if fc.Overwrite == 1 {
-		return file, file.OverWriteFromReader(fc.Reader, fc.Hidden, fc.RootPath, fc.DB)
+		return file, file.OverWriteWithObject(object, fc.Hidden, fc.DB)
 	}
 
-	if fc.Append == 1 {
-		return file, file.AppendFromReader(fc.Reader, fc.Hidden, fc.RootPath, fc.DB)
+	return models.CreateFileWithObject(&fc.Token.App, models.RenamedPath(path), object, fc.Hidden, fc.DB)
+}
+
+// append appends Reader to file, or creates it if there isn't one.
+func (fc *FileCreate) append(file *models.File) (interface{}, error) {
+	fc.Reader = fc.quota.limit(fc.Reader, 0)
+
+	if file == nil || file.ID == 0 {
+		return models.CreateFileFromReader(&fc.Token.App, fc.Token.PathWithScope(fc.Path), fc.Reader, fc.Hidden, fc.RootPath, fc.DB)
 	}
 
-	if fc.Rename == 1 {
-		var (
-			dir      = libPath.Dir(path)
-			basename = libPath.Base(path)
-		)
-		path = fmt.Sprintf("%s/%s_%s", dir, models.RandomWithMD5(256), basename)
-		return models.CreateFileFromReader(&fc.Token.App, path, fc.Reader, fc.Hidden, fc.RootPath, fc.DB)
+	if file.DeletedAt != nil {
+		return nil, ErrFileHasBeenDeleted
 	}
 
-	return nil, ErrPathExisted
+	return file, file.AppendFromReader(fc.Reader, fc.Hidden, fc.RootPath, fc.DB)
 }
 
 var ErrReadHiddenFile = errors.New("try to read the hidden file")
@@ -273,11 +394,12 @@ func (dl *DirectoryList) Execute(ctx context.Context) (interface{}, error) {
 type FileUpdate struct {
 	BaseService
 
-	Token  *models.Token `validate:"required"`
-	File   *models.File  `validate:"required"`
-	IP     *string       `validate:"omitempty"`
-	Hidden *int8         `validate:"omitempty,oneof=0 1"`
-	Path   *string       `validate:"omitempty,max=1000"`
+	Token   *models.Token `validate:"required"`
+	File    *models.File  `validate:"required"`
+	IP      *string       `validate:"omitempty"`
+	Hidden  *int8         `validate:"omitempty,oneof=0 1"`
+	Path    *string       `validate:"omitempty,max=1000"`
+	IfMatch *string       `validate:"omitempty"`
 }
 
 func (fu *FileUpdate) Validate() ValidateErrors {
@@ -312,11 +434,8 @@ func (fu *FileUpdate) Validate() ValidateErrors {
 	return validateErrors
 }
 
-func (fu *FileUpdate) Execute(ctx context.Context) (interface{}, error) {
-	var (
-		err   error
-		inTrx = utils.InTransaction(fu.DB)
-	)
+func (fu *FileUpdate) Execute(ctx context.Context) (result interface{}, err error) {
+	var inTrx = utils.InTransaction(fu.DB)
 
 	if !inTrx {
 		fu.DB = fu.DB.BeginTx(ctx, &sql.TxOptions{
@@ -326,17 +445,26 @@ func (fu *FileUpdate) Execute(ctx context.Context) (interface{}, error) {
 		defer func() {
 			if reErr := recover(); reErr != nil {
 				fu.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				fu.DB.Rollback()
+				return
 			}
+			err = fu.DB.Commit().Error
 		}()
-		defer func() { err = fu.DB.Commit().Error }()
 	}
 
 	if err = fu.Token.UpdateAvailableTimes(-1, fu.DB); err != nil {
 		return nil, err
 	}
 
+	if err = checkIfMatch(fu.IfMatch, fu.File, fu.DB); err != nil {
+		return nil, err
+	}
+
 	if fu.Path != nil {
-		if err := fu.File.MoveTo(fu.Token.PathWithScope(*fu.Path), fu.DB); err != nil {
+		if err = fu.File.MoveTo(fu.Token.PathWithScope(*fu.Path), fu.DB); err != nil {
 			return nil, err
 		}
 	}
@@ -345,7 +473,420 @@ func (fu *FileUpdate) Execute(ctx context.Context) (interface{}, error) {
 		fu.File.Hidden = *fu.Hidden
 	}
 
-	return fu.File, fu.DB.Save(fu.File).Error
+	if err = fu.DB.Save(fu.File).Error; err != nil {
+		return nil, err
+	}
+
+	return fu.File, nil
+}
+
+type FileCopy struct {
+	BaseService
+
+	Token     *models.Token `validate:"required"`
+	File      *models.File  `validate:"required"`
+	IP        *string       `validate:"omitempty"`
+	DstToken  *models.Token `validate:"omitempty"`
+	Path      string        `validate:"required,max=1000"`
+	Overwrite int8          `validate:"oneof=0 1"`
+	Rename    int8          `validate:"oneof=0 1"`
+}
+
+func (fc *FileCopy) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if fc.Overwrite+fc.Rename > 1 {
+		validateErrors = append(
+			validateErrors,
+			generateErrorByField("FileCopy.Operate", ErrOnlyOneRenameAppendOverWrite),
+		)
+	}
+
+	if err = Validate.Struct(fc); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if err = ValidateToken(fc.DB, fc.IP, true, fc.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileCopy.Token", err))
+	}
+
+	if err = ValidateFile(fc.DB, fc.File); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileCopy.File", err))
+	} else if fc.File.AppID != fc.Token.AppID {
+		validateErrors = append(validateErrors, generateErrorByField("FileCopy.Token", models.ErrAccessDenied))
+	} else if err = fc.File.CanBeAccessedByToken(fc.Token, fc.DB); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileCopy.Token", err))
+	}
+
+	// the copy is written through the destination token, which is the token
+	// itself unless the file is copied to another app
+	if err = ValidateToken(fc.DB, fc.IP, false, fc.dstToken()); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileCopy.DstToken", err))
+	}
+
+	if !ValidatePath(fc.Path) {
+		validateErrors = append(validateErrors, generateErrorByField("FileCopy.Path", ErrInvalidPath))
+	}
+
+	return validateErrors
+}
+
+func (fc *FileCopy) dstToken() *models.Token {
+	if fc.DstToken != nil {
+		return fc.DstToken
+	}
+	return fc.Token
+}
+
+func (fc *FileCopy) Execute(ctx context.Context) (result interface{}, err error) {
+	var (
+		q          *quota
+		onConflict = models.ConflictFail
+		dstToken   = fc.dstToken()
+		inTrx      = utils.InTransaction(fc.DB)
+	)
+
+	if !inTrx {
+		fc.DB = fc.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				fc.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				fc.DB.Rollback()
+				return
+			}
+			err = fc.DB.Commit().Error
+		}()
+	}
+
+	if err = fc.Token.UpdateAvailableTimes(-1, fc.DB); err != nil {
+		return nil, err
+	}
+	if dstToken != fc.Token {
+		if err = dstToken.UpdateAvailableTimes(-1, fc.DB); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc.Overwrite == 1 {
+		onConflict = models.ConflictOverwrite
+	} else if fc.Rename == 1 {
+		onConflict = models.ConflictRename
+	}
+
+	if q, err = beginQuota("FileCopy.Quota", dstToken, fc.DB); err != nil {
+		return nil, err
+	}
+
+	if result, err = fc.File.CopyTo(&dstToken.App, dstToken.PathWithScope(fc.Path), onConflict, fc.RootPath, fc.DB); err != nil {
+		return nil, err
+	}
+
+	return result, q.end(fc.DB)
+}
+
+type FileWrite struct {
+	BaseService
+
+	Token  *models.Token `validate:"required"`
+	File   *models.File  `validate:"required"`
+	IP     *string       `validate:"omitempty"`
+	Reader io.Reader     `validate:"required"`
+	Offset int           `validate:"min=0"`
+	Hidden *int8         `validate:"omitempty,oneof=0 1"`
+}
+
+func (fw *FileWrite) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(fw); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if err = ValidateToken(fw.DB, fw.IP, false, fw.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileWrite.Token", err))
+	}
+
+	if err = ValidateFile(fw.DB, fw.File); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileWrite.File", err))
+	} else if err = fw.File.CanBeAccessedByToken(fw.Token, fw.DB); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileWrite.Token", err))
+	} else if fw.Offset > fw.File.Size {
+		validateErrors = append(validateErrors, generateErrorByField("FileWrite.Offset", models.ErrOffsetOutOfRange))
+	}
+
+	return validateErrors
+}
+
+func (fw *FileWrite) Execute(ctx context.Context) (result interface{}, err error) {
+	var (
+		q        *quota
+		draft    *models.ObjectDraft
+		object   *models.Object
+		objectID uint64
+		hidden   = fw.File.Hidden
+		inTrx    = utils.InTransaction(fw.DB)
+	)
+
+	// the content is stored before the transaction as FileCreate does, the
+	// file is only checked not to be changed meanwhile
+	if draft, err = fw.writeDraft(); err != nil {
+		return nil, err
+	}
+	objectID = fw.File.ObjectID
+
+	if !inTrx {
+		fw.DB = fw.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				fw.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				fw.DB.Rollback()
+				return
+			}
+			err = fw.DB.Commit().Error
+		}()
+	}
+
+	if err = fw.Token.UpdateAvailableTimes(-1, fw.DB); err != nil {
+		return nil, err
+	}
+
+	if err = fw.DB.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", fw.File.ID).First(fw.File).Error; err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, ErrFileHasBeenDeleted
+		}
+		return nil, err
+	}
+	if fw.File.ObjectID != objectID {
+		return nil, ErrFileChanged
+	}
+
+	if fw.Hidden != nil {
+		hidden = *fw.Hidden
+	}
+
+	if q, err = beginQuota("FileWrite.Quota", fw.Token, fw.DB); err != nil {
+		return nil, err
+	}
+
+	if object, err = draft.Save(fw.RootPath, fw.DB); err != nil {
+		return nil, err
+	}
+
+	if err = fw.File.OverWriteWithObject(object, hidden, fw.DB); err != nil {
+		return nil, err
+	}
+
+	return fw.File, q.end(fw.DB)
+}
+
+// writeDraft stores the content of Reader written at Offset, limited by the
+// quota as it's before the write.
+func (fw *FileWrite) writeDraft() (draft *models.ObjectDraft, err error) {
+	var q *quota
+
+	if q, err = peekQuota("FileWrite.Quota", fw.Token, fw.DB); err != nil {
+		return nil, err
+	}
+
+	// the bytes overwritten within the file don't grow it
+	reader := q.limit(fw.Reader, int64(fw.File.Size-fw.Offset))
+	if draft, _, err = fw.File.WriteAtDraft(fw.Offset, reader, fw.RootPath, fw.DB); err != nil {
+		return nil, q.error(err)
+	}
+
+	return draft, nil
+}
+
+type FileTruncate struct {
+	BaseService
+
+	Token *models.Token `validate:"required"`
+	File  *models.File  `validate:"required"`
+	IP    *string       `validate:"omitempty"`
+	Size  int           `validate:"min=0"`
+}
+
+func (ft *FileTruncate) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(ft); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if err = ValidateToken(ft.DB, ft.IP, false, ft.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileTruncate.Token", err))
+	}
+
+	if err = ValidateFile(ft.DB, ft.File); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileTruncate.File", err))
+	} else if err = ft.File.CanBeAccessedByToken(ft.Token, ft.DB); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileTruncate.Token", err))
+	} else if ft.Size > ft.File.Size {
+		validateErrors = append(validateErrors, generateErrorByField("FileTruncate.Size", models.ErrOffsetOutOfRange))
+	}
+
+	return validateErrors
+}
+
+func (ft *FileTruncate) Execute(ctx context.Context) (result interface{}, err error) {
+	inTrx := utils.InTransaction(ft.DB)
+
+	if !inTrx {
+		ft.DB = ft.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				ft.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				ft.DB.Rollback()
+				return
+			}
+			err = ft.DB.Commit().Error
+		}()
+	}
+
+	if err = ft.Token.UpdateAvailableTimes(-1, ft.DB); err != nil {
+		return nil, err
+	}
+
+	return ft.File, ft.File.Truncate(ft.Size, ft.RootPath, ft.DB)
+}
+
+const MaxComposeFiles = 1000
+
+type FileCompose struct {
+	BaseService
+
+	Token     *models.Token  `validate:"required"`
+	Files     []*models.File `validate:"required,min=1,max=1000"`
+	IP        *string        `validate:"omitempty"`
+	Path      string         `validate:"required,max=1000"`
+	Hidden    int8           `validate:"oneof=0 1"`
+	Overwrite int8           `validate:"oneof=0 1"`
+	Rename    int8           `validate:"oneof=0 1"`
+}
+
+func (fc *FileCompose) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if fc.Overwrite+fc.Rename > 1 {
+		validateErrors = append(
+			validateErrors,
+			generateErrorByField("FileCompose.Operate", ErrOnlyOneRenameAppendOverWrite),
+		)
+	}
+
+	if err = Validate.Struct(fc); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if err = ValidateToken(fc.DB, fc.IP, false, fc.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("FileCompose.Token", err))
+	}
+
+	for _, file := range fc.Files {
+		if err = ValidateFile(fc.DB, file); err != nil {
+			validateErrors = append(validateErrors, generateErrorByField("FileCompose.Files", err))
+			break
+		}
+		if file.AppID != fc.Token.AppID {
+			validateErrors = append(validateErrors, generateErrorByField("FileCompose.Token", models.ErrAccessDenied))
+			break
+		}
+		if err = file.CanBeAccessedByToken(fc.Token, fc.DB); err != nil {
+			validateErrors = append(validateErrors, generateErrorByField("FileCompose.Token", err))
+			break
+		}
+	}
+
+	if !ValidatePath(fc.Path) {
+		validateErrors = append(validateErrors, generateErrorByField("FileCompose.Path", ErrInvalidPath))
+	}
+
+	return validateErrors
+}
+
+func (fc *FileCompose) Execute(ctx context.Context) (result interface{}, err error) {
+	var (
+		q          *quota
+		onConflict = models.ConflictFail
+		inTrx      = utils.InTransaction(fc.DB)
+	)
+
+	if !inTrx {
+		fc.DB = fc.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				fc.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				fc.DB.Rollback()
+				return
+			}
+			err = fc.DB.Commit().Error
+		}()
+	}
+
+	if err = fc.Token.UpdateAvailableTimes(-1, fc.DB); err != nil {
+		return nil, err
+	}
+
+	if fc.Overwrite == 1 {
+		onConflict = models.ConflictOverwrite
+	} else if fc.Rename == 1 {
+		onConflict = models.ConflictRename
+	}
+
+	if q, err = beginQuota("FileCompose.Quota", fc.Token, fc.DB); err != nil {
+		return nil, err
+	}
+
+	path := fc.Token.PathWithScope(fc.Path)
+	if result, err = models.ComposeFiles(&fc.Token.App, fc.Files, path, fc.Hidden, onConflict, fc.RootPath, fc.DB); err != nil {
+		return nil, err
+	}
+
+	return result, q.end(fc.DB)
 }
 
 type FileDelete struct {
@@ -383,10 +924,9 @@ func (fd *FileDelete) Validate() ValidateErrors {
 	return validateErrors
 }
 
-func (fd *FileDelete) Execute(ctx context.Context) (interface{}, error) {
+func (fd *FileDelete) Execute(ctx context.Context) (result interface{}, err error) {
 	var (
 		falseValue = false
-		err        error
 		inTrx      = utils.InTransaction(fd.DB)
 	)
 
@@ -398,9 +938,14 @@ func (fd *FileDelete) Execute(ctx context.Context) (interface{}, error) {
 		defer func() {
 			if reErr := recover(); reErr != nil {
 				fd.DB.Rollback()
+				panic(reErr)
 			}
+			if err != nil {
+				fd.DB.Rollback()
+				return
+			}
+			err = fd.DB.Commit().Error
 		}()
-		defer func() { err = fd.DB.Commit().Error }()
 	}
 
 	if err = fd.Token.UpdateAvailableTimes(-1, fd.DB); err != nil {
@@ -417,3 +962,34 @@ func (fd *FileDelete) Execute(ctx context.Context) (interface{}, error) {
 
 	return fd.File, nil
 }
+
+// checkIfMatch evaluates If-Match against the object of file, the file is
+// locked until the transaction ends so that it can't be changed in between.
+// It never matches a file which doesn't exist.
+func checkIfMatch(ifMatch *string, file *models.File, db *gorm.DB) error {
+	if ifMatch == nil {
+		return nil
+	}
+	if file == nil || file.ID == 0 || file.DeletedAt != nil {
+		return ErrPreconditionFailed
+	}
+
+	var (
+		current = &models.File{}
+		object  = &models.Object{}
+		etag    string
+	)
+	if err := db.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", file.ID).First(current).Error; err != nil {
+		return err
+	}
+	if current.IsDir == 0 {
+		if err := db.Where("id = ?", current.ObjectID).First(object).Error; err != nil {
+			return err
+		}
+		etag = utils.ETag(object.Hash)
+	}
+	if !utils.MatchETag(*ifMatch, etag, false) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
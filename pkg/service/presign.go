@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"medea/pkg/database/models"
+	"medea/pkg/utils"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var (
+	ErrPresignExpired  = errors.New("the presigned url has expired")
+	ErrPresignMethod   = errors.New("the method isn't allowed by the presigned url")
+	ErrPresignIP       = errors.New("the ip isn't allowed by the presigned url")
+	ErrPresignMaxBytes = errors.New("the size is beyond maxBytes of the presigned url")
+)
+
+// Presign is what a presigned url allows, its signature is checked before by
+// the secret of App, or of Token if it's signed by a token.
+type Presign struct {
+	App      *models.App
+	Token    *models.Token
+	Method   string
+	Expires  time.Time
+	IP       *string
+	MaxBytes *int64
+}
+
+func (p *Presign) validate(db *gorm.DB, ip *string, method string) error {
+	if time.Now().After(p.Expires) {
+		return ErrPresignExpired
+	}
+	if method != p.Method && !(method == http.MethodHead && p.Method == http.MethodGet) {
+		return ErrPresignMethod
+	}
+	if p.IP != nil && (ip == nil || !matchIP(*p.IP, *ip)) {
+		return ErrPresignIP
+	}
+	if p.Token != nil {
+		return ValidateToken(db, ip, p.Method == http.MethodGet, p.Token)
+	}
+	return nil
+}
+
+// matchIP tells whether ip is allowed, which is either an ip or a CIDR.
+func matchIP(allowed, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	if strings.Contains(allowed, "/") {
+		_, network, err := net.ParseCIDR(allowed)
+		return err == nil && network.Contains(parsed)
+	}
+	return parsed.Equal(net.ParseIP(allowed))
+}
+
+// path returns the full path of p within the scope of the token.
+func (p *Presign) path(path string) string {
+	if p.Token != nil {
+		return p.Token.PathWithScope(path)
+	}
+	return path
+}
+
+type PresignedRead struct {
+	BaseService
+
+	Presign *Presign     `validate:"required"`
+	File    *models.File `validate:"required"`
+	IP      *string      `validate:"omitempty"`
+	Method  string       `validate:"required,oneof=GET HEAD"`
+}
+
+func (pr *PresignedRead) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(pr); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+		return validateErrors
+	}
+
+	if err = pr.Presign.validate(pr.DB, pr.IP, pr.Method); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("PresignedRead.Presign", err))
+	}
+
+	if err = ValidateFile(pr.DB, pr.File); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("PresignedRead.File", err))
+		return validateErrors
+	}
+
+	if pr.File.AppID != pr.Presign.App.ID {
+		validateErrors = append(validateErrors, generateErrorByField("PresignedRead.File", models.ErrAccessDenied))
+	} else if pr.Presign.Token != nil {
+		if err = pr.File.CanBeAccessedByToken(pr.Presign.Token, pr.DB); err != nil {
+			validateErrors = append(validateErrors, generateErrorByField("PresignedRead.File", err))
+		}
+	}
+
+	if pr.Presign.MaxBytes != nil && int64(pr.File.Size) > *pr.Presign.MaxBytes {
+		validateErrors = append(validateErrors, generateErrorByField("PresignedRead.MaxBytes", ErrPresignMaxBytes))
+	}
+
+	return validateErrors
+}
+
+func (pr *PresignedRead) Execute(ctx context.Context) (interface{}, error) {
+	if pr.File.Hidden == 1 {
+		return nil, ErrReadHiddenFile
+	}
+
+	return pr.File.Reader(pr.RootPath, pr.DB)
+}
+
+type PresignedCreate struct {
+	BaseService
+
+	Presign   *Presign  `validate:"required"`
+	Path      string    `validate:"required,max=1000"`
+	IP        *string   `validate:"omitempty"`
+	Method    string    `validate:"required,eq=PUT"`
+	Reader    io.Reader `validate:"required"`
+	Hidden    int8      `validate:"oneof=0 1"`
+	Overwrite int8      `validate:"oneof=0 1"`
+	Rename    int8      `validate:"oneof=0 1"`
+
+	quota *quota
+}
+
+func (pc *PresignedCreate) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if pc.Overwrite+pc.Rename > 1 {
+		validateErrors = append(
+			validateErrors,
+			generateErrorByField("PresignedCreate.Operate", ErrOnlyOneRenameAppendOverWrite),
+		)
+	}
+
+	if err = Validate.Struct(pc); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+		return validateErrors
+	}
+
+	if err = pc.Presign.validate(pc.DB, pc.IP, pc.Method); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("PresignedCreate.Presign", err))
+	}
+
+	if !ValidatePath(pc.Path) {
+		validateErrors = append(validateErrors, generateErrorByField("PresignedCreate.Path", ErrInvalidPath))
+	}
+
+	return validateErrors
+}
+
+func (pc *PresignedCreate) Execute(ctx context.Context) (result interface{}, err error) {
+	var (
+		file       *models.File
+		object     *models.Object
+		draft      *models.ObjectDraft
+		q          *quota
+		released   int64
+		path       = pc.Presign.path(pc.Path)
+		onConflict = models.ConflictFail
+		inTrx      = utils.InTransaction(pc.DB)
+	)
+
+	if pc.Overwrite == 1 {
+		onConflict = models.ConflictOverwrite
+		if file, err = models.FindFileByPath(pc.Presign.App, path, pc.DB, false); err == nil {
+			released = int64(file.Size)
+		}
+	} else if pc.Rename == 1 {
+		onConflict = models.ConflictRename
+	} else if file, err = models.FindFileByPathWithTrashed(pc.Presign.App, path, pc.DB); err == nil && file.ID > 0 {
+		return nil, models.ErrFileExisted
+	}
+
+	// the content is stored before the transaction, so that its locks aren't
+	// held while the body is read, the quota is checked again within it
+	if q, err = peekAppQuota("PresignedCreate.Quota", pc.Presign.App, pc.Presign.Token, pc.DB); err != nil {
+		return nil, err
+	}
+
+	reader := q.limit(pc.Reader, released)
+	if pc.Presign.MaxBytes != nil {
+		reader = utils.NewLimitedReader(reader, *pc.Presign.MaxBytes, ErrPresignMaxBytes)
+	}
+
+	if draft, err = models.WriteObjectDraft(pc.Presign.App, reader, pc.RootPath, pc.DB); err != nil {
+		if err == ErrPresignMaxBytes {
+			err = ValidateErrors{generateErrorByField("PresignedCreate.MaxBytes", err)}
+		}
+		return nil, q.error(err)
+	}
+
+	if !inTrx {
+		pc.DB = pc.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				pc.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				pc.DB.Rollback()
+				return
+			}
+			err = pc.DB.Commit().Error
+		}()
+	}
+
+	if pc.quota, err = beginAppQuota("PresignedCreate.Quota", pc.Presign.App, pc.Presign.Token, pc.DB); err != nil {
+		return nil, err
+	}
+
+	if object, err = draft.Save(pc.RootPath, pc.DB); err != nil {
+		return nil, err
+	}
+
+	if file, err = models.SaveObjectToPath(pc.Presign.App, path, object, pc.Hidden, onConflict, pc.DB); err != nil {
+		return nil, pc.quota.error(err)
+	}
+
+	if err = pc.quota.end(pc.DB); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
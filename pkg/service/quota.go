@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	"medea/pkg/database/models"
+	"medea/pkg/utils"
+
+	"github.com/jinzhu/gorm"
+)
+
+// quota checks a write of token against the quotas of its app and itself, the
+// usage of the app is locked from begin, so the usage grown by the write is
+// the difference at end. A write without token, such as one by a URL presigned
+// with the app secret, is only checked against the app.
+type quota struct {
+	field  string
+	app    *models.App
+	token  *models.Token
+	before *models.Usage
+}
+
+func beginQuota(field string, token *models.Token, db *gorm.DB) (q *quota, err error) {
+	return beginAppQuota(field, &token.App, token, db)
+}
+
+func beginAppQuota(field string, app *models.App, token *models.Token, db *gorm.DB) (q *quota, err error) {
+	q = &quota{field: field, app: app, token: token}
+	if q.before, err = app.LockUsage(db); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// peekQuota reads the usage of the app of token without locking it, it only
+// limits a write stored before the quota is begun, which checks it again.
+func peekQuota(field string, token *models.Token, db *gorm.DB) (q *quota, err error) {
+	return peekAppQuota(field, &token.App, token, db)
+}
+
+func peekAppQuota(field string, app *models.App, token *models.Token, db *gorm.DB) (q *quota, err error) {
+	q = &quota{field: field, app: app, token: token}
+	if q.before, err = app.Usage(db); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// remainingBytes returns the bytes which can still be written by token, it is
+// negative if neither the app nor the token has a byte quota.
+func (q *quota) remainingBytes() int64 {
+	remaining := q.before.RemainingBytes()
+	if q.token == nil {
+		return remaining
+	}
+	tokenRemaining := q.token.Usage().RemainingBytes()
+	if tokenRemaining >= 0 && (remaining < 0 || tokenRemaining < remaining) {
+		return tokenRemaining
+	}
+	return remaining
+}
+
+// limit makes reader fail once more than the remaining bytes plus released,
+// which are the bytes freed by the write, are read.
+func (q *quota) limit(reader io.Reader, released int64) io.Reader {
+	remaining := q.remainingBytes()
+	if remaining < 0 {
+		return reader
+	}
+	return utils.NewLimitedReader(reader, remaining+released, models.ErrQuotaExceeded)
+}
+
+// end checks the usage grown by the write, and records it as written by the
+// token. Shrinking usage isn't credited to the token.
+func (q *quota) end(db *gorm.DB) error {
+	var (
+		after        *models.Usage
+		bytes, files int64
+		err          error
+	)
+	if after, err = q.app.Usage(db); err != nil {
+		return err
+	}
+	bytes, files = after.Bytes-q.before.Bytes, after.Files-q.before.Files
+	if after.Exceeded(bytes, files) {
+		return q.error(models.ErrQuotaExceeded)
+	}
+	if q.token == nil {
+		return nil
+	}
+
+	if bytes < 0 {
+		bytes = 0
+	}
+	if files < 0 {
+		files = 0
+	}
+	if err = q.token.AddUsage(bytes, files, db); err != nil {
+		return err
+	}
+	if q.token.Usage().Exceeded(bytes, files) {
+		return q.error(models.ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// error turns the quota errors into validate errors, so that they are
+// reported with their code.
+func (q *quota) error(err error) error {
+	if err == models.ErrQuotaExceeded {
+		return ValidateErrors{generateErrorByField(q.field, err)}
+	}
+	return err
+}
+
+type UsageResponse struct {
+	App   *models.Usage
+	Token *models.Usage
+}
+
+type Usage struct {
+	BaseService
+
+	Token *models.Token `validate:"required"`
+	IP    *string       `validate:"omitempty"`
+}
+
+func (u *Usage) Validate() ValidateErrors {
+	var validateErrors ValidateErrors
+
+	if err := ValidateToken(u.DB, u.IP, true, u.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("Usage.Token", err))
+	}
+
+	return validateErrors
+}
+
+func (u *Usage) Execute(ctx context.Context) (interface{}, error) {
+	var (
+		appUsage *models.Usage
+		err      error
+	)
+
+	if appUsage, err = u.Token.App.Usage(u.DB); err != nil {
+		return nil, err
+	}
+
+	return &UsageResponse{App: appUsage, Token: u.Token.Usage()}, nil
+}
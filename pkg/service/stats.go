@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"medea/pkg/database/models"
+)
+
+// MaxStatsDays is the most days of stats returned at once.
+const MaxStatsDays = 366
+
+var ErrInvalidStatsRange = errors.New("from must not be after to, and at most 366 days are allowed")
+
+// StatsResponse has the app-wide stats only for a token scoped to the root
+// of the app, since they include the traffic of the other tokens.
+type StatsResponse struct {
+	App   []models.DailyStat
+	Token []models.DailyStat
+}
+
+type Stats struct {
+	BaseService
+
+	Token *models.Token `validate:"required"`
+	IP    *string       `validate:"omitempty"`
+	From  time.Time
+	To    time.Time
+}
+
+func (s *Stats) Validate() ValidateErrors {
+	var validateErrors ValidateErrors
+
+	if err := ValidateToken(s.DB, s.IP, true, s.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("Stats.Token", err))
+	}
+
+	if s.From.After(s.To) || s.To.Sub(s.From) >= MaxStatsDays*24*time.Hour {
+		validateErrors = append(validateErrors, generateErrorByField("Stats.Range", ErrInvalidStatsRange))
+	}
+
+	return validateErrors
+}
+
+func (s *Stats) Execute(ctx context.Context) (interface{}, error) {
+	var (
+		resp = &StatsResponse{}
+		err  error
+	)
+
+	if s.Token.Scope() == "/" {
+		if resp.App, err = models.FindDailyStats(&s.Token.App, nil, s.From, s.To, s.DB); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.Token, err = models.FindDailyStats(&s.Token.App, s.Token, s.From, s.To, s.DB); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
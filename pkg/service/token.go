@@ -19,6 +19,9 @@ type TokenCreate struct {
 	ReadOnly       int8        `validate:"oneof=0 1"`
 	ExpiredAt      *time.Time  `validate:"omitempty,gt"`
 	AvailableTimes int         `validate:"omitempty,gte=-1,max=2147483647"`
+	QuotaBytes     int64       `validate:"omitempty,gte=0"`
+	QuotaFiles     int64       `validate:"omitempty,gte=0"`
+	RateLimit      *string     `validate:"omitempty"`
 
 	token *models.Token
 }
@@ -43,12 +46,23 @@ func (t *TokenCreate) Validate() ValidateErrors {
 		validateErrors = append(validateErrors, generateErrorByField("TokenCreate.Path", ErrInvalidPath))
 	}
 
+	if _, err := models.ParseRateLimit(t.RateLimit); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("TokenCreate.RateLimit", err))
+	}
+
 	return validateErrors
 }
 
 func (t *TokenCreate) Execute(ctx context.Context) (interface{}, error) {
-	var err error
-	t.token, err = models.NewToken(t.App, t.Path, t.ExpiredAt, t.IP, t.Secret, t.AvailableTimes, t.ReadOnly, t.DB)
+	var (
+		err       error
+		rateLimit *string
+		limit, _  = models.ParseRateLimit(t.RateLimit)
+	)
+	if rateLimit, err = models.RateLimitText(limit); err != nil {
+		return nil, err
+	}
+	t.token, err = models.NewToken(t.App, t.Path, t.ExpiredAt, t.IP, t.Secret, t.AvailableTimes, t.ReadOnly, t.QuotaBytes, t.QuotaFiles, rateLimit, t.DB)
 	return t.token, err
 }
 
@@ -62,6 +76,9 @@ type TokenUpdate struct {
 	ReadOnly       *int8      `validate:"omitempty,oneof=0 1"`
 	ExpiredAt      *time.Time `validate:"omitempty,gt"`
 	AvailableTimes *int       `validate:"omitempty,gte=-1,max=2147483647"`
+	QuotaBytes     *int64     `validate:"omitempty,gte=0"`
+	QuotaFiles     *int64     `validate:"omitempty,gte=0"`
+	RateLimit      *string    `validate:"omitempty"`
 }
 
 func (t *TokenUpdate) Validate() ValidateErrors {
@@ -83,6 +100,10 @@ func (t *TokenUpdate) Validate() ValidateErrors {
 		}
 	}
 
+	if _, err := models.ParseRateLimit(t.RateLimit); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("TokenUpdate.RateLimit", err))
+	}
+
 	return validateErrors
 }
 
@@ -111,6 +132,18 @@ func (t *TokenUpdate) Execute(ctx context.Context) (result interface{}, err erro
 	if t.AvailableTimes != nil {
 		token.AvailableTimes = *t.AvailableTimes
 	}
+	if t.QuotaBytes != nil {
+		token.QuotaBytes = *t.QuotaBytes
+	}
+	if t.QuotaFiles != nil {
+		token.QuotaFiles = *t.QuotaFiles
+	}
+	if t.RateLimit != nil {
+		limit, _ := models.ParseRateLimit(t.RateLimit)
+		if token.RateLimit, err = models.RateLimitText(limit); err != nil {
+			return nil, err
+		}
+	}
 
 	if t.DB.Save(token).Error != nil {
 		return nil, err
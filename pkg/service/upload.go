@@ -0,0 +1,385 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"medea/pkg/database/models"
+	"medea/pkg/utils"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var ErrInvalidUploadSession = errors.New("invalid upload session")
+
+type UploadInitiate struct {
+	BaseService
+
+	Token     *models.Token `validate:"required"`
+	Path      string        `validate:"required,max=1000"`
+	Hidden    int8          `validate:"oneof=0 1"`
+	IP        *string       `validate:"omitempty"`
+	Overwrite int8          `validate:"oneof=0 1"`
+	Rename    int8          `validate:"oneof=0 1"`
+}
+
+func (ui *UploadInitiate) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if ui.Overwrite+ui.Rename > 1 {
+		validateErrors = append(
+			validateErrors,
+			generateErrorByField("UploadInitiate.Operate", ErrOnlyOneRenameAppendOverWrite),
+		)
+	}
+
+	if err = Validate.Struct(ui); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if err = ValidateToken(ui.DB, ui.IP, false, ui.Token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField("UploadInitiate.Token", err))
+	}
+
+	if !ValidatePath(ui.Path) {
+		validateErrors = append(validateErrors, generateErrorByField("UploadInitiate.Path", ErrInvalidPath))
+	}
+
+	return validateErrors
+}
+
+func (ui *UploadInitiate) Execute(ctx context.Context) (interface{}, error) {
+	var onConflict = models.ConflictFail
+
+	if err := ui.Token.UpdateAvailableTimes(-1, ui.DB); err != nil {
+		return nil, err
+	}
+
+	if ui.Overwrite == 1 {
+		onConflict = models.ConflictOverwrite
+	} else if ui.Rename == 1 {
+		onConflict = models.ConflictRename
+	}
+
+	return models.NewUploadSession(&ui.Token.App, ui.Token.PathWithScope(ui.Path), ui.Hidden, onConflict, ui.DB)
+}
+
+func validateUploadSession(db *gorm.DB, ip *string, token *models.Token, session *models.UploadSession, field string) ValidateErrors {
+	var validateErrors ValidateErrors
+
+	if err := ValidateToken(db, ip, false, token); err != nil {
+		validateErrors = append(validateErrors, generateErrorByField(field+".Token", err))
+	}
+
+	if session == nil || session.ID == 0 {
+		validateErrors = append(validateErrors, generateErrorByField(field+".Session", ErrInvalidUploadSession))
+	} else if token != nil {
+		if err := session.CanBeAccessedByToken(token); err != nil {
+			validateErrors = append(validateErrors, generateErrorByField(field+".Session", err))
+		}
+	}
+
+	return validateErrors
+}
+
+type UploadPartPut struct {
+	BaseService
+
+	Token   *models.Token         `validate:"required"`
+	Session *models.UploadSession `validate:"required"`
+	IP      *string               `validate:"omitempty"`
+	Number  int                   `validate:"min=1"`
+	Content []byte                `validate:"omitempty"`
+}
+
+func (up *UploadPartPut) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(up); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	return append(validateErrors, validateUploadSession(up.DB, up.IP, up.Token, up.Session, "UploadPartPut")...)
+}
+
+func (up *UploadPartPut) Execute(ctx context.Context) (result interface{}, err error) {
+	var inTrx = utils.InTransaction(up.DB)
+
+	if !inTrx {
+		up.DB = up.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				up.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				up.DB.Rollback()
+				return
+			}
+			err = up.DB.Commit().Error
+		}()
+	}
+
+	if err = up.Token.UpdateAvailableTimes(-1, up.DB); err != nil {
+		return nil, err
+	}
+
+	// lock the session, so that the parts aren't replaced concurrently, nor while it's completed
+	if err = up.DB.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", up.Session.ID).First(up.Session).Error; err != nil {
+		return nil, err
+	}
+
+	return up.Session.PutPart(up.Number, up.Content, up.RootPath, up.DB)
+}
+
+type UploadPartList struct {
+	BaseService
+
+	Token   *models.Token         `validate:"required"`
+	Session *models.UploadSession `validate:"required"`
+	IP      *string               `validate:"omitempty"`
+}
+
+func (ul *UploadPartList) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(ul); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	return append(validateErrors, validateUploadSession(ul.DB, ul.IP, ul.Token, ul.Session, "UploadPartList")...)
+}
+
+func (ul *UploadPartList) Execute(ctx context.Context) (interface{}, error) {
+	if err := ul.Token.UpdateAvailableTimes(-1, ul.DB); err != nil {
+		return nil, err
+	}
+	return ul.Session.ListParts(ul.DB)
+}
+
+// MaxNegotiateChunks is the most chunks negotiated at once.
+const MaxNegotiateChunks = 10000
+
+var ErrInvalidChunkDigests = errors.New("invalid chunk hashes or sizes")
+
+type UploadNegotiate struct {
+	BaseService
+
+	Token   *models.Token         `validate:"required"`
+	Session *models.UploadSession `validate:"required"`
+	IP      *string               `validate:"omitempty"`
+	Digests []models.ChunkDigest  `validate:"omitempty"`
+}
+
+func (un *UploadNegotiate) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(un); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	if !validChunkDigests(un.Digests) {
+		validateErrors = append(validateErrors, generateErrorByField("UploadNegotiate.Digests", ErrInvalidChunkDigests))
+	}
+
+	return append(validateErrors, validateUploadSession(un.DB, un.IP, un.Token, un.Session, "UploadNegotiate")...)
+}
+
+func validChunkDigests(digests []models.ChunkDigest) bool {
+	if len(digests) == 0 || len(digests) > MaxNegotiateChunks {
+		return false
+	}
+	for _, digest := range digests {
+		if len(digest.Hash) != 64 || strings.Trim(digest.Hash, "0123456789abcdef") != "" {
+			return false
+		}
+		if digest.Size < 0 || digest.Size > models.ChunkSize {
+			return false
+		}
+	}
+	return true
+}
+
+// Execute returns the numbers of the parts which have to be uploaded, the
+// others are taken from the chunks already stored.
+func (un *UploadNegotiate) Execute(ctx context.Context) (result interface{}, err error) {
+	var inTrx = utils.InTransaction(un.DB)
+
+	if !inTrx {
+		un.DB = un.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				un.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				un.DB.Rollback()
+				return
+			}
+			err = un.DB.Commit().Error
+		}()
+	}
+
+	if err = un.Token.UpdateAvailableTimes(-1, un.DB); err != nil {
+		return nil, err
+	}
+
+	// lock the session, so that it isn't completed meanwhile
+	if err = un.DB.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", un.Session.ID).First(un.Session).Error; err != nil {
+		return nil, err
+	}
+
+	return un.Session.Negotiate(un.Digests, un.DB)
+}
+
+type UploadComplete struct {
+	BaseService
+
+	Token   *models.Token         `validate:"required"`
+	Session *models.UploadSession `validate:"required"`
+	IP      *string               `validate:"omitempty"`
+}
+
+func (uc *UploadComplete) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(uc); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	return append(validateErrors, validateUploadSession(uc.DB, uc.IP, uc.Token, uc.Session, "UploadComplete")...)
+}
+
+func (uc *UploadComplete) Execute(ctx context.Context) (result interface{}, err error) {
+	var (
+		inTrx = utils.InTransaction(uc.DB)
+		q     *quota
+	)
+
+	if !inTrx {
+		uc.DB = uc.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				uc.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				uc.DB.Rollback()
+				return
+			}
+			err = uc.DB.Commit().Error
+		}()
+	}
+
+	if err = uc.Token.UpdateAvailableTimes(-1, uc.DB); err != nil {
+		return nil, err
+	}
+
+	// lock the session, so that it can't be completed twice concurrently
+	if err = uc.DB.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", uc.Session.ID).First(uc.Session).Error; err != nil {
+		return nil, err
+	}
+
+	if q, err = beginQuota("UploadComplete.Quota", uc.Token, uc.DB); err != nil {
+		return nil, err
+	}
+
+	if result, err = uc.Session.Complete(uc.RootPath, uc.DB); err != nil {
+		return nil, err
+	}
+
+	return result, q.end(uc.DB)
+}
+
+type UploadAbort struct {
+	BaseService
+
+	Token   *models.Token         `validate:"required"`
+	Session *models.UploadSession `validate:"required"`
+	IP      *string               `validate:"omitempty"`
+}
+
+func (ua *UploadAbort) Validate() ValidateErrors {
+	var (
+		err            error
+		validateErrors ValidateErrors
+	)
+
+	if err = Validate.Struct(ua); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			validateErrors = append(validateErrors, PreDefinedValidateErrors[err.Namespace()])
+		}
+	}
+
+	return append(validateErrors, validateUploadSession(ua.DB, ua.IP, ua.Token, ua.Session, "UploadAbort")...)
+}
+
+func (ua *UploadAbort) Execute(ctx context.Context) (result interface{}, err error) {
+	var inTrx = utils.InTransaction(ua.DB)
+
+	if !inTrx {
+		ua.DB = ua.DB.BeginTx(ctx, &sql.TxOptions{
+			Isolation: sql.LevelReadCommitted,
+			ReadOnly:  false,
+		})
+		defer func() {
+			if reErr := recover(); reErr != nil {
+				ua.DB.Rollback()
+				panic(reErr)
+			}
+			if err != nil {
+				ua.DB.Rollback()
+				return
+			}
+			err = ua.DB.Commit().Error
+		}()
+	}
+
+	if err = ua.Token.UpdateAvailableTimes(-1, ua.DB); err != nil {
+		return nil, err
+	}
+
+	// lock the session, so that it isn't completed or written meanwhile
+	if err = ua.DB.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", ua.Session.ID).First(ua.Session).Error; err != nil {
+		return nil, err
+	}
+
+	return ua.Session, ua.Session.Abort(ua.DB)
+}
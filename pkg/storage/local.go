@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: strings.TrimSuffix(root, string(os.PathSeparator))}
+}
+
+func (ls *LocalStore) path(key string) string {
+	return filepath.Join(ls.root, filepath.FromSlash(key))
+}
+
+func (ls *LocalStore) Put(key string, p []byte) error {
+	var path = ls.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, p, 0644)
+}
+
+func (ls *LocalStore) Get(key string) ([]byte, error) {
+	p, err := ioutil.ReadFile(ls.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return p, err
+}
+
+type sectionReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (ls *LocalStore) Range(key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(ls.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	if length >= 0 {
+		return &sectionReadCloser{Reader: io.NewSectionReader(file, offset, length), Closer: file}, nil
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Append writes p to the end of the file at key, which is created if needed,
+// and returns the offset p is written at.
+func (ls *LocalStore) Append(key string, p []byte) (int64, error) {
+	var path = ls.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	if _, err = file.Write(p); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	return fileInfo.Size(), file.Close()
+}
+
+func (ls *LocalStore) Delete(key string) error {
+	err := os.Remove(ls.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (ls *LocalStore) Stat(key string) (*Info, error) {
+	fileInfo, err := os.Stat(ls.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, err
+	}
+	return &Info{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+func (ls *LocalStore) List(prefix string, fn func(info *Info) error) error {
+	err := filepath.Walk(ls.root, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fileInfo.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(ls.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(&Info{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()})
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"medea/pkg/config"
+)
+
+const DefaultRoot = "default"
+
+const (
+	TierHot  = "hot"
+	TierCold = "cold"
+)
+
+var (
+	ErrNoRoot        = errors.New("no chunk root is available for placement")
+	ErrDuplicateRoot = errors.New("duplicate chunk root name")
+	ErrUnknownTier   = errors.New("tier of chunk root must be hot or cold")
+)
+
+// Root is a named store of chunks, such as a disk. Chunks are placed on the
+// roots in proportion to their weight, a root of zero weight is drained and
+// only read. New chunks are placed on hot roots, cold roots only receive the
+// chunks moved by tiering.
+type Root struct {
+	Name   string
+	Weight int
+	Tier   string
+	Store  ChunkStore
+}
+
+var (
+	defaultRoots     []*Root
+	defaultRootsErr  error
+	defaultRootsOnce sync.Once
+)
+
+func NewRoots(conf *config.Chunk) ([]*Root, error) {
+	if len(conf.Roots) == 0 {
+		store, err := New(conf)
+		if err != nil {
+			return nil, err
+		}
+		return []*Root{{Name: DefaultRoot, Weight: 1, Tier: TierHot, Store: store}}, nil
+	}
+
+	var (
+		roots = make([]*Root, 0, len(conf.Roots))
+		names = make(map[string]bool)
+	)
+	for _, rootConf := range conf.Roots {
+		if names[rootConf.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateRoot, rootConf.Name)
+		}
+		names[rootConf.Name] = true
+
+		store, err := New(&config.Chunk{RootPath: rootConf.Path, Backend: rootConf.Backend, S3: rootConf.S3})
+		if err != nil {
+			return nil, err
+		}
+		root := &Root{Name: rootConf.Name, Weight: rootConf.Weight, Tier: rootConf.Tier, Store: store}
+		switch root.Tier {
+		case "":
+			root.Tier = TierHot
+		case TierHot, TierCold:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTier, root.Tier)
+		}
+		if root.Weight <= 0 {
+			root.Weight = 1
+		}
+		if rootConf.Drain {
+			root.Weight = 0
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// DefaultRoots returns the roots configured by config.DefaultConfig, they are
+// created on the first call.
+func DefaultRoots() ([]*Root, error) {
+	defaultRootsOnce.Do(func() {
+		defaultRoots, defaultRootsErr = NewRoots(&config.DefaultConfig.Chunk)
+	})
+	return defaultRoots, defaultRootsErr
+}
+
+func FindRoot(roots []*Root, name string) *Root {
+	for _, root := range roots {
+		if root.Name == name {
+			return root
+		}
+	}
+	return nil
+}
+
+// TierRoots returns the roots of tier.
+func TierRoots(roots []*Root, tier string) []*Root {
+	var tiered []*Root
+	for _, root := range roots {
+		if root.Tier == tier {
+			tiered = append(tiered, root)
+		}
+	}
+	return tiered
+}
+
+// Appendable returns the roots whose stores are Appender.
+func Appendable(roots []*Root) []*Root {
+	var appendable []*Root
+	for _, root := range roots {
+		if _, ok := root.Store.(Appender); ok {
+			appendable = append(appendable, root)
+		}
+	}
+	return appendable
+}
+
+// IsCold tells whether all roots are cold.
+func IsCold(roots []*Root) bool {
+	for _, root := range roots {
+		if root.Tier != TierCold {
+			return false
+		}
+	}
+	return len(roots) > 0
+}
+
+// Place chooses n roots for key by weighted rendezvous hashing, so adding,
+// removing or reweighting a root only moves the keys it gains or loses.
+func Place(key string, roots []*Root, n int) ([]*Root, error) {
+	type scored struct {
+		root  *Root
+		score float64
+	}
+
+	candidates := make([]scored, 0, len(roots))
+	for _, root := range roots {
+		if root.Weight <= 0 {
+			continue
+		}
+		sum := sha256.Sum256([]byte(root.Name + "/" + key))
+		// uniform in (0, 1)
+		u := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+		candidates = append(candidates, scored{root: root, score: float64(root.Weight) / -math.Log(u)})
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoRoot
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	placed := make([]*Root, n)
+	for i := range placed {
+		placed[i] = candidates[i].root
+	}
+	return placed, nil
+}
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"medea/pkg/config"
+)
+
+const (
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3Service       = "s3"
+	s3TimeFormat    = "20060102T150405Z"
+	s3DateFormat    = "20060102"
+	s3EmptyBodyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var ErrInvalidS3Config = errors.New("endpoint, region and bucket of s3 are required")
+
+// S3Store keeps chunks in a bucket of an S3 compatible object storage, such
+// as AWS S3 or MinIO. Requests are signed by signature version 4.
+type S3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	prefix    string
+	pathStyle bool
+	client    *http.Client
+}
+
+func NewS3Store(conf *config.S3) (*S3Store, error) {
+	if conf.Endpoint == "" || conf.Region == "" || conf.Bucket == "" {
+		return nil, ErrInvalidS3Config
+	}
+
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		endpoint:  endpoint,
+		region:    conf.Region,
+		bucket:    conf.Bucket,
+		accessKey: conf.AccessKey,
+		secretKey: conf.SecretKey,
+		prefix:    strings.Trim(conf.Prefix, "/"),
+		pathStyle: conf.PathStyle,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *S3Store) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *S3Store) url(objectKey string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = ""
+	}
+	if objectKey != "" {
+		u.Path += "/" + objectKey
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (s *S3Store) do(method, objectKey string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	var (
+		u           = s.url(objectKey, query)
+		payloadHash = s3EmptyBodyHash
+	)
+
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	request, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	request.ContentLength = int64(len(body))
+	s.sign(request, u, payloadHash, time.Now().UTC())
+
+	return s.client.Do(request)
+}
+
+func (s *S3Store) sign(request *http.Request, u *url.URL, payloadHash string, now time.Time) {
+	var (
+		amzDate = now.Format(s3TimeFormat)
+		date    = now.Format(s3DateFormat)
+		scope   = strings.Join([]string{date, s.region, s3Service, "aws4_request"}, "/")
+	)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if r := request.Header.Get("Range"); r != "" {
+		headers["range"] = r
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := bytes.NewBufferString("")
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		encodePath(u.Path),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode encodes every byte except the unreserved characters as required
+// by signature version 4, slashes are kept if keepSlash is true.
+func uriEncode(s string, keepSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func encodePath(path string) string {
+	return uriEncode(path, true)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("s3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (s *S3Store) Put(key string, p []byte) error {
+	resp, err := s.do(http.MethodPut, s.objectKey(key), nil, http.Header{
+		"Content-Type": {"application/octet-stream"},
+	}, p)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(key string) ([]byte, error) {
+	reader, err := s.Range(key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func (s *S3Store) Range(key string, offset, length int64) (io.ReadCloser, error) {
+	var header = http.Header{}
+
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(http.MethodGet, s.objectKey(key), nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		_ = resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	default:
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+}
+
+func (s *S3Store) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.objectKey(key), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		if err = responseError(resp); err != ErrNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *S3Store) Stat(key string) (*Info, error) {
+	resp, err := s.do(http.MethodHead, s.objectKey(key), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	info := &Info{Key: key}
+	if info.Size, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err != nil {
+		return nil, err
+	}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func (s *S3Store) List(prefix string, fn func(info *Info) error) error {
+	var continuationToken string
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.objectKey(prefix)},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		result, err := s.listObjects(query)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range result.Contents {
+			key := item.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			if err = fn(&Info{Key: key, Size: item.Size, ModTime: item.LastModified}); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) listObjects(query url.Values) (*listBucketResult, error) {
+	resp, err := s.do(http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	result := &listBucketResult{}
+	return result, xml.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"medea/pkg/config"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+var (
+	ErrNotExist       = errors.New("chunk doesn't exist in the store")
+	ErrUnknownBackend = errors.New("unknown chunk store backend")
+)
+
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ChunkStore keeps the content of chunks by key, a key is a slash separated
+// relative path such as "1/234/1234567".
+type ChunkStore interface {
+	Put(key string, p []byte) error
+	Get(key string) ([]byte, error)
+	// Range reads length bytes from offset, a negative length reads to the end
+	Range(key string, offset, length int64) (io.ReadCloser, error)
+	Delete(key string) error
+	Stat(key string) (*Info, error)
+	List(prefix string, fn func(info *Info) error) error
+}
+
+// Appender is implemented by the stores which can append to a file, small
+// chunks are packed into files of such stores.
+type Appender interface {
+	Append(key string, p []byte) (offset int64, err error)
+}
+
+func New(conf *config.Chunk) (ChunkStore, error) {
+	switch conf.Backend {
+	case "", BackendLocal:
+		return NewLocalStore(conf.RootPath), nil
+	case BackendS3:
+		return NewS3Store(&conf.S3)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, conf.Backend)
+	}
+}
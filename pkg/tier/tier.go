@@ -0,0 +1,261 @@
+package tier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/database/models"
+	"medea/pkg/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrNoColdRoot    = errors.New("no cold chunk root is configured")
+	ErrInvalidPrefix = errors.New("path prefix must be given as appUid:/path")
+	ErrNoPolicy      = errors.New("neither idle days nor path prefixes are given")
+)
+
+type Options struct {
+	IdleDays     int
+	PathPrefixes []string
+	BatchSize    int
+	DryRun       bool
+	RootPath     *string
+}
+
+func NewOptions(conf *config.Tiering) Options {
+	return Options{
+		IdleDays:     conf.IdleDays,
+		PathPrefixes: conf.PathPrefixes,
+	}
+}
+
+type Failure struct {
+	ChunkID uint64
+	Detail  string
+}
+
+type Report struct {
+	DryRun     bool
+	Objects    int64
+	Chunks     int64
+	MovedBytes int64
+	// chunks shared with objects which stay hot, and packed chunks
+	Skipped  int64
+	Failures []Failure
+}
+
+type tiering struct {
+	db        *gorm.DB
+	opts      Options
+	cutoff    time.Time
+	coldRoots []*storage.Root
+	prefixed  map[uint64]bool
+	visited   map[uint64]bool
+	report    *Report
+}
+
+// Run moves the chunks of the objects matching the policy to cold roots, an
+// object matches if it isn't read for IdleDays, or if it belongs to a file
+// under PathPrefixes. A chunk shared by an object which doesn't match stays.
+func Run(db *gorm.DB, opts Options) (report *Report, err error) {
+	var roots []*storage.Root
+
+	if opts.IdleDays <= 0 && len(opts.PathPrefixes) == 0 {
+		return nil, ErrNoPolicy
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if roots, err = models.ChunkRoots(opts.RootPath); err != nil {
+		return nil, err
+	}
+
+	t := &tiering{
+		db:        db,
+		opts:      opts,
+		cutoff:    time.Now().AddDate(0, 0, -opts.IdleDays),
+		coldRoots: storage.TierRoots(roots, storage.TierCold),
+		prefixed:  make(map[uint64]bool),
+		visited:   make(map[uint64]bool),
+		report:    &Report{DryRun: opts.DryRun},
+	}
+	if len(t.coldRoots) == 0 {
+		return nil, ErrNoColdRoot
+	}
+	for _, prefix := range opts.PathPrefixes {
+		if err = t.markPrefix(prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	var lastID uint64
+	for {
+		var objects []models.Object
+		if err = db.Where("id > ?", lastID).Order("id").Limit(opts.BatchSize).Find(&objects).Error; err != nil {
+			return t.report, err
+		}
+		if len(objects) == 0 {
+			return t.report, nil
+		}
+		lastID = objects[len(objects)-1].ID
+		for index := range objects {
+			if !t.matches(&objects[index]) {
+				continue
+			}
+			t.report.Objects++
+			if err = t.moveObject(&objects[index]); err != nil {
+				return t.report, err
+			}
+		}
+	}
+}
+
+// markPrefix records the objects of files under prefix, including their
+// histories and the files in trash.
+func (t *tiering) markPrefix(prefix string) error {
+	var (
+		app *models.App
+		dir *models.File
+		err error
+	)
+
+	parts := strings.SplitN(prefix, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || !strings.HasPrefix(parts[1], "/") {
+		return fmt.Errorf("%w: %s", ErrInvalidPrefix, prefix)
+	}
+	if app, err = models.FindAppByUID(parts[0], t.db); err != nil {
+		return err
+	}
+	if strings.Trim(parts[1], "/") == "" {
+		dir, err = models.CreateOrGetRootPath(app, t.db)
+	} else {
+		dir, err = models.FindFileByPath(app, parts[1], t.db, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	for pending := []models.File{*dir}; len(pending) > 0; {
+		var (
+			file     = pending[0]
+			children []models.File
+		)
+		pending = pending[1:]
+
+		if file.IsDir != models.IsDir {
+			t.prefixed[file.ObjectID] = true
+			var objectIDs []uint64
+			if err = t.db.Model(&models.History{}).Where("fileId = ?", file.ID).Pluck("objectId", &objectIDs).Error; err != nil {
+				return err
+			}
+			for _, id := range objectIDs {
+				t.prefixed[id] = true
+			}
+			continue
+		}
+
+		if err = t.db.Unscoped().Where("pid = ?", file.ID).Find(&children).Error; err != nil {
+			return err
+		}
+		pending = append(pending, children...)
+	}
+	return nil
+}
+
+func (t *tiering) matches(object *models.Object) bool {
+	if t.prefixed[object.ID] {
+		return true
+	}
+	if t.opts.IdleDays <= 0 {
+		return false
+	}
+	return object.UpdatedAt.Before(t.cutoff) && (object.LastReadAt == nil || object.LastReadAt.Before(t.cutoff))
+}
+
+func (t *tiering) moveObject(object *models.Object) error {
+	chunks, err := object.OrderedChunks(t.db)
+	if err != nil {
+		return err
+	}
+
+	for index := range chunks {
+		chunk := &chunks[index]
+		if t.visited[chunk.ID] {
+			continue
+		}
+		t.visited[chunk.ID] = true
+
+		var sharing []models.Object
+		err = t.db.Joins("join object_chunk on object_chunk.objectId = objects.id and object_chunk.chunkId = ?", chunk.ID).
+			Find(&sharing).Error
+		if err != nil {
+			return err
+		}
+		if !t.allMatch(sharing) {
+			t.report.Skipped++
+			continue
+		}
+		if err = t.moveChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tiering) allMatch(objects []models.Object) bool {
+	for index := range objects {
+		if !t.matches(&objects[index]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tiering) moveChunk(chunk *models.Chunk) error {
+	var (
+		key     string
+		roots   []*storage.Root
+		targets []*storage.Root
+		moved   int64
+		err     error
+	)
+
+	if chunk.IsPacked() {
+		t.report.Skipped++
+		return nil
+	}
+	if roots, err = chunk.ReplicaRoots(t.opts.RootPath, t.db); err != nil {
+		return err
+	}
+	if storage.IsCold(roots) {
+		return nil
+	}
+	if key, err = chunk.Key(); err != nil {
+		t.fail(chunk, err)
+		return nil
+	}
+	if targets, err = storage.Place(key, t.coldRoots, models.ChunkReplicas()); err != nil {
+		return err
+	}
+
+	t.report.Chunks++
+	if t.opts.DryRun {
+		t.report.MovedBytes += int64(chunk.StoredSize)
+		return nil
+	}
+	if moved, err = chunk.MoveTo(targets, t.opts.RootPath, t.db); err != nil {
+		t.report.Chunks--
+		t.fail(chunk, err)
+	}
+	t.report.MovedBytes += moved
+	return nil
+}
+
+func (t *tiering) fail(chunk *models.Chunk, err error) {
+	t.report.Failures = append(t.report.Failures, Failure{ChunkID: chunk.ID, Detail: err.Error()})
+}
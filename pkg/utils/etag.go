@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"strings"
+)
+
+// ETag quotes hash as a strong entity tag.
+func ETag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// MatchETag tells whether any entity tag listed by header, such as the value
+// of If-Match or If-None-Match, matches etag. "*" matches anything, whether
+// there is something at all is up to the caller. Weak comparison ignores the
+// W/ prefix, strong comparison never matches a weak tag.
+func MatchETag(header, etag string, weak bool) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(tag, "W/") || strings.HasPrefix(etag, "W/") {
+			continue
+		}
+		if tag != "" && tag == etag {
+			return true
+		}
+	}
+	return false
+}
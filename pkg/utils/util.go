@@ -4,6 +4,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"hash"
+	"io"
 	"os"
 	"reflect"
 
@@ -81,3 +83,48 @@ func InTransaction(db *gorm.DB) bool {
 	}
 	return reflect.ValueOf(db).Elem().FieldByName("db").Elem().Type().String() == "*sql.Tx"
 }
+
+type DigestReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int
+}
+
+func NewDigestReader(reader io.Reader) *DigestReader {
+	return &DigestReader{reader: reader, hash: sha256.New()}
+}
+
+func (d *DigestReader) Read(p []byte) (n int, err error) {
+	n, err = d.reader.Read(p)
+	d.size += n
+	_, _ = d.hash.Write(p[:n])
+	return n, err
+}
+
+func (d *DigestReader) Size() int {
+	return d.size
+}
+
+func (d *DigestReader) Hash() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// LimitedReader reads from reader until more than limit bytes are read, err is
+// returned then.
+type LimitedReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func NewLimitedReader(reader io.Reader, limit int64, err error) *LimitedReader {
+	return &LimitedReader{reader: reader, remaining: limit, err: err}
+}
+
+func (l *LimitedReader) Read(p []byte) (n int, err error) {
+	n, err = l.reader.Read(p)
+	if l.remaining -= int64(n); l.remaining < 0 {
+		return n, l.err
+	}
+	return n, err
+}
@@ -2,8 +2,10 @@ package app
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"medea/pkg/config"
 	"medea/pkg/database"
@@ -141,9 +143,10 @@ var Commands = []*cli.Command{
 				page    = ctx.Uint("page")
 				size    = ctx.Uint("size")
 				del     = ctx.Bool("delete")
-				headers = []string{"ID", "UID", "Secret", "Name", "Note", "CreatedAt"}
+				headers = []string{"ID", "UID", "Secret", "Name", "Note", "CreatedAt", "Bytes", "Files"}
 				data    []string
 				apps    []models.App
+				db      = connection
 			)
 			if page < 1 || size < 1 {
 				return errors.New("page and size must be greater than 0")
@@ -160,6 +163,10 @@ var Commands = []*cli.Command{
 				if app.Note != nil {
 					note = *app.Note
 				}
+				usage, err := app.Usage(db)
+				if err != nil {
+					return err
+				}
 				data = []string{
 					strconv.FormatUint(app.ID, 10),
 					app.UID,
@@ -167,6 +174,8 @@ var Commands = []*cli.Command{
 					app.Name,
 					note,
 					app.CreatedAt.Format("2000-01-01 15:15:15"),
+					formatUsage(usage.Bytes, usage.QuotaBytes),
+					formatUsage(usage.Files, usage.QuotaFiles),
 				}
 				if del {
 					if app.DeletedAt != nil {
@@ -181,4 +190,132 @@ var Commands = []*cli.Command{
 			return nil
 		},
 	},
+	{
+		Name:      "app:quota",
+		Category:  category,
+		Usage:     "set the storage quota of an application",
+		UsageText: "app:quota [command options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "uid",
+				Aliases: []string{"u"},
+				Usage:   "application uid",
+			},
+			&cli.Int64Flag{
+				Name:  "bytes",
+				Usage: "max logical bytes of the files, 0 is unlimited",
+			},
+			&cli.Int64Flag{
+				Name:  "files",
+				Usage: "max number of the files, 0 is unlimited",
+			},
+		},
+		Before: before,
+		Action: func(ctx *cli.Context) error {
+			var (
+				uid   = ctx.String("uid")
+				bytes = ctx.Int64("bytes")
+				files = ctx.Int64("files")
+				app   *models.App
+				err   error
+			)
+			if len(uid) == 0 {
+				return errors.New("uid is empty")
+			}
+			if bytes < 0 || files < 0 {
+				return errors.New("bytes and files must be greater than or equal to 0")
+			}
+			if app, err = models.FindAppByUID(uid, connection); err != nil {
+				return err
+			}
+			if err = app.SetQuota(bytes, files, connection); err != nil {
+				return err
+			}
+			logger.Infof("set quota of application %s: %d bytes, %d files", uid, bytes, files)
+			return nil
+		},
+	},
+	{
+		Name:      "app:ratelimit",
+		Category:  category,
+		Usage:     "set the bandwidth limit of an application",
+		UsageText: "app:ratelimit [command options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "uid",
+				Aliases: []string{"u"},
+				Usage:   "application uid",
+			},
+			&cli.Int64Flag{
+				Name:  "read",
+				Usage: "max bytes per second read, 0 is unlimited",
+			},
+			&cli.Int64Flag{
+				Name:  "write",
+				Usage: "max bytes per second written, 0 is unlimited",
+			},
+			&cli.StringSliceFlag{
+				Name:  "schedule",
+				Usage: "rates during a time of day as from-to/read/write, such as 22:00-06:00/0/0",
+			},
+		},
+		Before: before,
+		Action: func(ctx *cli.Context) error {
+			var (
+				uid   = ctx.String("uid")
+				limit = &config.RateLimit{Read: ctx.Int64("read"), Write: ctx.Int64("write")}
+				app   *models.App
+				err   error
+			)
+			if len(uid) == 0 {
+				return errors.New("uid is empty")
+			}
+			for _, text := range ctx.StringSlice("schedule") {
+				schedule, err := parseRateSchedule(text)
+				if err != nil {
+					return err
+				}
+				limit.Schedules = append(limit.Schedules, schedule)
+			}
+			if err = limit.Validate(); err != nil {
+				return err
+			}
+			if app, err = models.FindAppByUID(uid, connection); err != nil {
+				return err
+			}
+			if err = app.SetRateLimit(limit, connection); err != nil {
+				return err
+			}
+			logger.Infof("set rate limit of application %s: read %d, write %d bytes per second, %d schedules", uid, limit.Read, limit.Write, len(limit.Schedules))
+			return nil
+		},
+	},
+}
+
+// parseRateSchedule parses a schedule given as from-to/read/write.
+func parseRateSchedule(text string) (schedule config.RateSchedule, err error) {
+	var (
+		parts  = strings.Split(text, "/")
+		window []string
+	)
+	if len(parts) != 3 {
+		return schedule, fmt.Errorf("schedule %s isn't like from-to/read/write", text)
+	}
+	if window = strings.Split(parts[0], "-"); len(window) != 2 {
+		return schedule, fmt.Errorf("schedule %s isn't like from-to/read/write", text)
+	}
+	schedule.From, schedule.To = window[0], window[1]
+	if schedule.Read, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return schedule, err
+	}
+	schedule.Write, err = strconv.ParseInt(parts[2], 10, 64)
+	return schedule, err
+}
+
+// formatUsage formats used against quota, a quota of 0 is unlimited.
+func formatUsage(used, quota int64) string {
+	if quota <= 0 {
+		return strconv.FormatInt(used, 10)
+	}
+	return strconv.FormatInt(used, 10) + "/" + strconv.FormatInt(quota, 10)
 }
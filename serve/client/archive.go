@@ -0,0 +1,201 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"medea/pkg/http"
+	libHttp "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errUnsafeArchivePath = errors.New("archive entry escapes the dst directory")
+
+// directory_archive reads a directory as an archive and extracts it into dst,
+// tar is extracted while it is downloaded, zip needs a temp file since its
+// index is at the end.
+func directory_archive(val map[string]string) error {
+	format := val["archive"]
+	if format != "zip" && format != "tar" && format != "tar.gz" {
+		return fmt.Errorf("unknown archive format %s, only zip, tar and tar.gz", format)
+	}
+
+	params := map[string]interface{}{
+		"token":  val["token"],
+		"format": format,
+		"nonce":  RandomWithMD56(333),
+	}
+	if len(val["path"]) > 0 {
+		params["subDir"] = val["path"]
+	}
+	if len(val["include"]) > 0 {
+		params["include"] = val["include"]
+	}
+	if len(val["exclude"]) > 0 {
+		params["exclude"] = val["exclude"]
+	}
+	if val["hidden"] == "1" {
+		params["hidden"] = "1"
+	}
+
+	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/directory/archive")
+	url := fmt.Sprintf("%s?%s", api, http.GetParamsSignBody(params, val["secret"]))
+
+	start := time.Now()
+	request, err := libHttp.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Forwarded-For", val["host"])
+	resp, err := libHttp.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != libHttp.StatusOK {
+		p := &http.Response{}
+		if err = json.NewDecoder(resp.Body).Decode(p); err != nil {
+			return fmt.Errorf("request failed: %s", resp.Status)
+		}
+		return fmt.Errorf("request failed: %v", p.Errors)
+	}
+
+	dst := val["dst"]
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	var count int
+	switch format {
+	case "zip":
+		count, err = extract_zip(resp.Body, dst)
+	case "tar":
+		count, err = extract_tar(resp.Body, dst)
+	case "tar.gz":
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(resp.Body); err != nil {
+			return err
+		}
+		count, err = extract_tar(gr, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	timeStr := time.Now().Format("2006-01-02 15:04:05")
+	fmt.Printf("%v (%v) - %v files extracted to '%v'\n", timeStr, time.Since(start).Round(time.Millisecond), count, dst)
+	return nil
+}
+
+// archive_target returns where the entry name is extracted under dst, names
+// which would land outside of dst are refused.
+func archive_target(dst, name string) (string, error) {
+	target := filepath.Join(dst, filepath.FromSlash(name))
+	if target != filepath.Clean(dst) && !strings.HasPrefix(target, filepath.Clean(dst)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%w: %s", errUnsafeArchivePath, name)
+	}
+	return target, nil
+}
+
+func extract_file(target string, reader io.Reader, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+func extract_tar(reader io.Reader, dst string) (int, error) {
+	var (
+		count int
+		tr    = tar.NewReader(reader)
+	)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		target, err := archive_target(dst, header.Name)
+		if err != nil {
+			return count, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			if err = extract_file(target, tr, header.ModTime); err == nil {
+				count++
+			}
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+func extract_zip(reader io.Reader, dst string) (int, error) {
+	temp, err := ioutil.TempFile("", "medea-archive-*.zip")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	size, err := io.Copy(temp, reader)
+	if err != nil {
+		return 0, err
+	}
+	zr, err := zip.NewReader(temp, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, entry := range zr.File {
+		target, err := archive_target(dst, entry.Name)
+		if err != nil {
+			return count, err
+		}
+		if entry.FileInfo().IsDir() {
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return count, err
+			}
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return count, err
+		}
+		err = extract_file(target, rc, entry.Modified)
+		_ = rc.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"medea/pkg/log"
+	"strconv"
 
 	"gopkg.in/urfave/cli.v2"
 
@@ -79,6 +80,23 @@ var (
 					Name:  "src",
 					Usage: "file src path",
 				},
+				&cli.IntFlag{
+					Name:  "parallel",
+					Usage: "number of parts uploaded concurrently",
+					Value: 4,
+				},
+				&cli.StringFlag{
+					Name:  "upload",
+					Usage: "resume the upload session with this id",
+				},
+				&cli.BoolFlag{
+					Name:  "stream",
+					Usage: "upload the whole file in one streaming request",
+				},
+				&cli.BoolFlag{
+					Name:  "dedup",
+					Usage: "upload only the parts whose chunks aren't stored yet",
+				},
 				&cli.StringFlag{
 					Name:  "host",
 					Usage: "app host allow",
@@ -86,11 +104,19 @@ var (
 			},
 			Action: func(context *cli.Context) error {
 				val := map[string]string{
-					"token":  context.String("token"),
-					"secret": context.String("secret"),
-					"path":   context.String("path"),
-					"src":    context.String("src"),
-					"host":   context.String("host"),
+					"token":    context.String("token"),
+					"secret":   context.String("secret"),
+					"path":     context.String("path"),
+					"src":      context.String("src"),
+					"host":     context.String("host"),
+					"parallel": strconv.Itoa(context.Int("parallel")),
+					"upload":   context.String("upload"),
+				}
+				if context.Bool("stream") {
+					val["stream"] = "1"
+				}
+				if context.Bool("dedup") {
+					val["dedup"] = "1"
 				}
 				if len(val["token"]) == 0 {
 					logger.Error("access token is empty \n")
@@ -149,15 +175,42 @@ var (
 					Name:  "range",
 					Usage: "range concurrence",
 				},
+				&cli.StringFlag{
+					Name:  "archive",
+					Usage: "read the directory of path as zip, tar or tar.gz and extract it into dst",
+				},
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "path of the file read instead of uid, or the directory to archive",
+				},
+				&cli.StringFlag{
+					Name:  "include",
+					Usage: "comma separated glob patterns of files to archive",
+				},
+				&cli.StringFlag{
+					Name:  "exclude",
+					Usage: "comma separated glob patterns of files to leave out",
+				},
+				&cli.BoolFlag{
+					Name:  "hidden",
+					Usage: "archive hidden files too",
+				},
 			},
 			Action: func(context *cli.Context) error {
 				val := map[string]string{
-					"token":  context.String("token"),
-					"secret": context.String("secret"),
-					"uid":    context.String("uid"),
-					"dst":    context.String("dst"),
-					"host":   context.String("host"),
-					"range":  context.String("range"),
+					"token":   context.String("token"),
+					"secret":  context.String("secret"),
+					"uid":     context.String("uid"),
+					"dst":     context.String("dst"),
+					"host":    context.String("host"),
+					"range":   context.String("range"),
+					"archive": context.String("archive"),
+					"path":    context.String("path"),
+					"include": context.String("include"),
+					"exclude": context.String("exclude"),
+				}
+				if context.Bool("hidden") {
+					val["hidden"] = "1"
 				}
 				if len(val["token"]) == 0 {
 					logger.Error("app token is empty \n")
@@ -165,8 +218,8 @@ var (
 				if len(val["secret"]) == 0 {
 					logger.Error("app secret is empty \n")
 				}
-				if len(val["uid"]) == 0 {
-					logger.Error("file uid is empty \n")
+				if len(val["uid"]) == 0 && len(val["path"]) == 0 {
+					logger.Error("file uid and path are empty \n")
 				}
 				if len(val["dst"]) == 0 {
 					logger.Error("local dst is empty \n")
@@ -177,6 +230,13 @@ var (
 					val["host"] = medeaHost
 				}
 
+				if len(val["archive"]) > 0 {
+					if err := directory_archive(val); err != nil {
+						fmt.Println("directory archive failed", err)
+					}
+					return nil
+				}
+
 				if err := file_read(val); err != nil {
 					fmt.Println("file read failed", err)
 				}
@@ -201,6 +261,10 @@ var (
 					Name:  "uid",
 					Usage: "file uid",
 				},
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "file path instead of uid",
+				},
 				&cli.StringFlag{
 					Name:  "host",
 					Usage: "app host allow",
@@ -211,6 +275,7 @@ var (
 					"token":  context.String("token"),
 					"secret": context.String("secret"),
 					"uid":    context.String("uid"),
+					"path":   context.String("path"),
 					"host":   context.String("host"),
 				}
 				if len(val["token"]) == 0 {
@@ -219,8 +284,8 @@ var (
 				if len(val["secret"]) == 0 {
 					logger.Error("app secret is empty \n")
 				}
-				if len(val["uid"]) == 0 {
-					logger.Error("file uid is empty \n")
+				if len(val["uid"]) == 0 && len(val["path"]) == 0 {
+					logger.Error("file uid and path are empty \n")
 				}
 
 				globalEnvironmentUpdate()
@@ -248,6 +313,10 @@ var (
 					Name:  "secret",
 					Usage: "access secret",
 				},
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "directory listed, the root of token by default",
+				},
 				&cli.StringFlag{
 					Name:  "host",
 					Usage: "app host allow",
@@ -257,6 +326,7 @@ var (
 				val := map[string]string{
 					"token":  context.String("token"),
 					"secret": context.String("secret"),
+					"path":   context.String("path"),
 					"host":   context.String("host"),
 				}
 				if len(val["token"]) == 0 {
@@ -277,6 +347,256 @@ var (
 				return nil
 			},
 		},
+		{
+			Name:      "client:copy",
+			Category:  category,
+			Usage:     "client copy",
+			UsageText: "client:copy",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "token",
+					Usage: "access token",
+				},
+				&cli.StringFlag{
+					Name:  "secret",
+					Usage: "access secret",
+				},
+				&cli.StringFlag{
+					Name:  "uid",
+					Usage: "uid of the file or directory copied",
+				},
+				&cli.StringFlag{
+					Name:  "file-path",
+					Usage: "path of the file or directory copied instead of uid",
+				},
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "path of the copy",
+				},
+				&cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "overwrite the files at the path of the copy",
+				},
+				&cli.BoolFlag{
+					Name:  "rename",
+					Usage: "rename the copy if its path is taken",
+				},
+				&cli.StringFlag{
+					Name:  "dst-token",
+					Usage: "access token of the app copied to, the app of token by default",
+				},
+				&cli.StringFlag{
+					Name:  "dst-secret",
+					Usage: "access secret of dst-token",
+				},
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "app host allow",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				val := map[string]string{
+					"token":     context.String("token"),
+					"secret":    context.String("secret"),
+					"uid":       context.String("uid"),
+					"filePath":  context.String("file-path"),
+					"path":      context.String("path"),
+					"dstToken":  context.String("dst-token"),
+					"dstSecret": context.String("dst-secret"),
+					"host":      context.String("host"),
+				}
+				if context.Bool("overwrite") {
+					val["overwrite"] = "1"
+				}
+				if context.Bool("rename") {
+					val["rename"] = "1"
+				}
+				if len(val["token"]) == 0 {
+					logger.Error("access token is empty \n")
+				}
+				if len(val["uid"]) == 0 && len(val["filePath"]) == 0 {
+					logger.Error("file uid and path are empty \n")
+				}
+				if len(val["path"]) == 0 {
+					logger.Error("copy path is empty \n")
+				}
+
+				globalEnvironmentUpdate()
+				if len(val["host"]) == 0 {
+					val["host"] = medeaHost
+				}
+
+				if err := file_copy(val); err != nil {
+					fmt.Println("file copy failed", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "client:compose",
+			Category:  category,
+			Usage:     "client compose",
+			UsageText: "client:compose",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "token",
+					Usage: "access token",
+				},
+				&cli.StringFlag{
+					Name:  "secret",
+					Usage: "access secret",
+				},
+				&cli.StringFlag{
+					Name:  "uids",
+					Usage: "uids of the files composed in order, comma separated",
+				},
+				&cli.StringFlag{
+					Name:  "paths",
+					Usage: "paths of the files composed in order instead of uids, comma separated",
+				},
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "path of the composed file",
+				},
+				&cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "overwrite the file at the path",
+				},
+				&cli.BoolFlag{
+					Name:  "rename",
+					Usage: "rename the composed file if its path is taken",
+				},
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "app host allow",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				val := map[string]string{
+					"token":  context.String("token"),
+					"secret": context.String("secret"),
+					"uids":   context.String("uids"),
+					"paths":  context.String("paths"),
+					"path":   context.String("path"),
+					"host":   context.String("host"),
+				}
+				if context.Bool("overwrite") {
+					val["overwrite"] = "1"
+				}
+				if context.Bool("rename") {
+					val["rename"] = "1"
+				}
+				if len(val["token"]) == 0 {
+					logger.Error("access token is empty \n")
+				}
+				if len(val["uids"]) == 0 && len(val["paths"]) == 0 {
+					logger.Error("file uids and paths are empty \n")
+				}
+				if len(val["path"]) == 0 {
+					logger.Error("compose path is empty \n")
+				}
+
+				globalEnvironmentUpdate()
+				if len(val["host"]) == 0 {
+					val["host"] = medeaHost
+				}
+
+				if err := file_compose(val); err != nil {
+					fmt.Println("file compose failed", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "client:presign",
+			Category:  category,
+			Usage:     "client presign",
+			UsageText: "client:presign",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "token",
+					Usage: "access token signing the url",
+				},
+				&cli.StringFlag{
+					Name:  "app",
+					Usage: "app uid signing the url instead of token",
+				},
+				&cli.StringFlag{
+					Name:  "secret",
+					Usage: "secret of the token or the app",
+				},
+				&cli.StringFlag{
+					Name:  "uid",
+					Usage: "uid of the file to download",
+				},
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "path of the file to upload",
+				},
+				&cli.StringFlag{
+					Name:  "expires",
+					Usage: "how long the url is valid, such as 30m",
+					Value: "1h",
+				},
+				&cli.StringFlag{
+					Name:  "ip",
+					Usage: "ip or CIDR allowed to use the url",
+				},
+				&cli.StringFlag{
+					Name:  "max-bytes",
+					Usage: "max size of the file downloaded or uploaded",
+				},
+				&cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "overwrite the file at the path",
+				},
+				&cli.BoolFlag{
+					Name:  "rename",
+					Usage: "rename the uploaded file if its path is taken",
+				},
+				&cli.BoolFlag{
+					Name:  "hidden",
+					Usage: "upload as a hidden file",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				val := map[string]string{
+					"token":    context.String("token"),
+					"app":      context.String("app"),
+					"secret":   context.String("secret"),
+					"uid":      context.String("uid"),
+					"path":     context.String("path"),
+					"expires":  context.String("expires"),
+					"ip":       context.String("ip"),
+					"maxBytes": context.String("max-bytes"),
+				}
+				if context.Bool("overwrite") {
+					val["overwrite"] = "1"
+				}
+				if context.Bool("rename") {
+					val["rename"] = "1"
+				}
+				if context.Bool("hidden") {
+					val["hidden"] = "1"
+				}
+				if len(val["token"]) == 0 && len(val["app"]) == 0 {
+					logger.Error("access token or app uid is empty \n")
+				}
+				if len(val["secret"]) == 0 {
+					logger.Error("secret is empty \n")
+				}
+				if len(val["uid"]) == 0 && len(val["path"]) == 0 {
+					logger.Error("file uid or upload path is empty \n")
+				}
+
+				globalEnvironmentUpdate()
+
+				if err := presign(val); err != nil {
+					fmt.Println("presign failed", err)
+				}
+				return nil
+			},
+		},
 		{
 			Name:      "client:env",
 			Category:  category,
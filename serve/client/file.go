@@ -1,17 +1,19 @@
 package client
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"medea/pkg/database/models"
 	"medea/pkg/http"
-	"mime/multipart"
+	"medea/pkg/utils"
 	libHttp "net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,105 +21,197 @@ type fileMetaConfig struct {
 	token  string
 	secret string
 	uid    string
+	path   string
 	host   string
 	dst    string
 }
 
+// file_params adds the file of uid to params, or else its path as pathKey.
+func file_params(params map[string]interface{}, uid, path, pathKey string) map[string]interface{} {
+	if len(uid) > 0 {
+		params["fileUid"] = uid
+	} else {
+		params[pathKey] = path
+	}
+	return params
+}
+
 func file_create(val map[string]string) error {
 	token := val["token"]
 	secret := val["secret"]
 	path := val["path"]
 	src := val["src"]
 	host := val["host"]
+	uploadId := val["upload"]
+
+	if val["stream"] == "1" {
+		return file_stream_create(val)
+	}
+
+	parallel, err := strconv.Atoi(val["parallel"])
+	if err != nil || parallel < 1 {
+		parallel = 4
+	}
 
 	file, err := os.Open(src)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	count := 0
-	for index := 0; ; index++ {
-		var (
-			err            error
-			body           = new(bytes.Buffer)
-			chunk          = make([]byte, models.ChunkSize)
-			request        *libHttp.Request
-			readCount      int
-			formBodyWriter = multipart.NewWriter(body)
-			formFileWriter io.Writer
-		)
+	c := &uploadConfig{token: token, secret: secret, host: host}
 
-		if readCount, err = file.Read(chunk); err != nil {
-			if err == io.EOF {
-				break
-			}
+	if len(uploadId) == 0 {
+		if uploadId, err = c.upload_initiate(path, true); err != nil {
 			return err
 		}
+	}
+	fmt.Printf("upload id: %s\n", uploadId)
 
-		params := map[string]interface{}{
-			"token": token,
-			"path":  path,
-			"nonce": models.RandomWithMD5(255),
-		}
+	received, err := c.upload_parts(uploadId)
+	if err != nil {
+		return err
+	}
 
-		if index == 0 {
-			params["overwrite"] = "1"
-		} else {
-			params["append"] = "1"
+	var missing map[int]bool
+	if val["dedup"] == "1" {
+		if missing, err = negotiate_parts(c, uploadId, file); err != nil {
+			return err
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
 		}
+	}
+
+	var (
+		count   int
+		skipped int
+		jobs    = make(chan uploadPartJob, parallel)
+		done    = make(chan error, 1)
+		readErr error
+	)
 
-		params["sign"] = http.GetParamsSignature(params, secret)
-		for k, v := range params {
-			if err = formBodyWriter.WriteField(k, v.(string)); err != nil {
-				return err
+	go func() {
+		done <- c.upload_missing_parts(uploadId, jobs, parallel)
+	}()
+
+	for number := 1; ; number++ {
+		var (
+			chunk     = make([]byte, models.ChunkSize)
+			readCount int
+		)
+		if readCount, readErr = io.ReadFull(file, chunk); readErr != nil {
+			if readErr == io.EOF {
+				readErr = nil
+				break
 			}
+			if readErr != io.ErrUnexpectedEOF {
+				break
+			}
+			readErr = nil
 		}
 
-		if formFileWriter, err = formBodyWriter.CreateFormFile("file", "random.bytes"); err != nil {
-			return err
+		hash, _ := utils.Sha256Hash2String(chunk[:readCount])
+		count++
+		if received[number] == hash || (missing != nil && !missing[number]) {
+			skipped++
+			continue
 		}
+		jobs <- uploadPartJob{number: number, content: chunk[:readCount], hash: hash}
+	}
+	close(jobs)
 
-		if _, err = formFileWriter.Write(chunk[:readCount]); err != nil {
-			return err
-		}
+	if err = <-done; err != nil {
+		fmt.Printf("upload is interrupted, it can be resumed by --upload %s\n", uploadId)
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
 
-		if err = formBodyWriter.Close(); err != nil {
-			return err
-		}
+	p, err := c.upload_complete(uploadId)
+	if err != nil {
+		return err
+	}
 
-		api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/create")
-		if request, err = libHttp.NewRequest(libHttp.MethodPost, api, body); err != nil {
-			return err
-		}
+	resp2, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println("resp:\n", string(resp2))
+	fmt.Printf("finished %d partitions upload, %d partitions have already been uploaded\n", count, skipped)
 
-		request.Header.Set("Content-Type", formBodyWriter.FormDataContentType())
-		request.Header.Set("X-Forwarded-For", host)
-		resp, err := libHttp.DefaultClient.Do(request)
-		if err != nil {
-			return err
+	return nil
+}
+
+// negotiate_parts hashes file by parts and negotiates them with the server.
+func negotiate_parts(c *uploadConfig, uploadId string, file io.Reader) (map[int]bool, error) {
+	var (
+		hashes []string
+		sizes  []string
+		chunk  = make([]byte, models.ChunkSize)
+	)
+	for {
+		readCount, err := io.ReadFull(file, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
 		}
+		hash, _ := utils.Sha256Hash2String(chunk[:readCount])
+		hashes = append(hashes, hash)
+		sizes = append(sizes, strconv.Itoa(readCount))
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	return c.upload_negotiate(uploadId, hashes, sizes)
+}
 
-		if bodyBytes, err := ioutil.ReadAll(resp.Body); err != nil {
-			return err
-		} else {
-			p := &http.Response{}
-			err := json.Unmarshal(bodyBytes, p)
-			if err != nil {
-				return err
-			}
+func file_stream_create(val map[string]string) error {
+	file, err := os.Open(val["src"])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-			resp2, err := json.MarshalIndent(p, "", "    ")
-			if err != nil {
-				return err
-			}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
 
-			fmt.Println("resp:\n", string(resp2))
-		}
+	qs := http.GetParamsSignBody(map[string]interface{}{
+		"token":     val["token"],
+		"path":      val["path"],
+		"overwrite": "1",
+		"size":      strconv.FormatInt(size, 10),
+		"hash":      hex.EncodeToString(hash.Sum(nil)),
+		"nonce":     models.RandomWithMD5(255),
+	}, val["secret"])
+
+	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/create")
+	request, err := libHttp.NewRequest(libHttp.MethodPut, fmt.Sprintf("%s?%s", api, qs), file)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", "application/octet-stream")
 
-		count++
+	p, err := do_request(request, val["host"])
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("finished %d partitions upload\n", count)
+	resp, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println("resp:\n", string(resp))
 
 	return nil
 }
@@ -125,14 +219,12 @@ func file_create(val map[string]string) error {
 func file_info(val map[string]string) error {
 	token := val["token"]
 	secret := val["secret"]
-	uid := val["uid"]
 	host := val["host"]
 
-	qs := http.GetParamsSignBody(map[string]interface{}{
-		"token":   token,
-		"fileUid": uid,
-		"nonce":   RandomWithMD56(333),
-	}, secret)
+	qs := http.GetParamsSignBody(file_params(map[string]interface{}{
+		"token": token,
+		"nonce": RandomWithMD56(333),
+	}, val["uid"], val["path"], "path"), secret)
 
 	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/info")
 
@@ -175,14 +267,14 @@ func file_read(val map[string]string) error {
 		token:  token,
 		secret: secret,
 		uid:    uid,
+		path:   val["path"],
 		host:   host,
 	}
 
-	qs := http.GetParamsSignBody(map[string]interface{}{
-		"token":   token,
-		"fileUid": uid,
-		"nonce":   RandomWithMD56(333),
-	}, secret)
+	qs := http.GetParamsSignBody(file_params(map[string]interface{}{
+		"token": token,
+		"nonce": RandomWithMD56(333),
+	}, uid, meta.path, "path"), secret)
 
 	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/read")
 	url := fmt.Sprintf("%s?%s", api, qs)
@@ -241,10 +333,14 @@ func file_list(val map[string]string) error {
 
 	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/directory/list")
 
-	qs := http.GetParamsSignBody(map[string]interface{}{
+	params := map[string]interface{}{
 		"token": token,
 		"nonce": RandomWithMD56(333),
-	}, secret)
+	}
+	if len(val["path"]) > 0 {
+		params["subDir"] = val["path"]
+	}
+	qs := http.GetParamsSignBody(params, secret)
 
 	request, err := libHttp.NewRequest("GET", fmt.Sprintf("%s?%s", api, qs), nil)
 	if err != nil {
@@ -275,3 +371,86 @@ func file_list(val map[string]string) error {
 
 	return nil
 }
+
+func file_copy(val map[string]string) error {
+	token := val["token"]
+	secret := val["secret"]
+	host := val["host"]
+	dstToken := val["dstToken"]
+
+	params := file_params(map[string]interface{}{
+		"token": token,
+		"path":  val["path"],
+		"nonce": RandomWithMD56(333),
+	}, val["uid"], val["filePath"], "filePath")
+	if val["overwrite"] == "1" {
+		params["overwrite"] = "1"
+	}
+	if val["rename"] == "1" {
+		params["rename"] = "1"
+	}
+	// copying to another app is signed by the destination token too
+	if len(dstToken) > 0 {
+		params["dstToken"] = dstToken
+		params["dstSign"] = http.GetParamsSignature(params, val["dstSecret"])
+	}
+
+	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/copy")
+	request, err := libHttp.NewRequest("POST", api, strings.NewReader(http.GetParamsSignBody(params, secret)))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	p, err := do_request(request, host)
+	if err != nil {
+		return err
+	}
+
+	resp, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println("resp:\n", string(resp))
+
+	return nil
+}
+
+func file_compose(val map[string]string) error {
+	params := map[string]interface{}{
+		"token": val["token"],
+		"path":  val["path"],
+		"nonce": RandomWithMD56(333),
+	}
+	if len(val["uids"]) > 0 {
+		params["fileUids"] = val["uids"]
+	} else {
+		params["paths"] = val["paths"]
+	}
+	if val["overwrite"] == "1" {
+		params["overwrite"] = "1"
+	}
+	if val["rename"] == "1" {
+		params["rename"] = "1"
+	}
+
+	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/compose")
+	request, err := libHttp.NewRequest("POST", api, strings.NewReader(http.GetParamsSignBody(params, val["secret"])))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	p, err := do_request(request, val["host"])
+	if err != nil {
+		return err
+	}
+
+	resp, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println("resp:\n", string(resp))
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"medea/pkg/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// presign prints a url for downloading the file of uid, or for uploading to
+// path by PUT, which is signed locally and needs no request to the server.
+func presign(val map[string]string) error {
+	expires, err := time.ParseDuration(val["expires"])
+	if err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{
+		"expires": strconv.FormatInt(time.Now().Add(expires).Unix(), 10),
+	}
+	if len(val["token"]) > 0 {
+		params["token"] = val["token"]
+	} else {
+		params["appUid"] = val["app"]
+	}
+
+	switch {
+	case len(val["uid"]) > 0:
+		params["method"] = "GET"
+		params["fileUid"] = val["uid"]
+	case len(val["path"]) > 0:
+		params["method"] = "PUT"
+		params["path"] = val["path"]
+		for _, key := range []string{"overwrite", "rename", "hidden"} {
+			if val[key] == "1" {
+				params[key] = "1"
+			}
+		}
+	default:
+		return errors.New("either uid or path is required")
+	}
+
+	for _, key := range []string{"ip", "maxBytes"} {
+		if len(val[key]) > 0 {
+			params[key] = val[key]
+		}
+	}
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value.(string))
+	}
+	query.Set("sign", http.GetParamsSignature(params, val["secret"]))
+
+	fmt.Printf("%s %s/%s?%s\n", params["method"], medeaServer, "api/medea/presigned/file", query.Encode())
+	return nil
+}
@@ -194,14 +194,12 @@ func (r *RangeDownloader) mergeFileParts() error {
 func (r *RangeDownloader) getHeaderInfo(meta *fileMetaConfig) (int, error) {
 	token := meta.token
 	secret := meta.secret
-	uid := meta.uid
 	host := meta.host
 
-	qs := http.GetParamsSignBody(map[string]interface{}{
-		"token":   token,
-		"fileUid": uid,
-		"nonce":   RandomWithMD56(333),
-	}, secret)
+	qs := http.GetParamsSignBody(file_params(map[string]interface{}{
+		"token": token,
+		"nonce": RandomWithMD56(333),
+	}, meta.uid, meta.path, "path"), secret)
 
 	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/file/info")
 
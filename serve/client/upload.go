@@ -0,0 +1,219 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"medea/pkg/database/models"
+	"medea/pkg/http"
+	"mime/multipart"
+	libHttp "net/http"
+	"strings"
+	"sync"
+)
+
+const uploadPartRetryTimes = 3
+
+type uploadConfig struct {
+	token  string
+	secret string
+	host   string
+}
+
+func do_request(request *libHttp.Request, host string) (*http.Response, error) {
+	request.Header.Set("X-Forwarded-For", host)
+	resp, err := libHttp.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &http.Response{}
+	if err = json.Unmarshal(bodyBytes, p); err != nil {
+		return nil, err
+	}
+
+	if !p.Success {
+		return p, fmt.Errorf("request failed: %v", p.Errors)
+	}
+
+	return p, nil
+}
+
+func (c *uploadConfig) form_request(method, service string, params map[string]interface{}) (*http.Response, error) {
+	params["token"] = c.token
+	params["nonce"] = models.RandomWithMD5(255)
+	body := http.GetParamsSignBody(params, c.secret)
+	api := fmt.Sprintf("%s/%s", medeaServer, service)
+
+	var (
+		request *libHttp.Request
+		err     error
+	)
+	if method == libHttp.MethodGet || method == libHttp.MethodDelete {
+		request, err = libHttp.NewRequest(method, fmt.Sprintf("%s?%s", api, body), nil)
+	} else {
+		request, err = libHttp.NewRequest(method, api, strings.NewReader(body))
+		if request != nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return do_request(request, c.host)
+}
+
+func (c *uploadConfig) upload_initiate(path string, overwrite bool) (string, error) {
+	params := map[string]interface{}{
+		"path": path,
+	}
+	if overwrite {
+		params["overwrite"] = "1"
+	}
+
+	p, err := c.form_request(libHttp.MethodPost, "api/medea/upload/initiate", params)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Data.(map[string]interface{})["uploadId"].(string), nil
+}
+
+func (c *uploadConfig) upload_parts(uploadId string) (map[int]string, error) {
+	p, err := c.form_request(libHttp.MethodGet, "api/medea/upload/parts", map[string]interface{}{
+		"uploadId": uploadId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	received := make(map[int]string)
+	for _, item := range p.Data.(map[string]interface{})["parts"].([]interface{}) {
+		part := item.(map[string]interface{})
+		received[int(part["number"].(float64))] = part["hash"].(string)
+	}
+
+	return received, nil
+}
+
+// upload_negotiate sends the hashes and sizes of all parts, the parts whose
+// chunks are stored already are taken by the server, the missing ones are
+// returned.
+func (c *uploadConfig) upload_negotiate(uploadId string, hashes []string, sizes []string) (map[int]bool, error) {
+	p, err := c.form_request(libHttp.MethodPost, "api/medea/upload/negotiate", map[string]interface{}{
+		"uploadId": uploadId,
+		"hashes":   strings.Join(hashes, ","),
+		"sizes":    strings.Join(sizes, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[int]bool)
+	for _, number := range p.Data.(map[string]interface{})["missing"].([]interface{}) {
+		missing[int(number.(float64))] = true
+	}
+
+	return missing, nil
+}
+
+func (c *uploadConfig) upload_put_part(uploadId string, number int, content []byte, hash string) error {
+	var (
+		err            error
+		body           = new(bytes.Buffer)
+		request        *libHttp.Request
+		formBodyWriter = multipart.NewWriter(body)
+		formFileWriter io.Writer
+	)
+
+	params := map[string]interface{}{
+		"token":    c.token,
+		"uploadId": uploadId,
+		"number":   fmt.Sprintf("%d", number),
+		"hash":     hash,
+		"nonce":    models.RandomWithMD5(255),
+	}
+	params["sign"] = http.GetParamsSignature(params, c.secret)
+	for k, v := range params {
+		if err = formBodyWriter.WriteField(k, v.(string)); err != nil {
+			return err
+		}
+	}
+
+	if formFileWriter, err = formBodyWriter.CreateFormFile("file", "random.bytes"); err != nil {
+		return err
+	}
+	if _, err = formFileWriter.Write(content); err != nil {
+		return err
+	}
+	if err = formBodyWriter.Close(); err != nil {
+		return err
+	}
+
+	api := fmt.Sprintf("%s/%s", medeaServer, "api/medea/upload/part")
+	if request, err = libHttp.NewRequest(libHttp.MethodPost, api, body); err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", formBodyWriter.FormDataContentType())
+
+	_, err = do_request(request, c.host)
+	return err
+}
+
+func (c *uploadConfig) upload_complete(uploadId string) (*http.Response, error) {
+	return c.form_request(libHttp.MethodPost, "api/medea/upload/complete", map[string]interface{}{
+		"uploadId": uploadId,
+	})
+}
+
+type uploadPartJob struct {
+	number  int
+	content []byte
+	hash    string
+}
+
+// upload_missing_parts uploads all parts whose hash is not in received by
+// parallel workers, a failed part is retried before giving up.
+func (c *uploadConfig) upload_missing_parts(uploadId string, jobs <-chan uploadPartJob, parallel int) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				var err error
+				for retry := 0; retry < uploadPartRetryTimes; retry++ {
+					if err = c.upload_put_part(uploadId, job.number, job.content, job.hash); err == nil {
+						break
+					}
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("part %d: %s", job.number, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
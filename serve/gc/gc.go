@@ -0,0 +1,87 @@
+package gc
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/database"
+	"medea/pkg/gc"
+	"medea/pkg/log"
+
+	"github.com/jinzhu/gorm"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var (
+	category   = "gc"
+	connection *gorm.DB
+	err        error
+	logger     = log.MustNewLogger(nil)
+	before     = func(context *cli.Context) error {
+		connection, err = database.NewConnection(&config.DefaultConfig.Database)
+		return err
+	}
+)
+
+var Commands = []*cli.Command{
+	{
+		Name:      "gc:run",
+		Category:  category,
+		Usage:     "delete objects, chunks and chunk files which aren't referenced by any file or history",
+		UsageText: "gc:run [command options]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report what would be deleted",
+			},
+			&cli.DurationFlag{
+				Name:  "grace",
+				Usage: "keep what is younger than grace, it must be longer than the longest upload",
+				Value: time.Duration(config.DefaultConfig.GC.GracePeriod) * time.Second,
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "rows deleted per transaction",
+				Value: config.DefaultConfig.GC.BatchSize,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			opts := gc.Options{
+				GracePeriod: ctx.Duration("grace"),
+				BatchSize:   ctx.Int("batch-size"),
+				DryRun:      ctx.Bool("dry-run"),
+			}
+			report, err := gc.Run(connection, opts)
+			if report != nil {
+				printReport(report)
+			}
+			if err != nil {
+				return err
+			}
+			if report.LastDeleteErr != nil {
+				logger.Errorf("failed to delete %d chunk files: %s", report.DeleteErrors, report.LastDeleteErr)
+			}
+			return nil
+		},
+		Before: before,
+	},
+}
+
+func printReport(report *gc.Report) {
+	action := "deleted"
+	if report.DryRun {
+		action = "to delete"
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Item", action, "Bytes"})
+	table.Append([]string{"objects", strconv.FormatInt(report.Objects, 10), strconv.FormatInt(report.ObjectBytes, 10)})
+	table.Append([]string{"object_chunk rows", strconv.FormatInt(report.ObjectChunks, 10), ""})
+	table.Append([]string{"upload_parts rows", strconv.FormatInt(report.UploadParts, 10), ""})
+	table.Append([]string{"chunks", strconv.FormatInt(report.Chunks, 10), strconv.FormatInt(report.ChunkBytes, 10)})
+	table.Append([]string{"stray chunk files", strconv.FormatInt(report.StrayFiles, 10), strconv.FormatInt(report.StrayBytes, 10)})
+	table.Render()
+}
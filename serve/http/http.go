@@ -12,6 +12,8 @@ import (
 	"medea/pkg/config"
 	"medea/pkg/database"
 	"medea/pkg/database/migrate"
+	"medea/pkg/database/models"
+	"medea/pkg/gc"
 	"medea/pkg/http"
 	"medea/pkg/log"
 
@@ -120,6 +122,33 @@ var (
 				certFile := context.String("cert-file")
 				certKey := context.String("cert-key")
 
+				if config.DefaultConfig.Chunk.Tiering.PromoteOnRead {
+					db := database.MustNewConnection(&config.DefaultConfig.Database)
+					stop := models.StartPromoter(nil, db, func(chunkID uint64, err error) {
+						logger.Errorf("failed to promote chunk %d: %s", chunkID, err)
+					})
+					defer stop()
+				}
+
+				if config.DefaultConfig.Chunk.Pack.Enable {
+					stop := models.StartPacker(database.MustNewConnection(&config.DefaultConfig.Database))
+					defer stop()
+				}
+
+				if interval := config.DefaultConfig.HTTP.StatsInterval; interval > 0 {
+					db := database.MustNewConnection(&config.DefaultConfig.Database)
+					stop := models.StartStats(db, time.Duration(interval)*time.Second, func(err error) {
+						logger.Errorf("failed to write stats: %s", err)
+					})
+					defer stop()
+				}
+
+				if conf := config.DefaultConfig.GC; conf.Enable && conf.Interval > 0 {
+					db := database.MustNewConnection(&config.DefaultConfig.Database)
+					stop := gc.Start(db, gc.NewOptions(&conf), time.Duration(conf.Interval)*time.Second, logger)
+					defer stop()
+				}
+
 				go func() {
 					if certFile != "" && certKey != "" {
 						logger.Infof("medea https service listening on: https://%s", addr)
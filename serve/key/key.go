@@ -0,0 +1,128 @@
+package key
+
+import (
+	"errors"
+	"fmt"
+
+	"medea/pkg/config"
+	"medea/pkg/database"
+	"medea/pkg/database/models"
+	"medea/pkg/envelope"
+	"medea/pkg/log"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var (
+	category   = "key"
+	connection *gorm.DB
+	err        error
+	logger     = log.MustNewLogger(nil)
+	before     = func(context *cli.Context) error {
+		connection, err = database.NewConnection(&config.DefaultConfig.Database)
+		return err
+	}
+)
+
+var Commands = []*cli.Command{
+	{
+		Name:      "key:generate",
+		Category:  category,
+		Usage:     "generate a master key",
+		UsageText: "key:generate",
+		Action: func(ctx *cli.Context) error {
+			key, err := envelope.NewKey()
+			if err != nil {
+				return err
+			}
+			fmt.Println(envelope.EncodeKey(key))
+			return nil
+		},
+	},
+	{
+		Name:      "key:app",
+		Category:  category,
+		Usage:     "create a data key for an application, its new chunks are encrypted by it",
+		UsageText: "key:app [command options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "uid",
+				Aliases: []string{"u"},
+				Usage:   "application uid",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			app, err := models.FindAppByUID(ctx.String("uid"), connection)
+			if err != nil {
+				return err
+			}
+			dk, err := models.CreateAppDataKey(app, connection)
+			if err != nil {
+				return err
+			}
+			logger.Infof("application %s uses data key %d", app.UID, dk.ID)
+			return nil
+		},
+		Before: before,
+	},
+	{
+		Name:      "key:rotate",
+		Category:  category,
+		Usage:     "re-wrap all data keys by a new master key, chunks aren't rewritten",
+		UsageText: "key:rotate [command options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "new-key",
+				Usage: "new master key encoded by base64",
+			},
+			&cli.StringFlag{
+				Name:  "new-key-file",
+				Usage: "file of new master key",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			var (
+				masterKey    []byte
+				newMasterKey []byte
+				dataKeys     []models.DataKey
+				err          error
+			)
+
+			if masterKey, err = models.MasterKey(); err != nil {
+				return err
+			}
+
+			switch {
+			case ctx.String("new-key") != "":
+				newMasterKey, err = envelope.ParseKey(ctx.String("new-key"))
+			case ctx.String("new-key-file") != "":
+				newMasterKey, err = envelope.LoadKeyFile(ctx.String("new-key-file"))
+			default:
+				err = errors.New("new-key or new-key-file is required")
+			}
+			if err != nil {
+				return err
+			}
+
+			tx := connection.Begin()
+			if err = tx.Find(&dataKeys).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			for index := range dataKeys {
+				if err = dataKeys[index].Rewrap(masterKey, newMasterKey, tx); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("data key %d: %w", dataKeys[index].ID, err)
+				}
+			}
+			if err = tx.Commit().Error; err != nil {
+				return err
+			}
+
+			logger.Infof("%d data keys are re-wrapped, replace the master key in config by the new one", len(dataKeys))
+			return nil
+		},
+		Before: before,
+	},
+}
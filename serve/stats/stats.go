@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"medea/pkg/config"
+	"medea/pkg/database"
+	"medea/pkg/database/models"
+
+	"github.com/jinzhu/gorm"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var (
+	category   = "stats"
+	connection *gorm.DB
+	err        error
+	before     = func(context *cli.Context) error {
+		connection, err = database.NewConnection(&config.DefaultConfig.Database)
+		return err
+	}
+)
+
+var Commands = []*cli.Command{
+	{
+		Name:      "stats:report",
+		Category:  category,
+		Usage:     "report daily requests and traffic of an application or a token",
+		UsageText: "stats:report [command options]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "uid",
+				Aliases: []string{"u"},
+				Usage:   "application uid",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				Aliases: []string{"t"},
+				Usage:   "token uid, the application's stats of all tokens are reported without it",
+			},
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "first day like 2006-01-02, 6 days before to by default",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "last day like 2006-01-02, today by default",
+			},
+		},
+		Before: before,
+		Action: func(ctx *cli.Context) error {
+			var (
+				uid   = ctx.String("uid")
+				app   *models.App
+				token *models.Token
+				from  time.Time
+				to    = time.Now()
+				stats []models.DailyStat
+				err   error
+			)
+			if len(uid) == 0 {
+				return errors.New("uid is empty")
+			}
+			if value := ctx.String("to"); len(value) > 0 {
+				if to, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+					return err
+				}
+			}
+			from = to.AddDate(0, 0, -6)
+			if value := ctx.String("from"); len(value) > 0 {
+				if from, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+					return err
+				}
+			}
+
+			if app, err = models.FindAppByUIDWithTrashed(uid, connection); err != nil {
+				return err
+			}
+			if value := ctx.String("token"); len(value) > 0 {
+				if token, err = models.FindTokenByUIDWithTrashed(value, connection); err != nil {
+					return err
+				}
+				if token.AppID != app.ID {
+					return errors.New("the token doesn't belong to the application")
+				}
+			}
+			if stats, err = models.FindDailyStats(app, token, from, to, connection); err != nil {
+				return err
+			}
+
+			var requests, bytesIn, bytesOut int64
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Day", "Requests", "BytesIn", "BytesOut"})
+			for _, stat := range stats {
+				requests += stat.Requests
+				bytesIn += stat.BytesIn
+				bytesOut += stat.BytesOut
+				table.Append([]string{
+					stat.Day.Format("2006-01-02"),
+					strconv.FormatInt(stat.Requests, 10),
+					strconv.FormatInt(stat.BytesIn, 10),
+					strconv.FormatInt(stat.BytesOut, 10),
+				})
+			}
+			table.SetFooter([]string{
+				"Total",
+				strconv.FormatInt(requests, 10),
+				strconv.FormatInt(bytesIn, 10),
+				strconv.FormatInt(bytesOut, 10),
+			})
+			table.Render()
+			return nil
+		},
+	},
+}
@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"medea/pkg/compact"
+	"medea/pkg/config"
+	"medea/pkg/database"
+	"medea/pkg/fsck"
+	"medea/pkg/log"
+	"medea/pkg/rebalance"
+	"medea/pkg/refcount"
+	"medea/pkg/tier"
+
+	"github.com/jinzhu/gorm"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var (
+	category   = "storage"
+	connection *gorm.DB
+	err        error
+	logger     = log.MustNewLogger(nil)
+	before     = func(context *cli.Context) error {
+		connection, err = database.NewConnection(&config.DefaultConfig.Database)
+		return err
+	}
+)
+
+var Commands = []*cli.Command{
+	{
+		Name:      "storage:fsck",
+		Category:  category,
+		Usage:     "check chunk files against chunks, objects against their chunks, and find stray chunk files",
+		UsageText: "storage:fsck [command options]",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "workers",
+				Usage: "number of chunks or objects checked in parallel",
+				Value: 4,
+			},
+			&cli.Int64Flag{
+				Name:  "rate-limit",
+				Usage: "bytes read per second, 0 means unlimited",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "rows loaded per query",
+				Value: 500,
+			},
+			&cli.BoolFlag{
+				Name:  "quarantine",
+				Usage: "move corrupted and truncated chunk files under " + fsck.QuarantinePrefix,
+			},
+			&cli.BoolFlag{
+				Name:  "skip-object-hash",
+				Usage: "don't replay chunks to verify hashes of objects",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "output the report as json",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			report, err := fsck.Run(connection, fsck.Options{
+				Workers:    ctx.Int("workers"),
+				BatchSize:  ctx.Int("batch-size"),
+				RateLimit:  ctx.Int64("rate-limit"),
+				Quarantine: ctx.Bool("quarantine"),
+				SkipHash:   ctx.Bool("skip-object-hash"),
+			})
+			if report != nil {
+				if ctx.Bool("json") {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					if err := encoder.Encode(report); err != nil {
+						return err
+					}
+				} else {
+					printFsckReport(report)
+				}
+			}
+			return err
+		},
+		Before: before,
+	},
+	{
+		Name:      "storage:rebalance",
+		Category:  category,
+		Usage:     "move chunk replicas to the roots chosen by placement after roots are added, reweighted or drained",
+		UsageText: "storage:rebalance [command options]",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "drain",
+				Usage: "name of root to move all replicas off, besides the roots drained by config",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report what would be moved",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "rows loaded per query",
+				Value: 500,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			report, err := rebalance.Run(connection, rebalance.Options{
+				BatchSize: ctx.Int("batch-size"),
+				DryRun:    ctx.Bool("dry-run"),
+				Drain:     ctx.StringSlice("drain"),
+			})
+			if report != nil {
+				printRebalanceReport(report)
+			}
+			return err
+		},
+		Before: before,
+	},
+	{
+		Name:      "storage:tier",
+		Category:  category,
+		Usage:     "move chunks of objects matching the tiering policy from hot roots to cold roots",
+		UsageText: "storage:tier [command options]",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "idle-days",
+				Usage: "move objects not read for days, the configured one is used by default",
+				Value: config.DefaultConfig.Chunk.Tiering.IdleDays,
+			},
+			&cli.StringSliceFlag{
+				Name:  "prefix",
+				Usage: "move files under path prefix given as appUid:/path, the configured ones are used by default",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report what would be moved",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "rows loaded per query",
+				Value: 500,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			opts := tier.NewOptions(&config.DefaultConfig.Chunk.Tiering)
+			opts.IdleDays = ctx.Int("idle-days")
+			if prefixes := ctx.StringSlice("prefix"); len(prefixes) > 0 {
+				opts.PathPrefixes = prefixes
+			}
+			opts.BatchSize = ctx.Int("batch-size")
+			opts.DryRun = ctx.Bool("dry-run")
+
+			report, err := tier.Run(connection, opts)
+			if report != nil {
+				printTierReport(report)
+			}
+			return err
+		},
+		Before: before,
+	},
+	{
+		Name:      "storage:compact",
+		Category:  category,
+		Usage:     "rewrite closed packs which are mostly dead and delete retired packs",
+		UsageText: "storage:compact [command options]",
+		Flags: []cli.Flag{
+			&cli.Float64Flag{
+				Name:  "dead-ratio",
+				Usage: "rewrite a pack if at least this ratio of it is dead",
+				Value: 0.5,
+			},
+			&cli.DurationFlag{
+				Name:  "grace",
+				Usage: "delete retired packs older than grace, they may still be read meanwhile",
+				Value: time.Hour,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report what would be rewritten and deleted",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "rows loaded per query",
+				Value: 500,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			report, err := compact.Run(connection, compact.Options{
+				DeadRatio: ctx.Float64("dead-ratio"),
+				Grace:     ctx.Duration("grace"),
+				BatchSize: ctx.Int("batch-size"),
+				DryRun:    ctx.Bool("dry-run"),
+			})
+			if report != nil {
+				printCompactReport(report)
+			}
+			return err
+		},
+		Before: before,
+	},
+	{
+		Name:      "storage:refcount",
+		Category:  category,
+		Usage:     "recompute reference counts of objects and chunks from files, histories, object chunks and upload parts",
+		UsageText: "storage:refcount [command options]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the counts which are wrong",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "rows locked and recomputed per transaction",
+				Value: 500,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			report, err := refcount.Repair(connection, refcount.Options{
+				BatchSize: ctx.Int("batch-size"),
+				DryRun:    ctx.Bool("dry-run"),
+			})
+			if report != nil {
+				action := "fixed"
+				if report.DryRun {
+					action = "wrong"
+				}
+				logger.Infof(
+					"checked %d objects and %d chunks, counts of %d objects and %d chunks %s",
+					report.Objects, report.Chunks, report.FixedObjects, report.FixedChunks, action,
+				)
+			}
+			return err
+		},
+		Before: before,
+	},
+}
+
+func printFsckReport(report *fsck.Report) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "ChunkID", "ObjectID", "Key", "Detail", "Quarantined"})
+	for _, issue := range report.Issues {
+		table.Append([]string{
+			issue.Kind,
+			strconv.FormatUint(issue.ChunkID, 10),
+			strconv.FormatUint(issue.ObjectID, 10),
+			issue.Key,
+			issue.Detail,
+			strconv.FormatBool(issue.Quarantined),
+		})
+	}
+	table.Render()
+
+	logger.Infof(
+		"checked %d chunks, %d objects and %d chunk files, read %d bytes, found %d issues",
+		report.Chunks, report.Objects, report.StoredFiles, report.BytesRead, len(report.Issues),
+	)
+}
+
+func printRebalanceReport(report *rebalance.Report) {
+	if len(report.Failures) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ChunkID", "Failure"})
+		for _, failure := range report.Failures {
+			table.Append([]string{strconv.FormatUint(failure.ChunkID, 10), failure.Detail})
+		}
+		table.Render()
+	}
+
+	action := "moved"
+	if report.DryRun {
+		action = "to move"
+	}
+	logger.Infof(
+		"checked %d chunks, %d %s, %d copies (%d bytes) made, %d replicas (%d bytes) removed, %d failed",
+		report.Chunks, report.Moved, action, report.Copies, report.CopiedBytes,
+		report.Removed, report.RemovedBytes, len(report.Failures),
+	)
+}
+
+func printTierReport(report *tier.Report) {
+	if len(report.Failures) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ChunkID", "Failure"})
+		for _, failure := range report.Failures {
+			table.Append([]string{strconv.FormatUint(failure.ChunkID, 10), failure.Detail})
+		}
+		table.Render()
+	}
+
+	action := "moved"
+	if report.DryRun {
+		action = "to move"
+	}
+	logger.Infof(
+		"%d objects match, %d chunks (%d bytes) %s, %d shared chunks kept hot, %d failed",
+		report.Objects, report.Chunks, report.MovedBytes, action, report.Skipped, len(report.Failures),
+	)
+}
+
+func printCompactReport(report *compact.Report) {
+	if len(report.Failures) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"PackID", "ChunkID", "Failure"})
+		for _, failure := range report.Failures {
+			table.Append([]string{
+				strconv.FormatUint(failure.PackID, 10),
+				strconv.FormatUint(failure.ChunkID, 10),
+				failure.Detail,
+			})
+		}
+		table.Render()
+	}
+
+	action := "rewritten"
+	if report.DryRun {
+		action = "to rewrite"
+	}
+	logger.Infof(
+		"checked %d packs, %d %s by copying %d chunks (%d bytes), %d retired packs (%d bytes) deleted, %d failed",
+		report.Packs, report.Compacted, action, report.Chunks, report.CopiedBytes,
+		report.Deleted, report.ReclaimedBytes, len(report.Failures),
+	)
+}